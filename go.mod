@@ -3,17 +3,18 @@ module github.com/benoitkugler/go-weasyprint
 go 1.17
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/benoitkugler/pdf v0.0.7
 	github.com/benoitkugler/textprocessing v0.0.3
 	github.com/benoitkugler/webrender v0.0.9
 	github.com/go-text/typesetting v0.1.0
+	golang.org/x/image v0.13.0
 )
 
 require (
 	github.com/benoitkugler/pstokenizer v1.0.1 // indirect
 	github.com/benoitkugler/textlayout v0.3.0 // indirect
 	github.com/hhrutter/lzw v1.0.0 // indirect
-	golang.org/x/image v0.13.0 // indirect
 	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 )
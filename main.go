@@ -1,9 +1,11 @@
 package goweasyprint
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/benoitkugler/go-weasyprint/pdf"
+	"github.com/benoitkugler/pdf/model"
 	"github.com/benoitkugler/webrender/backend"
 	"github.com/benoitkugler/webrender/html/document"
 	"github.com/benoitkugler/webrender/html/tree"
@@ -17,7 +19,7 @@ import (
 //   - `tree.NewHTML` parses the input files (HTML and CSS)
 //   - `document.Render` layout the document, creating an intermediate representation ...
 //   - ... which is transformed into an in-memory PDF by `document.WriteDocument`, using the `pdf.Ouput` backend.
-//   - model.Write eventually serialize the PDF into `target`
+//   - `pdf.Output.Write` eventually serialize the PDF into `target`
 //
 // See `HtmlToPdfOptions` for more options.
 func HtmlToPdf(target io.Writer, htmlContent utils.ContentInput, fontConfig text.FontConfiguration) error {
@@ -42,6 +44,72 @@ func HtmlToPdfOptions(target io.Writer, htmlContent utils.ContentInput, baseUrl
 	doc := document.Render(parsedHtml, stylesheets, presentationalHints, fontConfig)
 	output := pdf.NewOutput()
 	doc.Write(output, utils.Fl(zoom), attachments)
-	pdfDoc := output.Finalize()
-	return pdfDoc.Write(target, nil)
+	return output.Write(target)
+}
+
+// HtmlFragment is an HTML document rendered in a region of
+// a page of an existing PDF file, see [HtmlOntoPdf].
+type HtmlFragment struct {
+	Content utils.ContentInput
+	// Page is the index of the page in the existing file (starting at 0)
+	Page int
+	// Region is the area covered by the fragment, in PDF coordinates
+	// (points, with y growing upward). If empty, the whole page is used.
+	Region model.Rectangle
+}
+
+// HtmlOntoPdf renders the HTML fragments on the pages of the PDF file `base`, and writes
+// the result in `target`.
+// Each fragment is laid out on a page with the size of its region (and without margins),
+// unless its stylesheet defines another @page size. Only the first page of each fragment is used.
+// The fragments share the same fonts and images.
+func HtmlOntoPdf(target io.Writer, base io.ReadSeeker, fragments []HtmlFragment, baseUrl string, urlFetcher utils.UrlFetcher, fontConfig text.FontConfiguration) error {
+	output := pdf.NewOutput()
+	var stamps []pdf.Stamp
+	for _, fragment := range fragments {
+		parsedHtml, err := tree.NewHTML(fragment.Content, baseUrl, urlFetcher, "")
+		if err != nil {
+			return err
+		}
+		var stylesheets []tree.CSS
+		if w, h := fragment.Region.Width(), fragment.Region.Height(); w > 0 && h > 0 {
+			pageSize, err := tree.NewCSSDefault(utils.InputString(fmt.Sprintf("@page { size: %gpt %gpt; margin: 0 }", w, h)))
+			if err != nil {
+				return err
+			}
+			stylesheets = append(stylesheets, pageSize)
+		}
+		doc := document.Render(parsedHtml, stylesheets, false, fontConfig)
+		if len(doc.Pages) == 0 {
+			continue
+		}
+		stamps = append(stamps, pdf.Stamp{Source: output.PageCount(), Page: fragment.Page, Region: fragment.Region})
+		doc.Write(fragmentOutput{output}, 1, nil)
+	}
+	return output.WriteOnto(base, stamps, target)
+}
+
+// fragmentOutput renders a fragment after the pages of the previous ones.
+// The anchors and bookmarks use page indices relative to the fragment, and
+// are not copied by [pdf.Output.WriteOnto]: they are ignored.
+type fragmentOutput struct {
+	*pdf.Output
+}
+
+func (fragmentOutput) CreateAnchors([][]backend.Anchor) {}
+
+func (fragmentOutput) SetBookmarks([]backend.BookmarkNode) {}
+
+// HtmlAppendToPdf renders the HTML document and appends its pages to the PDF file `original`,
+// using an incremental update: the original bytes are preserved, so that existing
+// digital signatures stay valid. See `HtmlToPdfOptions` for the other parameters.
+func HtmlAppendToPdf(target io.Writer, original []byte, htmlContent utils.ContentInput, baseUrl string, urlFetcher utils.UrlFetcher, fontConfig text.FontConfiguration) error {
+	parsedHtml, err := tree.NewHTML(htmlContent, baseUrl, urlFetcher, "")
+	if err != nil {
+		return err
+	}
+	doc := document.Render(parsedHtml, nil, false, fontConfig)
+	output := pdf.NewOutput()
+	doc.Write(output, 1, nil)
+	return output.AppendTo(original, target)
 }
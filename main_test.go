@@ -1,15 +1,20 @@
 package goweasyprint
 
 import (
+	"bytes"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/benoitkugler/go-weasyprint/pdf"
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader"
 	"github.com/benoitkugler/pdf/reader/file"
 	fc "github.com/benoitkugler/textprocessing/fontconfig"
 	"github.com/benoitkugler/textprocessing/pango/fcfonts"
+	"github.com/benoitkugler/webrender/backend"
 	"github.com/benoitkugler/webrender/logger"
 	"github.com/benoitkugler/webrender/text"
 	"github.com/benoitkugler/webrender/utils"
@@ -184,3 +189,86 @@ func TestSVGGradient(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestHtmlOntoPdf(t *testing.T) {
+	var base bytes.Buffer
+	err := HtmlToPdf(&base, utils.InputString(`<style>@page { size: A4 }</style><p>Form</p><p style="break-before: page">Second page</p>`), fontconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = HtmlOntoPdf(&out, bytes.NewReader(base.Bytes()), []HtmlFragment{
+		{Content: utils.InputString(`<p id="name" style="bookmark-level: 1">John Doe</p>`), Page: 1, Region: model.Rectangle{Llx: 100, Lly: 700, Urx: 300, Ury: 720}},
+		{Content: utils.InputString(`<p id="city" style="bookmark-level: 1">Paris</p>`), Page: 1, Region: model.Rectangle{Llx: 100, Lly: 600, Urx: 300, Ury: 620}},
+	}, "", nil, fontconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, _, err := reader.ParsePDFReader(bytes.NewReader(out.Bytes()), reader.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := doc.Catalog.Pages.Flatten()
+	if len(pages) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(pages))
+	}
+	if L := len(pages[0].Contents); L != 1 {
+		t.Fatalf("first page should not be modified, got %d streams", L)
+	}
+	content, err := pages[1].DecodeAllContents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(content, []byte("/Stamp Do\n")) {
+		t.Fatalf("unexpected content %s", content)
+	}
+	stamps := pages[1].Resources.XObject["Stamp"].(*model.XObjectForm)
+	if content, err = stamps.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []string{"1 0 0 1 100 700 cm", "1 0 0 1 100 600 cm"} {
+		if !bytes.Contains(content, []byte(op)) {
+			t.Fatalf("missing %q in %s", op, content)
+		}
+	}
+
+	// the anchors of a fragment use indices relative to the fragment
+	output := pdf.NewOutput()
+	output.AddPage(0, 0, 10, 10)
+	fragmentOutput{output}.CreateAnchors([][]backend.Anchor{{{Name: "city"}}})
+	fragmentOutput{output}.SetBookmarks([]backend.BookmarkNode{{Label: "Paris"}})
+	rendered, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rendered.Catalog.Names.Dests.Names) != 0 || rendered.Catalog.Outlines != nil {
+		t.Fatal("the anchors and bookmarks of fragments should be ignored")
+	}
+}
+
+func TestHtmlAppendToPdf(t *testing.T) {
+	var base bytes.Buffer
+	if err := HtmlToPdf(&base, utils.InputString(`<p>Signed contract</p>`), fontconfig); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err := HtmlAppendToPdf(&out, base.Bytes(), utils.InputString(`<title>Appendix</title><p>Appendix</p>`), "", nil, fontconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out.Bytes(), base.Bytes()) {
+		t.Fatal("the original bytes should be preserved")
+	}
+	doc, _, err := reader.ParsePDFReader(bytes.NewReader(out.Bytes()), reader.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(doc.Catalog.Pages.Flatten()); n != 2 {
+		t.Fatalf("expected 2 pages, got %d", n)
+	}
+	if doc.Trailer.Info.Title != "Appendix" {
+		t.Fatalf("unexpected metadata %v", doc.Trailer.Info)
+	}
+}
@@ -0,0 +1,334 @@
+package pdf
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"log"
+	"math"
+
+	cs "github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+// OutputIntent describes the output device the document is intended for,
+// using an ICC profile (such as FOGRA39 or GRACoL for print).
+//
+// When the profile describes a CMYK device, the colors, gradients and
+// raster images are converted from sRGB to DeviceCMYK using the BToA table
+// of the profile (for the rendering intent of its header, or the perceptual one),
+// so that the separations match the output condition.
+// Profiles without usable BToA table fall back, with a warning,
+// to the naive device formula (K = 1 - max(R, G, B)).
+//
+// Note that an output intent alone does not make the file conform
+// to PDF/X or PDF/A, which have other requirements (like embedding every font).
+type OutputIntent struct {
+	// Profile is the content of the ICC profile, describing
+	// an RGB or CMYK output device.
+	Profile []byte
+
+	// OutputConditionIdentifier identifies the output condition,
+	// such as "FOGRA39" or "CGATS TR 006".
+	OutputConditionIdentifier string
+	// OutputCondition is an optional human readable description
+	OutputCondition string
+	// RegistryName is an optional URL of the registry defining
+	// the identifier, such as "http://www.color.org"
+	RegistryName string
+
+	// Subtype is required, and written as is,
+	// for instance GTS_PDFX or GTS_PDFA1.
+	Subtype string
+}
+
+// isCMYK returns true if the profile color space is CMYK,
+// using the data color space field of the ICC header
+func (oi *OutputIntent) isCMYK() bool {
+	return len(oi.Profile) >= 20 && string(oi.Profile[16:20]) == "CMYK"
+}
+
+func (oi *OutputIntent) pdfObject(raw *rawPDF) model.ObjDict {
+	n := 3
+	if oi.isCMYK() {
+		n = 4
+	}
+	profile := model.NewCompressedStream(oi.Profile)
+	args := model.ObjDict{"N": model.ObjInt(n), "Length": model.ObjInt(len(profile.Content))}
+	if len(profile.Filter) != 0 {
+		args["Filter"] = model.ObjName(profile.Filter[0].Name)
+	}
+	out := model.ObjDict{
+		"Type":                      model.ObjName("OutputIntent"),
+		"S":                         model.ObjName(oi.Subtype),
+		"OutputConditionIdentifier": model.ObjStringLiteral(oi.OutputConditionIdentifier),
+		"DestOutputProfile":         raw.add(model.ObjStream{Args: args, Content: profile.Content}),
+	}
+	if oi.OutputCondition != "" {
+		out["OutputCondition"] = model.ObjStringLiteral(oi.OutputCondition)
+	}
+	if oi.RegistryName != "" {
+		out["RegistryName"] = model.ObjStringLiteral(oi.RegistryName)
+	}
+	return out
+}
+
+// cmykOutput returns true if the colors must be converted to CMYK
+func (c cache) cmykOutput() bool {
+	oi := c.options.OutputIntent
+	return oi != nil && oi.isCMYK() && c.grayOutput() == nil
+}
+
+// blendingSpace returns the color space of the transparency groups
+func (c cache) blendingSpace() model.ColorSpace {
+	if c.grayOutput() != nil {
+		return model.ColorSpaceGray
+	}
+	if c.cmykOutput() {
+		return model.ColorSpaceCMYK
+	}
+	return model.ColorSpaceRGB
+}
+
+// components returns the components of [color] in the blending space
+func (c cache) components(color parser.RGBA) []fl {
+	if gr := c.grayOutput(); gr != nil {
+		return []fl{gr.level(color.R, color.G, color.B)}
+	}
+	if c.cmykOutput() {
+		out := c.rgbToCMYK(color.R, color.G, color.B)
+		return out[:]
+	}
+	return []fl{color.R, color.G, color.B}
+}
+
+// outputProfile returns the BToA transform of the CMYK output intent,
+// or nil if it can't be used
+func (c cache) outputProfile() *iccLut {
+	oi := c.options.OutputIntent
+	if lut, has := c.outputProfiles[oi]; has {
+		return lut
+	}
+	lut, err := parseOutputProfile(oi.Profile)
+	if err == nil && lut.nbOutputs != 4 {
+		err = fmt.Errorf("expected 4 output channels, got %d", lut.nbOutputs)
+	}
+	if err != nil {
+		log.Printf("invalid output intent profile (%s): using the device CMYK conversion", err)
+		lut = nil
+	}
+	c.outputProfiles[oi] = lut
+	return lut
+}
+
+// rgbToCMYK converts the sRGB color to CMYK, using the profile
+// of the output intent (see [OutputIntent])
+func (c cache) rgbToCMYK(r, g, b fl) [4]fl {
+	lut := c.outputProfile()
+	if lut == nil {
+		return deviceCMYK(r, g, b)
+	}
+	cmyk := lut.eval(xyzToLab(srgbToXYZ(parser.RGBA{R: r, G: g, B: b})))
+	return [4]fl{fl(cmyk[0]), fl(cmyk[1]), fl(cmyk[2]), fl(cmyk[3])}
+}
+
+// deviceCMYK uses the naive device conversion,
+// without color management
+func deviceCMYK(r, g, b fl) [4]fl {
+	k := 1 - fl(math.Max(float64(r), math.Max(float64(g), float64(b))))
+	if k >= 1 {
+		return [4]fl{0, 0, 0, 1}
+	}
+	return [4]fl{(1 - r - k) / (1 - k), (1 - g - k) / (1 - k), (1 - b - k) / (1 - k), k}
+}
+
+// sameRGB returns true if [c1] and [c2] have the same components,
+// ignoring their opacity
+func sameRGB(c1, c2 parser.RGBA) bool {
+	return c1.R == c2.R && c1.G == c2.G && c1.B == c2.B
+}
+
+// logSharedColor logs, once per color, that [color], replaced by a [kind],
+// is an 8-bit value, which may also be used by unrelated elements
+func (c cache) logSharedColor(color parser.RGBA, kind string) {
+	key := [3]fl{color.R, color.G, color.B}
+	if c.sharedColors[key] {
+		return
+	}
+	c.sharedColors[key] = true
+	var bytes [3]uint8
+	for i, v := range key {
+		b := math.Round(float64(v) * 255)
+		if math.Abs(float64(v)*255-b) > 1e-3 {
+			return // not an 8-bit color
+		}
+		bytes[i] = uint8(b)
+	}
+	log.Printf("every use of the color #%02x%02x%02x is replaced by a %s", bytes[0], bytes[1], bytes[2], kind)
+}
+
+// writeColor writes the color operation for [color], ignoring its opacity
+func (g *group) writeColor(color parser.RGBA, stroke bool) {
+	if g.writeGrayColor(color, stroke) {
+		return
+	}
+	if g.writeSpotColor(color, stroke) {
+		return
+	}
+	if !g.cmykOutput() && g.writeWideGamutColor(color, stroke) {
+		return
+	}
+	if g.cmykOutput() {
+		c := g.rgbToCMYK(color.R, color.G, color.B)
+		if stroke {
+			g.app.Ops(cs.OpSetStrokeCMYKColor{C: c[0], M: c[1], Y: c[2], K: c[3]})
+		} else {
+			g.app.Ops(cs.OpSetFillCMYKColor{C: c[0], M: c[1], Y: c[2], K: c[3]})
+		}
+		return
+	}
+	if len(g.options.SpotColors) != 0 || len(g.options.WideGamutColors) != 0 {
+		// the color cache of GraphicStream is not aware of the other color spaces
+		if stroke {
+			g.app.Ops(cs.OpSetStrokeRGBColor{R: color.R, G: color.G, B: color.B})
+		} else {
+			g.app.Ops(cs.OpSetFillRGBColor{R: color.R, G: color.G, B: color.B})
+		}
+		return
+	}
+	if stroke {
+		g.app.SetColorStroke(color)
+	} else {
+		g.app.SetColorFill(color)
+	}
+}
+
+// convertShading converts the RGB shading [sh] to the output color space
+func (c cache) convertShading(sh *model.ShadingDict) {
+	if gr := c.grayOutput(); gr != nil && sh.ColorSpace == model.ColorSpaceRGB {
+		sh.ColorSpace = model.ColorSpaceGray
+		switch st := sh.ShadingType.(type) {
+		case model.ShadingAxial:
+			gr.grayFunctions(st.Function)
+		case model.ShadingRadial:
+			gr.grayFunctions(st.Function)
+		}
+		return
+	}
+	if !c.cmykOutput() || sh.ColorSpace != model.ColorSpaceRGB {
+		return
+	}
+	sh.ColorSpace = model.ColorSpaceCMYK
+	switch st := sh.ShadingType.(type) {
+	case model.ShadingAxial:
+		c.convertFunctions(st.Function)
+	case model.ShadingRadial:
+		c.convertFunctions(st.Function)
+	}
+}
+
+// convertFunctions converts the RGB outputs of [fns] to CMYK,
+// interpolating between the converted colors.
+func (c cache) convertFunctions(fns []model.FunctionDict) {
+	for i := range fns {
+		switch ft := fns[i].FunctionType.(type) {
+		case model.FunctionExpInterpolation:
+			if len(ft.C0) == 3 && len(ft.C1) == 3 {
+				c0, c1 := c.rgbToCMYK(ft.C0[0], ft.C0[1], ft.C0[2]), c.rgbToCMYK(ft.C1[0], ft.C1[1], ft.C1[2])
+				ft.C0, ft.C1 = c0[:], c1[:]
+				fns[i].FunctionType = ft
+			}
+		case model.FunctionStitching:
+			c.convertFunctions(ft.Functions)
+		}
+		if len(fns[i].Range) == 3 {
+			fns[i].Range = []model.Range{{0, 1}, {0, 1}, {0, 1}, {0, 1}}
+		}
+	}
+}
+
+// convertVertexColor converts the RGB color of a mesh vertex
+func (c cache) convertVertexColor(color []fl) []fl {
+	if gr := c.grayOutput(); gr != nil && len(color) == 3 {
+		return []fl{gr.gray(color[0], color[1], color[2])}
+	}
+	if !c.cmykOutput() || len(color) != 3 {
+		return color
+	}
+	out := c.rgbToCMYK(color[0], color[1], color[2])
+	return out[:]
+}
+
+// isRGBImage returns false for the images whose
+// color space is supported with a CMYK output intent
+func isRGBImage(img image.Image) bool {
+	switch img.(type) {
+	case nil, *image.Gray, *image.Gray16, *image.CMYK:
+		return false
+	default:
+		return true
+	}
+}
+
+// imageToCMYK returns a DeviceCMYK image, with a soft mask if [img] is not opaque
+func (c cache) imageToCMYK(img image.Image) *model.XObjectImage {
+	var (
+		pix           []byte
+		stride        int
+		premultiplied bool
+	)
+	switch img := img.(type) {
+	case *image.RGBA:
+		pix, stride, premultiplied = img.Pix, img.Stride, true
+	case *image.NRGBA:
+		pix, stride = img.Pix, img.Stride
+	default:
+		nrgba := image.NewNRGBA(img.Bounds())
+		draw.Draw(nrgba, nrgba.Rect, img, img.Bounds().Min, draw.Src)
+		pix, stride = nrgba.Pix, nrgba.Stride
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	colors, alpha := make([]byte, 0, 4*w*h), make([]byte, 0, w*h)
+	opaque := true
+	// images usually have few distinct colors, compared to their size
+	converted := make(map[[3]uint8][4]uint8)
+	for y := 0; y < h; y++ {
+		row := pix[y*stride : y*stride+4*w]
+		for i := 0; i < len(row); i += 4 {
+			rgb, a := [3]uint8{row[i], row[i+1], row[i+2]}, row[i+3]
+			if premultiplied && a != 0 && a != 0xff {
+				for k, v := range rgb {
+					rgb[k] = uint8((uint16(v)*0xff + uint16(a)/2) / uint16(a))
+				}
+			}
+			cmyk, has := converted[rgb]
+			if !has {
+				cf := c.rgbToCMYK(fl(rgb[0])/255, fl(rgb[1])/255, fl(rgb[2])/255)
+				cmyk = [4]uint8{uint8(cf[0]*255 + 0.5), uint8(cf[1]*255 + 0.5), uint8(cf[2]*255 + 0.5), uint8(cf[3]*255 + 0.5)}
+				converted[rgb] = cmyk
+			}
+			colors = append(colors, cmyk[:]...)
+			alpha = append(alpha, a)
+			opaque = opaque && a == 0xff
+		}
+	}
+	out := &model.XObjectImage{
+		Image: model.Image{
+			Stream:           model.NewCompressedStream(colors),
+			BitsPerComponent: 8,
+			Width:            w,
+			Height:           h,
+		},
+		ColorSpace: model.ColorSpaceCMYK,
+	}
+	if !opaque {
+		out.SMask = &model.ImageSMask{Image: model.Image{
+			Stream:           model.NewCompressedStream(alpha),
+			BitsPerComponent: 8,
+			Width:            w,
+			Height:           h,
+		}}
+	}
+	return out
+}
@@ -0,0 +1,238 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader/file"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+func TestDeviceCMYK(t *testing.T) {
+	for _, test := range []struct {
+		r, g, b fl
+		cmyk    [4]fl
+	}{
+		{0, 0, 0, [4]fl{0, 0, 0, 1}},
+		{1, 1, 1, [4]fl{0, 0, 0, 0}},
+		{1, 0, 0, [4]fl{0, 1, 1, 0}},
+		{0, 0.5, 0.5, [4]fl{1, 0, 0, 0.5}},
+	} {
+		if got := deviceCMYK(test.r, test.g, test.b); got != test.cmyk {
+			t.Fatalf("expected %v, got %v", test.cmyk, got)
+		}
+	}
+}
+
+// fakeProfile returns the header of an ICC profile
+func fakeProfile(space string) []byte {
+	out := make([]byte, 128)
+	copy(out[12:], "prtr")
+	copy(out[16:], space)
+	copy(out[36:], "acsp")
+	return out
+}
+
+func TestOutputIntent(t *testing.T) {
+	output := NewOutput()
+	output.Options.OutputIntent = &OutputIntent{
+		Profile:                   fakeProfile("CMYK"),
+		OutputConditionIdentifier: "FOGRA39",
+		RegistryName:              "http://www.color.org",
+	}
+	page := output.AddPage(0, 0, 100, 100)
+	page.State().SetColorRgba(parser.RGBA{R: 1, A: 1}, false)
+	page.Rectangle(0, 0, 10, 10)
+	page.Paint(backend.FillNonZero)
+	page.DrawGradient(backend.GradientLayout{
+		ScaleY:       1,
+		GradientKind: backend.GradientKind{Kind: "linear", Coords: [6]fl{0, 0, 100, 0}},
+		Positions:    []fl{0, 1},
+		Colors:       []parser.RGBA{{R: 1, A: 1}, {B: 1, A: 1}},
+	}, 100, 100)
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.NRGBA{G: 255, A: 128})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	page.DrawRasterImage(backend.RasterImage{Content: &buf, MimeType: "image/png", ID: 1}, 10, 10)
+
+	var out bytes.Buffer
+	if err := output.Write(&out); err == nil {
+		t.Fatal("expected error for missing subtype")
+	}
+	output.Options.OutputIntent.Subtype = "GTS_PDFX"
+	out.Reset()
+	if err := output.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	f, err := file.Read(bytes.NewReader(out.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog := f.ResolveObject(f.Root).(model.ObjDict)
+	intents, _ := f.ResolveObject(catalog["OutputIntents"]).(model.ObjArray)
+	if len(intents) != 1 {
+		t.Fatalf("expected one output intent, got %v", catalog)
+	}
+	intent := f.ResolveObject(intents[0]).(model.ObjDict)
+	if intent["S"] != model.ObjName("GTS_PDFX") || intent["OutputConditionIdentifier"] != model.ObjStringLiteral("FOGRA39") {
+		t.Fatalf("unexpected output intent %v", intent)
+	}
+	profile := f.ResolveObject(intent["DestOutputProfile"]).(model.ObjStream)
+	if profile.Args["N"] != model.ObjInt(4) {
+		t.Fatalf("unexpected profile %v", profile.Args)
+	}
+
+	// colors are converted
+	var nbCMYKImages int
+	for _, o := range f.XrefTable {
+		stream, ok := o.(model.ObjStream)
+		if !ok {
+			continue
+		}
+		if stream.Args["Subtype"] == model.ObjName("Image") && stream.Args["ColorSpace"] == model.ObjName("DeviceCMYK") {
+			nbCMYKImages++
+		}
+		if stream.Args["ShadingType"] != nil && stream.Args["ColorSpace"] != model.ObjName("DeviceCMYK") {
+			t.Fatalf("unexpected shading %v", stream.Args)
+		}
+	}
+	if nbCMYKImages != 1 {
+		t.Fatalf("expected one CMYK image, got %d", nbCMYKImages)
+	}
+	if !strings.Contains(out.String(), "/ColorSpace /DeviceCMYK") {
+		t.Fatal("expected a CMYK shading")
+	}
+	var content []byte
+	for _, o := range f.XrefTable {
+		if page, ok := o.(model.ObjDict); ok && page["Type"] == model.ObjName("Page") {
+			contents := f.ResolveObject(page["Contents"]).(model.ObjArray)
+			stream := f.ResolveObject(contents[0]).(model.ObjStream)
+			r, err := zlib.NewReader(bytes.NewReader(stream.Content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ = io.ReadAll(r)
+		}
+	}
+	if !strings.Contains(string(content), "0 1 1 0 k") || strings.Contains(string(content), " rg") {
+		t.Fatalf("expected CMYK colors, got %s", content)
+	}
+}
+
+// cmykProfile returns a CMYK output profile with Lab PCS, whose
+// BToA0 tag is [lut]
+func cmykProfile(lut []byte) []byte {
+	out := fakeProfile("CMYK")
+	copy(out[20:], "Lab ")
+	out = appendUint32(out, 1) // tag count
+	out = append(out, "B2A0"...)
+	out = appendUint32(out, 144)
+	out = appendUint32(out, uint32(len(lut)))
+	return append(out, lut...)
+}
+
+// testCLUT returns the values of a 2x2x2 CLUT, linear in each input,
+// so that the interpolation is exact: C = 1 - L, M = a, Y = b, K = 0.25
+func testCLUT() (out []float64) {
+	for l := 0; l < 2; l++ {
+		for a := 0; a < 2; a++ {
+			for b := 0; b < 2; b++ {
+				out = append(out, 1-float64(l), float64(a), float64(b), 0.25)
+			}
+		}
+	}
+	return out
+}
+
+func TestOutputProfile(t *testing.T) {
+	// version 2 lut16, with identity tables
+	lut16 := []byte("mft2\x00\x00\x00\x00\x03\x04\x02\x00")
+	for i := 0; i < 9; i++ {
+		lut16 = appendUint32(lut16, 0)
+	}
+	lut16 = appendUint16(lut16, 2)
+	lut16 = appendUint16(lut16, 2)
+	for i := 0; i < 3; i++ {
+		lut16 = appendUint16(appendUint16(lut16, 0), 0xffff)
+	}
+	for _, v := range testCLUT() {
+		lut16 = appendUint16(lut16, uint16(v*0xffff+0.5))
+	}
+	for i := 0; i < 4; i++ {
+		lut16 = appendUint16(appendUint16(lut16, 0), 0xffff)
+	}
+
+	// version 4 lutBToA, with identity B curves and squaring A curves
+	mBA := []byte("mBA \x00\x00\x00\x00\x03\x04\x00\x00")
+	for _, offset := range []uint32{32, 0, 0, 68, 120} {
+		mBA = appendUint32(mBA, offset)
+	}
+	for i := 0; i < 3; i++ { // B curves
+		mBA = appendUint32(append(mBA, "curv\x00\x00\x00\x00"...), 0)
+	}
+	mBA = append(mBA, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0) // CLUT
+	for _, v := range testCLUT() {
+		mBA = append(mBA, uint8(v*0xff+0.5))
+	}
+	for i := 0; i < 4; i++ { // A curves
+		mBA = appendUint16(appendUint16(append(mBA, "para\x00\x00\x00\x00"...), 0), 0)
+		mBA = appendUint32(mBA, 2<<16) // gamma
+	}
+
+	red := xyzToLab(srgbToXYZ(parser.RGBA{R: 1, A: 1}))
+	for _, test := range []struct {
+		lut      []byte
+		expected [4]float64
+	}{
+		{lut16, [4]float64{1 - red[0]/100*0xff00/0xffff, (red[1] + 128) * 0x100 / 0xffff, (red[2] + 128) * 0x100 / 0xffff, 0.25}},
+		{mBA, [4]float64{
+			math.Pow(1-red[0]/100, 2), math.Pow((red[1]+128)/255, 2),
+			math.Pow((red[2]+128)/255, 2), math.Pow(64./0xff, 2), // 0.25 is stored as 64
+		}},
+	} {
+		output := NewOutput()
+		output.Options.OutputIntent = &OutputIntent{Profile: cmykProfile(test.lut)}
+		if output.cache.outputProfile() == nil {
+			t.Fatal("invalid profile")
+		}
+		got := output.cache.rgbToCMYK(1, 0, 0)
+		for i, v := range got {
+			if math.Abs(float64(v)-test.expected[i]) > 1e-3 {
+				t.Fatalf("expected %v, got %v", test.expected, got)
+			}
+		}
+	}
+
+	// invalid profiles use the device conversion
+	output := NewOutput()
+	output.Options.OutputIntent = &OutputIntent{Profile: fakeProfile("CMYK")}
+	if got := output.cache.rgbToCMYK(1, 0, 0); got != deviceCMYK(1, 0, 0) {
+		t.Fatalf("unexpected fallback %v", got)
+	}
+
+	// images are converted with the profile, ignoring the premultiplication
+	output.Options.OutputIntent = &OutputIntent{Profile: cmykProfile(lut16)}
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	img.Set(1, 0, color.NRGBA{R: 255, A: 128})
+	obj := output.cache.imageToCMYK(img)
+	pixels, err := obj.Stream.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pixels[:4], pixels[4:]) || pixels[0] != uint8((1-red[0]/100*0xff00/0xffff)*255+0.5) || obj.SMask == nil {
+		t.Fatalf("unexpected image %v", pixels)
+	}
+}
@@ -0,0 +1,103 @@
+package pdf
+
+import (
+	"log"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+// blendModes maps the CSS 'mix-blend-mode' keywords
+// to the PDF blend modes
+var blendModes = map[string]model.Name{
+	"normal":      "Normal",
+	"multiply":    "Multiply",
+	"screen":      "Screen",
+	"overlay":     "Overlay",
+	"darken":      "Darken",
+	"lighten":     "Lighten",
+	"color-dodge": "ColorDodge",
+	"color-burn":  "ColorBurn",
+	"hard-light":  "HardLight",
+	"soft-light":  "SoftLight",
+	"difference":  "Difference",
+	"exclusion":   "Exclusion",
+	"hue":         "Hue",
+	"saturation":  "Saturation",
+	"color":       "Color",
+	"luminosity":  "Luminosity",
+}
+
+func (g *group) SetBlendingMode(mode string) {
+	bm, ok := blendModes[mode]
+	if !ok {
+		log.Printf("unsupported blend mode %s", mode)
+		return
+	}
+	g.app.SetGraphicState(&model.GraphicState{BM: []model.Name{bm}})
+}
+
+// TransparencyGroup is implemented by the canvases created by [backend.Canvas.NewGroup],
+// and may be used with a type assertion.
+// The settings are used when the group is drawn by [backend.Canvas.DrawWithOpacity].
+type TransparencyGroup interface {
+	// SetIsolated controls whether the group is composited
+	// on a transparent backdrop, ignoring the content below it when
+	// blending (like the CSS 'isolation: isolate' property). Groups are isolated by default.
+	SetIsolated(isolated bool)
+
+	// SetKnockout controls whether the elements of the group
+	// are composited with the initial backdrop of the group
+	// instead of the elements painted before them.
+	SetKnockout(knockout bool)
+}
+
+var _ TransparencyGroup = (*group)(nil)
+
+func (g *group) SetIsolated(isolated bool) { g.isolated = isolated }
+
+func (g *group) SetKnockout(knockout bool) { g.knockout = knockout }
+
+// MaskMode defines how the content of a mask is converted to opacity.
+type MaskMode uint8
+
+const (
+	// LuminosityMask uses the luminance of the mask colors
+	// (like the SVG 'mask-type: luminance' property).
+	LuminosityMask MaskMode = iota
+	// AlphaMask uses the opacity of the mask
+	// (like the SVG 'mask-type: alpha' property).
+	AlphaMask
+)
+
+// Masker is implemented by the canvases of this package,
+// and may be used with a type assertion on [backend.Canvas].
+type Masker interface {
+	// SetMask is the same as [backend.GraphicState.SetAlphaMask], using [mode].
+	// For luminosity masks, [backdrop] is the color used outside
+	// of the mask content (black if nil).
+	SetMask(mask backend.Canvas, mode MaskMode, backdrop *parser.RGBA)
+}
+
+var _ Masker = (*group)(nil)
+
+func (g *group) SetAlphaMask(mask backend.Canvas) {
+	g.SetMask(mask, LuminosityMask, nil)
+}
+
+func (g *group) SetMask(mask backend.Canvas, mode MaskMode, backdrop *parser.RGBA) {
+	content := mask.(*group).formObject()
+	smask := model.SoftMaskDict{G: &model.XObjectTransparencyGroup{XObjectForm: *content}}
+	switch mode {
+	case AlphaMask:
+		smask.S = "Alpha"
+	default:
+		smask.S = "Luminosity"
+		smask.G.CS = g.blendingSpace()
+		if backdrop != nil {
+			smask.BC = g.components(*backdrop)
+		}
+	}
+	g.app.SetGraphicState(&model.GraphicState{SMask: smask})
+}
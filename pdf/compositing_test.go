@@ -0,0 +1,82 @@
+package pdf
+
+import (
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+func TestBlendingMode(t *testing.T) {
+	output := NewOutput()
+	page := output.AddPage(0, 0, 100, 100)
+	page.State().SetBlendingMode("color-dodge")
+	page.State().SetBlendingMode("plus-lighter") // ignored
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := doc.Catalog.Pages.Kids[0].(*model.PageObject).Resources.ExtGState
+	if len(states) != 1 {
+		t.Fatalf("expected one graphic state, got %v", states)
+	}
+	for _, gs := range states {
+		if len(gs.BM) != 1 || gs.BM[0] != "ColorDodge" {
+			t.Fatalf("unexpected blend mode %v", gs.BM)
+		}
+	}
+}
+
+func TestGroupAttributes(t *testing.T) {
+	output := NewOutput()
+	page := output.AddPage(0, 0, 100, 100)
+	isolated := page.NewGroup(0, 0, 50, 50)
+	knockout := page.NewGroup(0, 0, 50, 50)
+	knockout.(TransparencyGroup).SetIsolated(false)
+	knockout.(TransparencyGroup).SetKnockout(true)
+	page.DrawWithOpacity(1, isolated)
+	page.DrawWithOpacity(1, knockout)
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nbIsolated, nbKnockout int
+	for _, xo := range doc.Catalog.Pages.Kids[0].(*model.PageObject).Resources.XObject {
+		form := xo.(*model.XObjectTransparencyGroup)
+		if form.I && !form.K {
+			nbIsolated++
+		} else if !form.I && form.K {
+			nbKnockout++
+		}
+	}
+	if nbIsolated != 1 || nbKnockout != 1 {
+		t.Fatalf("unexpected groups: %d isolated, %d knockout", nbIsolated, nbKnockout)
+	}
+}
+
+func TestMaskMode(t *testing.T) {
+	output := NewOutput()
+	page := output.AddPage(0, 0, 100, 100)
+	mask := page.NewGroup(0, 0, 100, 100)
+	mask.Rectangle(0, 0, 50, 50)
+	mask.Paint(backend.FillNonZero)
+	page.OnNewStack(func() {
+		page.(Masker).SetMask(mask, AlphaMask, nil)
+	})
+	page.(Masker).SetMask(mask, LuminosityMask, &parser.RGBA{R: 1, G: 1, B: 1, A: 1})
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	modes := map[model.Name][]fl{}
+	for _, gs := range doc.Catalog.Pages.Kids[0].(*model.PageObject).Resources.ExtGState {
+		modes[gs.SMask.S] = gs.SMask.BC
+	}
+	if bc, ok := modes["Alpha"]; !ok || bc != nil {
+		t.Fatalf("expected an alpha mask, got %v", modes)
+	}
+	if bc := modes["Luminosity"]; len(bc) != 3 || bc[0] != 1 {
+		t.Fatalf("expected a luminosity mask with a white backdrop, got %v", modes)
+	}
+}
@@ -228,11 +228,11 @@ func TestDisplayInlineBlockTwice(t *testing.T) {
 
 	output := NewOutput()
 	doc.Write(output, 1, nil)
-	_ = output.Finalize()
+	_, _ = output.Finalize()
 
 	output = NewOutput()
 	doc.Write(output, 1, nil)
-	_ = output.Finalize()
+	_, _ = output.Finalize()
 }
 
 func TestRoundedRect(t *testing.T) {
@@ -161,6 +161,11 @@ func htmlToModelExt(t *testing.T, html string, zoom utils.Fl, baseURL string) mo
 
 func htmlToModelExt2(t *testing.T, html string, zoom utils.Fl, baseURL string, attachments []backend.Attachment) model.Document {
 	t.Helper()
+	return htmlToModelOptions(t, html, zoom, baseURL, attachments, Options{})
+}
+
+func htmlToModelOptions(t *testing.T, html string, zoom utils.Fl, baseURL string, attachments []backend.Attachment, options Options) model.Document {
+	t.Helper()
 
 	parsedHtml, err := tree.NewHTML(utils.InputString(html), baseURL, nil, "")
 	if err != nil {
@@ -169,8 +174,13 @@ func htmlToModelExt2(t *testing.T, html string, zoom utils.Fl, baseURL string, a
 	parsedHtml.UAStyleSheet = tree.TestUAStylesheet
 	doc := document.Render(parsedHtml, nil, false, fontconfig)
 	output := NewOutput()
+	output.Options = options
 	doc.Write(output, zoom, attachments)
-	return output.Finalize()
+	pdfDoc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pdfDoc
 }
 
 // use the light UA stylesheet
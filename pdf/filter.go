@@ -0,0 +1,357 @@
+package pdf
+
+import (
+	"image"
+	"log"
+	"math"
+
+	cs "github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+	"github.com/benoitkugler/webrender/matrix"
+)
+
+// Filter is one of the image filters supported by [FilterCanvas.DrawFiltered] :
+// [Blur], [DropShadow] or [Grayscale].
+// The lengths are expressed in the user space of the canvas.
+type Filter interface {
+	isFilter()
+}
+
+// Blur is a gaussian blur, like the CSS blur() function.
+type Blur struct {
+	StdDeviation fl
+}
+
+// DropShadow draws a blurred and offset copy of the
+// content alpha mask, below the content, like the CSS drop-shadow() function.
+type DropShadow struct {
+	DX, DY       fl
+	StdDeviation fl
+	Color        parser.RGBA
+}
+
+// Grayscale converts the content to grayscale, like the CSS grayscale() function.
+// Amount is between 0 (no effect) and 1 (completely gray).
+type Grayscale struct {
+	Amount fl
+}
+
+func (Blur) isFilter()       {}
+func (DropShadow) isFilter() {}
+func (Grayscale) isFilter()  {}
+
+// margin returns the extension of the content area caused by [filter]
+func filterMargin(filter Filter) fl {
+	switch filter := filter.(type) {
+	case Blur:
+		return 3 * filter.StdDeviation
+	case DropShadow:
+		return 3*filter.StdDeviation + fl(math.Max(math.Abs(float64(filter.DX)), math.Abs(float64(filter.DY))))
+	default:
+		return 0
+	}
+}
+
+// FilterCanvas is implemented by the canvases of this package,
+// and may be used with a type assertion on [backend.Canvas].
+type FilterCanvas interface {
+	// NewFilterGroup is the same as [backend.Canvas.NewGroup], but also records
+	// a simplified version of the content, required by [FilterCanvas.DrawFiltered].
+	// It should only be used for filtered content, since the recording is costly.
+	NewFilterGroup(x, y, width, height fl) backend.Canvas
+
+	// DrawFiltered rasterizes the content of [group], which must have been
+	// created by [FilterCanvas.NewFilterGroup], at the resolution [dpi],
+	// applies [filters] in order, and draws the result as an image with a soft mask.
+	// The rasterization only supports a subset of the drawing operations :
+	// paths, text whose font outlines are available, PNG, JPEG and GIF images,
+	// and groups; gradients are replaced by a plain color.
+	DrawFiltered(group backend.Canvas, filters []Filter, dpi fl)
+
+	// DrawRectangleShadow fills the rectangle (x, y, width, height) with [color],
+	// blurred by [blur] (the CSS box-shadow blur radius, that is twice the standard deviation),
+	// using a vector approximation : the opacity is described by a shading mesh.
+	DrawRectangleShadow(x, y, width, height, blur fl, color parser.RGBA)
+}
+
+var _ FilterCanvas = (*group)(nil)
+
+// maxRasterSize is the maximum width or height, in pixels,
+// used to rasterize a filtered group
+const maxRasterSize = 4096
+
+func (g *group) NewFilterGroup(x, y, width, height fl) backend.Canvas {
+	out := g.NewGroup(x, y, width, height).(*group)
+	out.raster = &displayList{}
+	return out
+}
+
+func (g *group) DrawFiltered(gr backend.Canvas, filters []Filter, dpi fl) {
+	g.pathTexts = nil // painting operation, see Clip
+	sub := gr.(*group)
+	if sub.raster == nil {
+		log.Println("filtered group must be created by NewFilterGroup")
+		return
+	}
+	left, top, right, bottom := sub.GetRectangle()
+	var margin fl
+	for _, filter := range filters {
+		margin += filterMargin(filter)
+	}
+	left, top, right, bottom = left-margin, top-margin, right+margin, bottom+margin
+	if right <= left || bottom <= top {
+		return
+	}
+
+	// pixels per user space unit
+	scale := g.scaleFactor() * dpi / 72
+	if maxSide := fl(math.Max(float64(right-left), float64(bottom-top))) * scale; maxSide > maxRasterSize {
+		scale *= maxRasterSize / maxSide
+	}
+	width, height := int(math.Ceil(float64((right-left)*scale))), int(math.Ceil(float64((bottom-top)*scale)))
+	if width == 0 || height == 0 || scale == 0 {
+		return
+	}
+
+	mat := matrix.New(scale, 0, 0, scale, -left*scale, -top*scale)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	newRasterizer(mat, width, height).draw(img, sub.raster)
+	for _, filter := range filters {
+		img = applyFilter(img, filter, scale)
+	}
+
+	obj := g.rgbaToXObject(img)
+	// the pixel grid may be slightly larger than the region
+	w, h := fl(width)/scale, fl(height)/scale
+	g.app.AddXObjectDims(obj, left, top+h, w, -h)
+	if g.raster != nil {
+		itemMat := matrix.New(1/scale, 0, 0, 1/scale, left, top)
+		itemMat.LeftMultBy(g.GetTransform())
+		g.raster.items = append(g.raster.items, displayItem{img: img, mat: itemMat, clips: g.state.clips})
+	}
+}
+
+// applyFilter returns the filtered image, where [scale]
+// is the number of pixels per user space unit
+func applyFilter(img *image.RGBA, filter Filter, scale fl) *image.RGBA {
+	switch filter := filter.(type) {
+	case Blur:
+		gaussianBlur(img, filter.StdDeviation*scale)
+	case DropShadow:
+		return dropShadow(img, filter, scale)
+	case Grayscale:
+		grayscale(img, filter.Amount)
+	}
+	return img
+}
+
+// gaussianBlur approximates a gaussian blur with three successive box blurs,
+// as suggested by the SVG specification for feGaussianBlur
+func gaussianBlur(img *image.RGBA, stdDeviation fl) {
+	d := int(math.Floor(float64(stdDeviation)*3*math.Sqrt(2*math.Pi)/4 + 0.5))
+	if d < 2 {
+		return
+	}
+	radius := d / 2
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	line := make([]uint8, 4*max(w, h))
+	for pass := 0; pass < 3; pass++ {
+		for y := 0; y < h; y++ { // horizontal
+			boxBlur(img.Pix[y*img.Stride:], 4, w, radius, line)
+		}
+		for x := 0; x < w; x++ { // vertical
+			boxBlur(img.Pix[4*x:], img.Stride, h, radius, line)
+		}
+	}
+}
+
+// boxBlur blurs the [n] pixels of [pix] separated by [stride] bytes,
+// using [line] as buffer
+func boxBlur(pix []uint8, stride, n, radius int, line []uint8) {
+	for i := 0; i < n; i++ {
+		copy(line[4*i:4*i+4], pix[i*stride:i*stride+4])
+	}
+	size := 2*radius + 1
+	for c := 0; c < 4; c++ {
+		sum := 0
+		for i := 0; i < radius && i < n; i++ {
+			sum += int(line[4*i+c])
+		}
+		for i := 0; i < n; i++ {
+			if j := i + radius; j < n {
+				sum += int(line[4*j+c])
+			}
+			if j := i - radius - 1; j >= 0 {
+				sum -= int(line[4*j+c])
+			}
+			pix[i*stride+c] = uint8(sum / size) // pixels outside are transparent
+		}
+	}
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// dropShadow returns [img] drawn over its shadow
+func dropShadow(img *image.RGBA, filter DropShadow, scale fl) *image.RGBA {
+	shadow := image.NewRGBA(img.Rect)
+	dx, dy := int(math.Round(float64(filter.DX*scale))), int(math.Round(float64(filter.DY*scale)))
+	c := filter.Color
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		sy := y - dy
+		if sy < 0 || sy >= h {
+			continue
+		}
+		for x := 0; x < w; x++ {
+			sx := x - dx
+			if sx < 0 || sx >= w {
+				continue
+			}
+			a := fl(img.Pix[sy*img.Stride+4*sx+3]) * c.A // premultiplied color
+			i := y*shadow.Stride + 4*x
+			shadow.Pix[i], shadow.Pix[i+1], shadow.Pix[i+2], shadow.Pix[i+3] = uint8(c.R*a), uint8(c.G*a), uint8(c.B*a), uint8(a)
+		}
+	}
+	gaussianBlur(shadow, filter.StdDeviation*scale)
+	// source over
+	for i := 0; i < len(img.Pix); i += 4 {
+		inv := 255 - int(img.Pix[i+3])
+		for k := 0; k < 4; k++ {
+			shadow.Pix[i+k] = uint8(int(img.Pix[i+k]) + int(shadow.Pix[i+k])*inv/255)
+		}
+	}
+	return shadow
+}
+
+// grayscale applies the color matrix defined by the CSS grayscale() function
+func grayscale(img *image.RGBA, amount fl) {
+	a := 1 - fl(math.Max(0, math.Min(1, float64(amount))))
+	mat := [3][3]fl{
+		{0.2126 + 0.7874*a, 0.7152 - 0.7152*a, 0.0722 - 0.0722*a},
+		{0.2126 - 0.2126*a, 0.7152 + 0.2848*a, 0.0722 - 0.0722*a},
+		{0.2126 - 0.2126*a, 0.7152 - 0.7152*a, 0.0722 + 0.9278*a},
+	}
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, g, b := fl(img.Pix[i]), fl(img.Pix[i+1]), fl(img.Pix[i+2])
+		for k, row := range mat {
+			// premultiplied values are preserved since the matrix is linear
+			img.Pix[i+k] = uint8(math.Min(255, float64(row[0]*r+row[1]*g+row[2]*b)+0.5))
+		}
+	}
+}
+
+// rgbaToXObject returns an RGB image, whose soft mask is the alpha channel of [img].
+// The color values are premultiplied, which is described by the Matte entry.
+func (c cache) rgbaToXObject(img *image.RGBA) *model.XObjectImage {
+	if gr := c.grayOutput(); gr != nil {
+		out := gr.imageToGray(img)
+		out.Interpolate = true
+		return out
+	}
+	if c.cmykOutput() {
+		out := c.imageToCMYK(img)
+		out.Interpolate = true
+		return out
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	colors, alpha := make([]byte, 0, 3*w*h), make([]byte, 0, w*h)
+	for i := 0; i < len(img.Pix); i += 4 {
+		colors = append(colors, img.Pix[i:i+3]...)
+		alpha = append(alpha, img.Pix[i+3])
+	}
+	return &model.XObjectImage{
+		Image: model.Image{
+			Stream:           model.NewCompressedStream(colors),
+			BitsPerComponent: 8,
+			Width:            w,
+			Height:           h,
+			Interpolate:      true,
+		},
+		ColorSpace: model.ColorSpaceRGB,
+		SMask: &model.ImageSMask{
+			Image: model.Image{
+				Stream:           model.NewCompressedStream(alpha),
+				BitsPerComponent: 8,
+				Width:            w,
+				Height:           h,
+				Interpolate:      true,
+			},
+			Matte: []fl{0, 0, 0},
+		},
+	}
+}
+
+// shadowSamples is the number of grid lines used to
+// sample each edge of a blurred rectangle
+const shadowSamples = 12
+
+func (g *group) DrawRectangleShadow(x, y, width, height, blur fl, color parser.RGBA) {
+	g.OnNewStack(func() {
+		g.SetColorRgba(color, false)
+		sigma := blur / 2
+		if sigma > 0 {
+			margin := 3 * sigma
+			xs := shadowGrid(x, x+width, margin)
+			ys := shadowGrid(y, y+height, margin)
+			bbox := [4]fl{xs[0], xs[len(xs)-1], ys[0], ys[len(ys)-1]}
+			alphaStream := cs.NewGraphicStream(model.Rectangle{Llx: bbox[0], Lly: bbox[2], Urx: bbox[1], Ury: bbox[3]})
+			shName := alphaStream.AddShading(&model.ShadingDict{
+				ColorSpace:  model.ColorSpaceGray,
+				ShadingType: freeFormShading(shadowMesh(xs, ys, x, y, width, height, sigma), bbox, 1),
+			})
+			alphaStream.Ops(cs.OpShFill{Shading: shName})
+			g.drawMask(&alphaStream)
+			x, y, width, height = bbox[0], bbox[2], bbox[1]-bbox[0], bbox[3]-bbox[2]
+		}
+		g.Rectangle(x, y, width, height)
+		g.Paint(backend.FillNonZero)
+	})
+}
+
+// shadowGrid returns the sorted coordinates sampling
+// the blurred edges [start] and [end]
+func shadowGrid(start, end, margin fl) []fl {
+	var out []fl
+	for _, edge := range [2]fl{start, end} {
+		for i := 0; i <= shadowSamples; i++ {
+			v := edge - margin + 2*margin*fl(i)/shadowSamples
+			if len(out) == 0 || v > out[len(out)-1] { // the edges may overlap
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+// shadowAlpha returns the coverage of the segment [start, end]
+// blurred with [sigma], at [v]
+func shadowAlpha(v, start, end, sigma fl) fl {
+	s := float64(sigma) * math.Sqrt2
+	return fl(0.5 * (math.Erf(float64(v-start)/s) - math.Erf(float64(v-end)/s)))
+}
+
+// shadowMesh returns the triangles of the grid ([xs], [ys]), whose gray
+// level is the opacity of the rectangle blurred with [sigma]
+func shadowMesh(xs, ys []fl, x, y, width, height, sigma fl) []meshVertex {
+	vertex := func(i, j int) meshVertex {
+		alpha := shadowAlpha(xs[i], x, x+width, sigma) * shadowAlpha(ys[j], y, y+height, sigma)
+		return meshVertex{xs[i], ys[j], []fl{alpha}}
+	}
+	var out []meshVertex
+	for i := 0; i+1 < len(xs); i++ {
+		for j := 0; j+1 < len(ys); j++ {
+			out = append(out,
+				vertex(i, j), vertex(i+1, j), vertex(i+1, j+1),
+				vertex(i, j), vertex(i+1, j+1), vertex(i, j+1),
+			)
+		}
+	}
+	return out
+}
@@ -0,0 +1,141 @@
+package pdf
+
+import (
+	"image"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+	"github.com/benoitkugler/webrender/matrix"
+)
+
+func TestRasterizer(t *testing.T) {
+	var list displayList
+	list.moveTo(point{2, 2})
+	list.lineTo(point{6, 2})
+	list.lineTo(point{6, 6})
+	list.lineTo(point{2, 6})
+	list.closePath()
+	list.items = append(list.items, displayItem{shape: list.takePath(), color: parser.RGBA{R: 1, A: 1}})
+
+	// scale by 2
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	newRasterizer(matrix.New(2, 0, 0, 2, 0, 0), 16, 16).draw(img, &list)
+	if c := img.RGBAAt(8, 8); c.R != 255 || c.A != 255 || c.G != 0 {
+		t.Fatalf("unexpected inner color %v", c)
+	}
+	if c := img.RGBAAt(2, 2); c.A != 0 {
+		t.Fatalf("unexpected outer color %v", c)
+	}
+	if c := img.RGBAAt(12, 12); c.A != 0 {
+		t.Fatalf("unexpected outer color %v", c)
+	}
+}
+
+func TestGaussianBlur(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 41, 41))
+	for y := 15; y < 26; y++ {
+		for x := 15; x < 26; x++ {
+			copy(img.Pix[img.PixOffset(x, y):], []uint8{255, 0, 0, 255})
+		}
+	}
+	gaussianBlur(img, 3)
+	center, edge, outside := img.RGBAAt(20, 20), img.RGBAAt(15, 20), img.RGBAAt(5, 20)
+	if !(center.A > edge.A && edge.A > outside.A) {
+		t.Fatalf("unexpected blur profile %d %d %d", center.A, edge.A, outside.A)
+	}
+	if edge.A < 100 || edge.A > 155 {
+		t.Fatalf("expected half coverage on the edge, got %d", edge.A)
+	}
+	if left, right := img.RGBAAt(12, 20), img.RGBAAt(28, 20); left != right {
+		t.Fatalf("expected symmetric blur, got %v %v", left, right)
+	}
+}
+
+func TestDropShadow(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	copy(img.Pix[img.PixOffset(5, 5):], []uint8{0, 0, 255, 255})
+	out := dropShadow(img, DropShadow{DX: 2, DY: 1, Color: parser.RGBA{R: 1, A: 0.5}}, 2)
+	if c := out.RGBAAt(5, 5); c.B != 255 || c.A != 255 {
+		t.Fatalf("expected the content above the shadow, got %v", c)
+	}
+	if c := out.RGBAAt(9, 7); c.R != 127 || c.A != 127 {
+		t.Fatalf("unexpected shadow %v", c)
+	}
+}
+
+func TestShadowMesh(t *testing.T) {
+	const sigma = 5
+	if a := shadowAlpha(50, 0, 100, sigma); math.Abs(float64(a)-1) > 1e-3 {
+		t.Fatalf("expected opaque center, got %g", a)
+	}
+	if a := shadowAlpha(0, 0, 100, sigma); math.Abs(float64(a)-0.5) > 1e-3 {
+		t.Fatalf("expected half opacity on the edge, got %g", a)
+	}
+
+	xs := shadowGrid(0, 100, 3*sigma)
+	if xs[0] != -15 || xs[len(xs)-1] != 115 || len(xs) != 2*(shadowSamples+1) {
+		t.Fatalf("unexpected grid %v", xs)
+	}
+	// overlapping edges
+	xs = shadowGrid(0, 10, 3*sigma)
+	for i := 1; i < len(xs); i++ {
+		if xs[i] <= xs[i-1] {
+			t.Fatalf("unsorted grid %v", xs)
+		}
+	}
+	if L := len(shadowMesh(xs, xs, 0, 0, 10, 10, sigma)); L != 6*(len(xs)-1)*(len(xs)-1) {
+		t.Fatalf("unexpected mesh size %d", L)
+	}
+}
+
+func TestDrawFiltered(t *testing.T) {
+	output := NewOutput()
+	page := output.AddPage(0, 0, 100, 100)
+	if page.NewGroup(0, 0, 10, 10).(*group).raster != nil {
+		t.Fatal("plain groups should not record a display list")
+	}
+	gr := page.(FilterCanvas).NewFilterGroup(10, 10, 50, 50)
+	gr.State().SetColorRgba(parser.RGBA{G: 1, A: 1}, false)
+	gr.Rectangle(20, 20, 30, 30)
+	gr.Paint(backend.FillNonZero)
+	page.(FilterCanvas).DrawFiltered(gr, []Filter{DropShadow{DX: 4, DY: 4, StdDeviation: 2}, Grayscale{Amount: 1}}, 72)
+	page.(FilterCanvas).DrawRectangleShadow(10, 10, 20, 20, 6, parser.RGBA{A: 0.5})
+
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageObj := doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	var img *model.XObjectImage
+	for _, xo := range pageObj.Resources.XObject {
+		if xo, ok := xo.(*model.XObjectImage); ok {
+			img = xo
+		}
+	}
+	if img == nil || img.SMask == nil {
+		t.Fatal("expected an image with a soft mask")
+	}
+	// 50 + 2 * (3 * 2 + 4)
+	if img.Width != 70 || img.Height != 70 {
+		t.Fatalf("unexpected image size %d x %d", img.Width, img.Height)
+	}
+
+	var hasMask bool
+	for _, gs := range pageObj.Resources.ExtGState {
+		hasMask = hasMask || gs.SMask.G != nil
+	}
+	if !hasMask {
+		t.Fatal("expected a soft mask for the rectangle shadow")
+	}
+	content, err := pageObj.Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), " Do") || !strings.Contains(string(content), " re\nf") {
+		t.Fatalf("unexpected content %s", content)
+	}
+}
@@ -0,0 +1,201 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+)
+
+// ConicGradient is the [backend.GradientKind.Kind] used for conic gradients,
+// whose Coords are (cx, cy, angle), where (cx, cy) is the center of the gradient
+// and angle is the starting angle, in radians, measured clockwise from the top
+// (as for the CSS conic-gradient() function).
+// The positions are fractions of a full turn.
+//
+// The style engine (webrender v0.0.9) does not support conic-gradient(), so that
+// this kind is never used when rendering HTML: conic gradients are only drawn when
+// using the [backend.Canvas] directly, for instance in [Overlay.Draw].
+const ConicGradient = "conic"
+
+// conicSteps is the number of triangles used to approximate a full turn
+const conicSteps = 360
+
+// conicColors evaluates the color stops of a gradient,
+// using premultiplied alpha for the interpolation
+type conicColors struct {
+	positions []fl
+	colors    [][4]fl // premultiplied
+	repeating bool
+}
+
+func newConicColors(layout backend.GradientLayout) conicColors {
+	out := conicColors{positions: layout.Positions, repeating: layout.Reapeating}
+	out.colors = make([][4]fl, len(layout.Colors))
+	for i, c := range layout.Colors {
+		out.colors[i] = [4]fl{c.R * c.A, c.G * c.A, c.B * c.A, c.A}
+	}
+	return out
+}
+
+// at returns the (premultiplied) color at position [t]
+// (a fraction of a full turn)
+func (cc conicColors) at(t fl) [4]fl {
+	L := len(cc.positions)
+	first, last := cc.positions[0], cc.positions[L-1]
+	if cc.repeating && last > first {
+		t = first + fl(math.Mod(float64(t-first), float64(last-first)))
+		if t < first {
+			t += last - first
+		}
+	}
+	if t <= first {
+		return cc.colors[0]
+	}
+	if t >= last {
+		return cc.colors[L-1]
+	}
+	// first stop strictly after t
+	i := sort.Search(L, func(i int) bool { return cc.positions[i] > t })
+	p0, p1 := cc.positions[i-1], cc.positions[i]
+	c0, c1 := cc.colors[i-1], cc.colors[i]
+	if p1 == p0 {
+		return c1
+	}
+	f := (t - p0) / (p1 - p0)
+	var out [4]fl
+	for k := range out {
+		out[k] = c0[k] + f*(c1[k]-c0[k])
+	}
+	return out
+}
+
+// conicAngles returns the sorted angles (as fractions of turn)
+// of the fan, including the color stops
+func (cc conicColors) conicAngles() []fl {
+	angles := make([]fl, 0, conicSteps+1+len(cc.positions))
+	for i := 0; i <= conicSteps; i++ {
+		angles = append(angles, fl(i)/conicSteps)
+	}
+	for _, p := range cc.positions {
+		if 0 < p && p < 1 {
+			angles = append(angles, p)
+		}
+	}
+	sort.Slice(angles, func(i, j int) bool { return angles[i] < angles[j] })
+	return angles
+}
+
+// meshVertex is a vertex of a free-form shading
+type meshVertex struct {
+	x, y  fl
+	color []fl
+}
+
+// conicShadings builds a triangle fan approximating the conic
+// gradient in [layout], covering the rectangle (0, 0, width, height).
+// [alpha] is nil if all the colors are opaque.
+func (c cache) conicShadings(layout backend.GradientLayout, width, height fl) (color, alpha *model.ShadingDict) {
+	cx, cy, startAngle := layout.Coords[0], layout.Coords[1], layout.Coords[2]
+	// the farthest corner gives the radius of the fan
+	var radius fl
+	for _, corner := range [4][2]fl{{0, 0}, {width, 0}, {0, height}, {width, height}} {
+		if r := fl(math.Hypot(float64(corner[0]-cx), float64(corner[1]-cy))); r > radius {
+			radius = r
+		}
+	}
+	radius += 1
+
+	cc := newConicColors(layout)
+	needAlpha := false
+	for _, c := range layout.Colors {
+		needAlpha = needAlpha || c.A != 1
+	}
+
+	point := func(t fl) (fl, fl) {
+		theta := float64(startAngle) + 2*math.Pi*float64(t)
+		// clockwise from the top, with y growing downward
+		return cx + radius*fl(math.Sin(theta)), cy - radius*fl(math.Cos(theta))
+	}
+	const epsilon = 1e-6
+	angles := cc.conicAngles()
+	var colorTriangles, alphaTriangles []meshVertex
+	for i := 0; i+1 < len(angles); i++ {
+		t0, t1 := angles[i], angles[i+1]
+		if t1-t0 < epsilon {
+			continue
+		}
+		// use the colors inside the sector, to respect hard stops
+		c0, c1, cMid := cc.at(t0+epsilon), cc.at(t1-epsilon), cc.at((t0+t1)/2)
+		x0, y0 := point(t0)
+		x1, y1 := point(t1)
+		colorTriangles = append(colorTriangles,
+			meshVertex{cx, cy, c.convertVertexColor(unpremultiply(cMid))},
+			meshVertex{x0, y0, c.convertVertexColor(unpremultiply(c0))},
+			meshVertex{x1, y1, c.convertVertexColor(unpremultiply(c1))},
+		)
+		if needAlpha {
+			alphaTriangles = append(alphaTriangles,
+				meshVertex{cx, cy, []fl{cMid[3]}},
+				meshVertex{x0, y0, []fl{c0[3]}},
+				meshVertex{x1, y1, []fl{c1[3]}},
+			)
+		}
+	}
+
+	bbox := [4]fl{cx - radius, cx + radius, cy - radius, cy + radius}
+	space := c.blendingSpace()
+	color = &model.ShadingDict{
+		ColorSpace:  space,
+		ShadingType: freeFormShading(colorTriangles, bbox, space.NbColorComponents()),
+	}
+	if needAlpha {
+		alpha = &model.ShadingDict{
+			ColorSpace:  model.ColorSpaceGray,
+			ShadingType: freeFormShading(alphaTriangles, bbox, 1),
+		}
+	}
+	return color, alpha
+}
+
+func unpremultiply(c [4]fl) []fl {
+	if c[3] == 0 {
+		return []fl{0, 0, 0}
+	}
+	return []fl{c[0] / c[3], c[1] / c[3], c[2] / c[3]}
+}
+
+// freeFormShading encodes independent triangles as a type 4 shading,
+// whose coordinates are in [bbox] (xMin, xMax, yMin, yMax).
+func freeFormShading(triangles []meshVertex, bbox [4]fl, nbComponents int) model.ShadingFreeForm {
+	const maxCoord, maxComponent = math.MaxUint32, math.MaxUint16
+	clamp := func(v fl) float64 { return math.Max(0, math.Min(1, float64(v))) }
+	data := make([]byte, 0, len(triangles)*(1+8+2*nbComponents))
+	for _, v := range triangles {
+		data = append(data, 0) // flag: each triangle is independent
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(clamp((v.x-bbox[0])/(bbox[1]-bbox[0]))*maxCoord))
+		data = append(data, buf[:]...)
+		binary.BigEndian.PutUint32(buf[:], uint32(clamp((v.y-bbox[2])/(bbox[3]-bbox[2]))*maxCoord))
+		data = append(data, buf[:]...)
+		for _, c := range v.color {
+			binary.BigEndian.PutUint16(buf[:], uint16(clamp(c)*maxComponent))
+			data = append(data, buf[:2]...)
+		}
+	}
+	decode := [][2]fl{{bbox[0], bbox[1]}, {bbox[2], bbox[3]}}
+	for i := 0; i < nbComponents; i++ {
+		decode = append(decode, [2]fl{0, 1})
+	}
+	return model.ShadingFreeForm{
+		ShadingStream: model.ShadingStream{
+			Stream:            model.NewCompressedStream(data),
+			BitsPerCoordinate: 32,
+			BitsPerComponent:  16,
+			Decode:            decode,
+		},
+		BitsPerFlag: 8,
+	}
+}
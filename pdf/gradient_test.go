@@ -0,0 +1,103 @@
+package pdf
+
+import (
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+func TestConicColors(t *testing.T) {
+	red, blue := parser.RGBA{R: 1, A: 1}, parser.RGBA{B: 1, A: 1}
+	cc := newConicColors(backend.GradientLayout{
+		Positions: []fl{0, 0.5, 0.5, 1},
+		Colors:    []parser.RGBA{red, red, blue, blue},
+	})
+	if c := cc.at(0.25); c != [4]fl{1, 0, 0, 1} {
+		t.Fatalf("unexpected color %v", c)
+	}
+	if c := cc.at(0.75); c != [4]fl{0, 0, 1, 1} {
+		t.Fatalf("unexpected color %v", c)
+	}
+
+	cc = newConicColors(backend.GradientLayout{
+		Positions:  []fl{0, 0.25},
+		Colors:     []parser.RGBA{red, {A: 0}},
+		Reapeating: true,
+	})
+	if c := cc.at(0.25 + 0.125); c != [4]fl{0.5, 0, 0, 0.5} {
+		t.Fatalf("unexpected color %v", c)
+	}
+}
+
+func TestConicGradient(t *testing.T) {
+	layout := backend.GradientLayout{
+		GradientKind: backend.GradientKind{Kind: ConicGradient, Coords: [6]fl{50, 50, 0}},
+		ScaleY:       1,
+		Positions:    []fl{0, 0.3125, 1},
+		Colors:       []parser.RGBA{{R: 1, A: 1}, {G: 1, A: 1}, {B: 1, A: 0.5}},
+	}
+	sh, alpha := newCache(&Options{}).conicShadings(layout, 100, 100)
+	if alpha == nil {
+		t.Fatal("expected an alpha shading")
+	}
+	mesh := sh.ShadingType.(model.ShadingFreeForm)
+	content, err := mesh.Stream.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one triangle per step, plus one for the inner stop
+	if exp := (conicSteps + 1) * 3 * (1 + 8 + 6); len(content) != exp {
+		t.Fatalf("expected %d bytes, got %d", exp, len(content))
+	}
+
+	output := NewOutput()
+	page := output.AddPage(0, 0, 100, 100)
+	page.DrawGradient(layout, 100, 100)
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := doc.Catalog.Pages.Kids[0].(*model.PageObject).Resources
+	if len(resources.Shading) != 1 || len(resources.ExtGState) == 0 {
+		t.Fatal("expected a shading with an alpha mask")
+	}
+	for _, sh := range resources.Shading {
+		if _, ok := sh.ShadingType.(model.ShadingFreeForm); !ok {
+			t.Fatalf("unexpected shading %T", sh.ShadingType)
+		}
+	}
+}
+
+func TestConicGradientOverlay(t *testing.T) {
+	output := NewOutput()
+	output.Options.Overlays = []Overlay{{
+		Draw: func(dst backend.Canvas, width, height fl) {
+			dst.DrawGradient(backend.GradientLayout{
+				GradientKind: backend.GradientKind{Kind: ConicGradient, Coords: [6]fl{width / 2, height / 2, 0}},
+				ScaleY:       1,
+				Positions:    []fl{0, 1},
+				Colors:       []parser.RGBA{{R: 1, A: 1}, {B: 1, A: 1}},
+			}, width, height)
+		},
+	}}
+	page := output.AddPage(0, 0, 100, 100)
+	page.SetMediaBox(0, 0, 100, 100)
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var overlay *model.XObjectForm
+	for _, xo := range doc.Catalog.Pages.Kids[0].(*model.PageObject).Resources.XObject {
+		overlay, _ = xo.(*model.XObjectForm)
+	}
+	if overlay == nil || len(overlay.Resources.Shading) != 1 {
+		t.Fatal("expected a shading in the overlay")
+	}
+	for _, sh := range overlay.Resources.Shading {
+		if _, ok := sh.ShadingType.(model.ShadingFreeForm); !ok {
+			t.Fatalf("unexpected shading %T", sh.ShadingType)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package pdf
+
+import (
+	"image"
+
+	cs "github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+// GrayOutput converts all the colors of the document to DeviceGray,
+// for monochrome printers.
+type GrayOutput struct {
+	// Weights are the coefficients of the red, green and blue
+	// components used to compute the gray level.
+	// If zero, the Rec. 601 luma coefficients (0.299, 0.587, 0.114) are used.
+	Weights [3]fl
+
+	// Monochrome restricts the colors and the raster images to pure black and white:
+	// the gray levels lower than [Threshold] are painted in black.
+	// Gradients are still written with gray levels.
+	Monochrome bool
+	// Threshold defaults to 0.5. Higher values
+	// make the light colors (such as yellow text) readable.
+	Threshold fl
+	// Dither uses Floyd-Steinberg dithering for the raster images in
+	// monochrome mode, instead of a simple threshold.
+	Dither bool
+}
+
+// gray returns the gray level of [r, g, b], ignoring the monochrome mode
+func (gr *GrayOutput) gray(r, g, b fl) fl {
+	w := gr.Weights
+	if w == ([3]fl{}) {
+		w = [3]fl{0.299, 0.587, 0.114}
+	}
+	out := (w[0]*r + w[1]*g + w[2]*b) / (w[0] + w[1] + w[2])
+	if out < 0 {
+		return 0
+	} else if out > 1 {
+		return 1
+	}
+	return out
+}
+
+// level returns the gray level used for colors
+func (gr *GrayOutput) level(r, g, b fl) fl {
+	out := gr.gray(r, g, b)
+	if !gr.Monochrome {
+		return out
+	}
+	if out < gr.threshold() {
+		return 0
+	}
+	return 1
+}
+
+func (gr *GrayOutput) threshold() fl {
+	if gr.Threshold == 0 {
+		return 0.5
+	}
+	return gr.Threshold
+}
+
+// grayOutput returns the gray settings, or nil
+func (c cache) grayOutput() *GrayOutput { return c.options.GrayOutput }
+
+// writeGrayColor writes the color operation if the output is gray,
+// returning false otherwise
+func (g *group) writeGrayColor(color parser.RGBA, stroke bool) bool {
+	gr := g.grayOutput()
+	if gr == nil {
+		return false
+	}
+	level := gr.level(color.R, color.G, color.B)
+	if stroke {
+		g.app.Ops(cs.OpSetStrokeGray{G: level})
+	} else {
+		g.app.Ops(cs.OpSetFillGray{G: level})
+	}
+	return true
+}
+
+// grayFunctions converts the RGB outputs of [fns] to gray levels
+func (gr *GrayOutput) grayFunctions(fns []model.FunctionDict) {
+	for i := range fns {
+		switch ft := fns[i].FunctionType.(type) {
+		case model.FunctionExpInterpolation:
+			if len(ft.C0) == 3 && len(ft.C1) == 3 {
+				ft.C0 = []fl{gr.gray(ft.C0[0], ft.C0[1], ft.C0[2])}
+				ft.C1 = []fl{gr.gray(ft.C1[0], ft.C1[1], ft.C1[2])}
+				fns[i].FunctionType = ft
+			}
+		case model.FunctionStitching:
+			gr.grayFunctions(ft.Functions)
+		}
+		if len(fns[i].Range) == 3 {
+			fns[i].Range = fns[i].Range[:1]
+		}
+	}
+}
+
+// imageToGray returns a DeviceGray image, with a soft mask if [img] is not opaque.
+// In monochrome mode, the image uses one bit per pixel.
+func (gr *GrayOutput) imageToGray(img image.Image) *model.XObjectImage {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	levels, alpha := make([]fl, 0, w*h), make([]byte, 0, w*h)
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			var level fl
+			if a != 0 { // unpremultiply
+				level = gr.gray(fl(r)/fl(a), fl(g)/fl(a), fl(b)/fl(a))
+			}
+			levels = append(levels, level)
+			alpha = append(alpha, uint8(a>>8))
+			opaque = opaque && a == 0xffff
+		}
+	}
+
+	out := &model.XObjectImage{
+		Image:      model.Image{Width: w, Height: h},
+		ColorSpace: model.ColorSpaceGray,
+	}
+	if gr.Monochrome {
+		out.Stream = model.NewCompressedStream(gr.toBits(levels, w, h))
+		out.BitsPerComponent = 1
+	} else {
+		data := make([]byte, len(levels))
+		for i, l := range levels {
+			data[i] = uint8(l*255 + 0.5)
+		}
+		out.Stream = model.NewCompressedStream(data)
+		out.BitsPerComponent = 8
+	}
+	if !opaque {
+		out.SMask = &model.ImageSMask{Image: model.Image{
+			Stream:           model.NewCompressedStream(alpha),
+			BitsPerComponent: 8,
+			Width:            w,
+			Height:           h,
+		}}
+	}
+	return out
+}
+
+// toBits returns the 1-bit rows of [levels], using a threshold or
+// Floyd-Steinberg dithering. [levels] is modified.
+func (gr *GrayOutput) toBits(levels []fl, w, h int) []byte {
+	stride := (w + 7) / 8
+	out := make([]byte, stride*h)
+	threshold := gr.threshold()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			level := levels[y*w+x]
+			var value fl
+			if level >= threshold {
+				value = 1
+				out[y*stride+x/8] |= 0x80 >> (x % 8) // 1 is white
+			}
+			if !gr.Dither {
+				continue
+			}
+			err := level - value
+			if x+1 < w {
+				levels[y*w+x+1] += err * 7 / 16
+			}
+			if y+1 < h {
+				if x > 0 {
+					levels[(y+1)*w+x-1] += err * 3 / 16
+				}
+				levels[(y+1)*w+x] += err * 5 / 16
+				if x+1 < w {
+					levels[(y+1)*w+x+1] += err * 1 / 16
+				}
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+package pdf
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/bits"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+func TestGrayLevel(t *testing.T) {
+	gr := &GrayOutput{}
+	if l := gr.level(1, 0, 0); l != 0.299 {
+		t.Fatalf("unexpected level %g", l)
+	}
+	gr.Weights = [3]fl{1, 1, 1}
+	if l := gr.level(1, 0.5, 0); l != 0.5 {
+		t.Fatalf("unexpected level %g", l)
+	}
+	gr = &GrayOutput{Monochrome: true}
+	if gr.level(1, 1, 0) != 1 || gr.level(0.2, 0.2, 0.2) != 0 {
+		t.Fatal("unexpected monochrome levels")
+	}
+	// light yellow text is printed in black
+	gr.Threshold = 0.95
+	if gr.level(1, 1, 0) != 0 {
+		t.Fatal("expected black for yellow")
+	}
+}
+
+func TestToBits(t *testing.T) {
+	const w, h = 16, 16
+	flat := func(v fl) []fl {
+		out := make([]fl, w*h)
+		for i := range out {
+			out[i] = v
+		}
+		return out
+	}
+	countWhite := func(data []byte) (n int) {
+		for _, b := range data {
+			n += bits.OnesCount8(b)
+		}
+		return n
+	}
+
+	gr := &GrayOutput{Monochrome: true}
+	if data := gr.toBits(flat(0.4), w, h); len(data) != 2*h || countWhite(data) != 0 {
+		t.Fatalf("unexpected threshold output %v", data)
+	}
+	gr.Dither = true
+	if n := countWhite(gr.toBits(flat(0.4), w, h)); n < 90 || n > 115 {
+		t.Fatalf("unexpected number of white pixels %d", n)
+	}
+	// rows are padded
+	if data := gr.toBits(flat(1), 3, 2); len(data) != 2 || data[0] != 0xe0 {
+		t.Fatalf("unexpected padding %v", data)
+	}
+}
+
+func TestGrayOutput(t *testing.T) {
+	output := NewOutput()
+	output.Options.GrayOutput = &GrayOutput{}
+	output.Options.SpotColors = []SpotColor{{Name: "Red", RGB: parser.RGBA{R: 1, A: 1}}}
+	page := output.AddPage(0, 0, 100, 100)
+	page.State().SetColorRgba(parser.RGBA{R: 1, A: 1}, false)
+	page.Rectangle(0, 0, 10, 10)
+	page.Paint(backend.FillNonZero)
+	page.DrawGradient(backend.GradientLayout{
+		ScaleY:       1,
+		GradientKind: backend.GradientKind{Kind: "linear", Coords: [6]fl{0, 0, 100, 0}},
+		Positions:    []fl{0, 1},
+		Colors:       []parser.RGBA{{R: 1, A: 1}, {B: 1, A: 1}},
+	}, 100, 100)
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.NRGBA{G: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	page.DrawRasterImage(backend.RasterImage{Content: &buf, MimeType: "image/png", ID: 1}, 10, 10)
+
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageObj := doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	for _, sh := range pageObj.Resources.Shading {
+		if sh.ColorSpace != model.ColorSpaceGray {
+			t.Fatalf("unexpected shading color space %v", sh.ColorSpace)
+		}
+	}
+	for _, xo := range pageObj.Resources.XObject {
+		if img, ok := xo.(*model.XObjectImage); ok && img.ColorSpace != model.ColorSpaceGray {
+			t.Fatalf("unexpected image color space %v", img.ColorSpace)
+		}
+	}
+	content, err := pageObj.Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "0.299 g") || strings.Contains(string(content), " rg") || strings.Contains(string(content), " cs") {
+		t.Fatalf("unexpected content %s", content)
+	}
+}
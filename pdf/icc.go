@@ -0,0 +1,452 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// This file implements the conversion from the profile connection space (PCS)
+// to the device space of an ICC output profile, using its BToA tables.
+// See https://www.color.org/specification/ICC.1-2022-05.pdf
+
+// iccCurve is a one dimensional function, with
+// normalized input and output
+type iccCurve interface {
+	eval(x float64) float64
+}
+
+// sampledCurve is a 'curv' with at least two entries,
+// or the input and output tables of 'mft1' and 'mft2'
+type sampledCurve []float64
+
+func (c sampledCurve) eval(x float64) float64 {
+	pos := clamp01(x) * float64(len(c)-1)
+	i := int(pos)
+	if i >= len(c)-1 {
+		return c[len(c)-1]
+	}
+	t := pos - float64(i)
+	return c[i]*(1-t) + c[i+1]*t
+}
+
+// gammaCurve is a 'curv' with zero (identity) or one entry
+type gammaCurve float64
+
+func (g gammaCurve) eval(x float64) float64 { return math.Pow(clamp01(x), float64(g)) }
+
+// parametricCurve is a 'para' curve
+type parametricCurve struct {
+	function uint16
+	params   [7]float64 // g, a, b, c, d, e, f
+}
+
+func (p parametricCurve) eval(x float64) float64 {
+	g, a, b, c, d, e, f := p.params[0], p.params[1], p.params[2], p.params[3], p.params[4], p.params[5], p.params[6]
+	pow := func(v float64) float64 {
+		if v <= 0 {
+			return 0
+		}
+		return math.Pow(v, g)
+	}
+	var y float64
+	switch p.function {
+	case 0:
+		y = pow(x)
+	case 1:
+		if x >= -b/a {
+			y = pow(a*x + b)
+		}
+	case 2:
+		y = c
+		if x >= -b/a {
+			y = pow(a*x+b) + c
+		}
+	case 3:
+		y = c * x
+		if x >= d {
+			y = pow(a*x + b)
+		}
+	case 4:
+		y = c*x + f
+		if x >= d {
+			y = pow(a*x+b) + e
+		}
+	}
+	return clamp01(y)
+}
+
+// iccCLUT is a multi dimensional table, interpolated linearly
+type iccCLUT struct {
+	grid   []int     // number of points for each input
+	out    int       // number of outputs
+	values []float64 // normalized output values, the first input varying the slowest
+}
+
+func (cl iccCLUT) eval(in []float64) []float64 {
+	// find the cell and the position inside it
+	base := make([]int, len(in))
+	frac := make([]float64, len(in))
+	for i, v := range in {
+		pos := clamp01(v) * float64(cl.grid[i]-1)
+		base[i] = int(pos)
+		if base[i] >= cl.grid[i]-1 {
+			base[i] = cl.grid[i] - 1
+		}
+		frac[i] = pos - float64(base[i])
+	}
+
+	out := make([]float64, cl.out)
+	// sum the contributions of the 2^n corners of the cell
+	for corner := 0; corner < 1<<len(in); corner++ {
+		weight, index := 1., 0
+		for i := range in {
+			coord := base[i]
+			if corner&(1<<i) != 0 {
+				weight *= frac[i]
+				if coord < cl.grid[i]-1 {
+					coord++
+				}
+			} else {
+				weight *= 1 - frac[i]
+			}
+			index = index*cl.grid[i] + coord
+		}
+		if weight == 0 {
+			continue
+		}
+		for k := range out {
+			out[k] += weight * cl.values[index*cl.out+k]
+		}
+	}
+	return out
+}
+
+// iccLut is a BToA transform, whose elements are
+// applied in order, and may be nil
+type iccLut struct {
+	pcsLab    bool         // else XYZ
+	legacyLab bool         // the 16 bits Lab encoding of version 2 profiles
+	preMatrix *[12]float64 // the matrix of 'mft1' and 'mft2', only used for XYZ
+	bCurves   []iccCurve   // 'mBA' B curves, or 'mft1' and 'mft2' input tables
+	matrix    *[12]float64 // the matrix of 'mBA'
+	mCurves   []iccCurve   // 'mBA' M curves
+	clut      *iccCLUT     // may be nil for 'mBA'
+	aCurves   []iccCurve   // 'mBA' A curves, or 'mft1' and 'mft2' output tables
+	nbOutputs int
+}
+
+// encodePCS returns the normalized PCS values for the D50 [lab] color
+func (lut *iccLut) encodePCS(lab vec3) []float64 {
+	if lut.pcsLab {
+		if lut.legacyLab {
+			return []float64{lab[0] / 100 * 0xff00 / 0xffff, (lab[1] + 128) * 0x100 / 0xffff, (lab[2] + 128) * 0x100 / 0xffff}
+		}
+		return []float64{lab[0] / 100, (lab[1] + 128) / 255, (lab[2] + 128) / 255}
+	}
+	xyz := d65ToD50.apply(labToXYZ(lab))
+	return []float64{xyz[0] * 0x8000 / 0xffff, xyz[1] * 0x8000 / 0xffff, xyz[2] * 0x8000 / 0xffff}
+}
+
+func applyCurves(curves []iccCurve, values []float64) {
+	for i, c := range curves {
+		values[i] = c.eval(values[i])
+	}
+}
+
+func applyMatrix(m *[12]float64, v []float64) {
+	var out [3]float64
+	for i := range out {
+		out[i] = clamp01(m[3*i]*v[0] + m[3*i+1]*v[1] + m[3*i+2]*v[2] + m[9+i])
+	}
+	copy(v, out[:])
+}
+
+// eval returns the normalized device values of the D50 [lab] color
+func (lut *iccLut) eval(lab vec3) []float64 {
+	values := lut.encodePCS(lab)
+	if lut.preMatrix != nil && !lut.pcsLab {
+		applyMatrix(lut.preMatrix, values)
+	}
+	applyCurves(lut.bCurves, values)
+	if lut.matrix != nil {
+		applyMatrix(lut.matrix, values)
+	}
+	applyCurves(lut.mCurves, values)
+	if lut.clut != nil {
+		values = lut.clut.eval(values)
+	}
+	applyCurves(lut.aCurves, values)
+	for i, v := range values {
+		values[i] = clamp01(v)
+	}
+	return values
+}
+
+func clamp01(v float64) float64 { return math.Max(0, math.Min(1, v)) }
+
+func s15Fixed16(b []byte) float64 { return float64(int32(binary.BigEndian.Uint32(b))) / 65536 }
+
+// parseOutputProfile returns the BToA transform of the ICC profile [content],
+// selecting the table of the rendering intent of the header
+// (and defaulting to the perceptual one).
+func parseOutputProfile(content []byte) (*iccLut, error) {
+	if len(content) < 132 {
+		return nil, errors.New("invalid ICC profile header")
+	}
+	pcs := string(content[20:24])
+	if pcs != "Lab " && pcs != "XYZ " {
+		return nil, fmt.Errorf("unsupported PCS %q", pcs)
+	}
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(content[128:]))
+	if 132+12*count > len(content) {
+		return nil, errors.New("invalid ICC tag table")
+	}
+	for i := 0; i < count; i++ {
+		entry := content[132+12*i:]
+		offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		if uint64(offset)+uint64(size) > uint64(len(content)) {
+			return nil, fmt.Errorf("invalid ICC tag %q", entry[:4])
+		}
+		tags[string(entry[:4])] = content[offset : offset+size]
+	}
+
+	intent := binary.BigEndian.Uint32(content[64:])
+	if intent == 3 { // absolute colorimetric uses the relative table
+		intent = 1
+	}
+	data := tags[fmt.Sprintf("B2A%d", intent)]
+	if data == nil {
+		data = tags["B2A0"]
+	}
+	if data == nil {
+		return nil, errors.New("missing BToA table")
+	}
+
+	lut, err := parseLut(data)
+	if err != nil {
+		return nil, err
+	}
+	lut.pcsLab = pcs == "Lab "
+	return lut, nil
+}
+
+// parseLut parses a 'mft1', 'mft2' or 'mBA ' tag
+func parseLut(data []byte) (*iccLut, error) {
+	if len(data) < 12 {
+		return nil, errors.New("invalid BToA table")
+	}
+	switch typ := string(data[:4]); typ {
+	case "mft1", "mft2":
+		return parseMft(data, typ == "mft2")
+	case "mBA ":
+		return parseMBA(data)
+	default:
+		return nil, fmt.Errorf("unsupported BToA table type %q", typ)
+	}
+}
+
+func parseMft(data []byte, is16 bool) (*iccLut, error) {
+	if len(data) < 52 {
+		return nil, errors.New("invalid lut table")
+	}
+	nbIn, nbOut, gridPoints := int(data[8]), int(data[9]), int(data[10])
+	if nbIn != 3 || nbOut == 0 || gridPoints < 2 {
+		return nil, fmt.Errorf("invalid lut table dimensions (%d, %d, %d)", nbIn, nbOut, gridPoints)
+	}
+	var matrix [12]float64
+	for i := 0; i < 9; i++ {
+		matrix[i] = s15Fixed16(data[12+4*i:])
+	}
+
+	inEntries, outEntries, size, offset := 256, 256, 1, 48
+	if is16 {
+		inEntries, outEntries = int(binary.BigEndian.Uint16(data[48:])), int(binary.BigEndian.Uint16(data[50:]))
+		size, offset = 2, 52
+		if inEntries < 2 || outEntries < 2 {
+			return nil, errors.New("invalid lut table entries")
+		}
+	}
+	clutLen := nbOut
+	for i := 0; i < nbIn; i++ {
+		clutLen *= gridPoints
+	}
+	if len(data) < offset+size*(nbIn*inEntries+clutLen+nbOut*outEntries) {
+		return nil, errors.New("invalid lut table length")
+	}
+	read := func(n int) []float64 {
+		out := make([]float64, n)
+		for i := range out {
+			if is16 {
+				out[i] = float64(binary.BigEndian.Uint16(data[offset+2*i:])) / 0xffff
+			} else {
+				out[i] = float64(data[offset+i]) / 0xff
+			}
+		}
+		offset += size * n
+		return out
+	}
+	readCurves := func(nb, entries int) []iccCurve {
+		out := make([]iccCurve, nb)
+		for i := range out {
+			out[i] = sampledCurve(read(entries))
+		}
+		return out
+	}
+
+	out := &iccLut{legacyLab: is16, preMatrix: &matrix, nbOutputs: nbOut}
+	out.bCurves = readCurves(nbIn, inEntries)
+	out.clut = &iccCLUT{grid: []int{gridPoints, gridPoints, gridPoints}, out: nbOut, values: read(clutLen)}
+	out.aCurves = readCurves(nbOut, outEntries)
+	return out, nil
+}
+
+func parseMBA(data []byte) (*iccLut, error) {
+	if len(data) < 32 {
+		return nil, errors.New("invalid lutBToA table")
+	}
+	nbIn, nbOut := int(data[8]), int(data[9])
+	if nbIn != 3 || nbOut == 0 {
+		return nil, fmt.Errorf("invalid lutBToA table dimensions (%d, %d)", nbIn, nbOut)
+	}
+	offsets := [5]int{}
+	for i := range offsets {
+		offsets[i] = int(binary.BigEndian.Uint32(data[12+4*i:]))
+		if offsets[i] > len(data) {
+			return nil, errors.New("invalid lutBToA table offset")
+		}
+	}
+	bOffset, matrixOffset, mOffset, clutOffset, aOffset := offsets[0], offsets[1], offsets[2], offsets[3], offsets[4]
+
+	out := &iccLut{nbOutputs: nbOut}
+	var err error
+	if bOffset == 0 {
+		return nil, errors.New("missing B curves")
+	}
+	if out.bCurves, err = parseCurves(data[bOffset:], nbIn); err != nil {
+		return nil, err
+	}
+	if matrixOffset != 0 {
+		if len(data) < matrixOffset+48 {
+			return nil, errors.New("invalid lutBToA matrix")
+		}
+		var matrix [12]float64
+		for i := range matrix {
+			matrix[i] = s15Fixed16(data[matrixOffset+4*i:])
+		}
+		out.matrix = &matrix
+	}
+	if mOffset != 0 {
+		if out.mCurves, err = parseCurves(data[mOffset:], nbIn); err != nil {
+			return nil, err
+		}
+	}
+	if clutOffset != 0 {
+		if out.clut, err = parseCLUT(data[clutOffset:], nbIn, nbOut); err != nil {
+			return nil, err
+		}
+	} else if nbIn != nbOut {
+		return nil, errors.New("missing lutBToA CLUT")
+	}
+	if aOffset != 0 {
+		if out.aCurves, err = parseCurves(data[aOffset:], nbOut); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// parseCLUT parses the CLUT of a 'mBA ' tag
+func parseCLUT(data []byte, nbIn, nbOut int) (*iccCLUT, error) {
+	if len(data) < 20 {
+		return nil, errors.New("invalid CLUT")
+	}
+	out := &iccCLUT{grid: make([]int, nbIn), out: nbOut}
+	length := nbOut
+	for i := range out.grid {
+		out.grid[i] = int(data[i])
+		if out.grid[i] < 2 {
+			return nil, errors.New("invalid CLUT grid")
+		}
+		length *= out.grid[i]
+	}
+	precision := int(data[16])
+	if precision != 1 && precision != 2 {
+		return nil, fmt.Errorf("invalid CLUT precision %d", precision)
+	}
+	if len(data) < 20+precision*length {
+		return nil, errors.New("invalid CLUT length")
+	}
+	out.values = make([]float64, length)
+	for i := range out.values {
+		if precision == 2 {
+			out.values[i] = float64(binary.BigEndian.Uint16(data[20+2*i:])) / 0xffff
+		} else {
+			out.values[i] = float64(data[20+i]) / 0xff
+		}
+	}
+	return out, nil
+}
+
+// parseCurves parses [nb] consecutive 'curv' or 'para' elements, 4 bytes aligned
+func parseCurves(data []byte, nb int) ([]iccCurve, error) {
+	out := make([]iccCurve, nb)
+	offset := 0
+	for i := range out {
+		curve, size, err := parseCurve(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		out[i] = curve
+		offset += (size + 3) / 4 * 4
+		if offset > len(data) {
+			offset = len(data)
+		}
+	}
+	return out, nil
+}
+
+// parseCurve returns the curve and its length in bytes
+func parseCurve(data []byte) (iccCurve, int, error) {
+	if len(data) < 12 {
+		return nil, 0, errors.New("invalid curve")
+	}
+	switch typ := string(data[:4]); typ {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(data[8:]))
+		size := 12 + 2*n
+		if len(data) < size {
+			return nil, 0, errors.New("invalid curve length")
+		}
+		switch n {
+		case 0:
+			return gammaCurve(1), size, nil
+		case 1:
+			return gammaCurve(float64(binary.BigEndian.Uint16(data[12:])) / 256), size, nil
+		}
+		out := make(sampledCurve, n)
+		for i := range out {
+			out[i] = float64(binary.BigEndian.Uint16(data[12+2*i:])) / 0xffff
+		}
+		return out, size, nil
+	case "para":
+		function := binary.BigEndian.Uint16(data[8:])
+		nbParams := [5]int{1, 3, 4, 5, 7}
+		if function >= uint16(len(nbParams)) {
+			return nil, 0, fmt.Errorf("invalid parametric curve type %d", function)
+		}
+		size := 12 + 4*nbParams[function]
+		if len(data) < size {
+			return nil, 0, errors.New("invalid parametric curve length")
+		}
+		out := parametricCurve{function: function}
+		for i := 0; i < nbParams[function]; i++ {
+			out.params[i] = s15Fixed16(data[12+4*i:])
+		}
+		return out, size, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported curve type %q", typ)
+	}
+}
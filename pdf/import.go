@@ -0,0 +1,214 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/utils"
+)
+
+// PDF files are supported as images (for instance with <img src="diagram.pdf">
+// or background-image: url(letterhead.pdf)): the first page is embedded as
+// a form XObject, so that it stays vector.
+// See [PDFPageFetcher] to select another page.
+//
+// The colors of the imported pages are not converted by the color options
+// (like [Options.GrayOutput]).
+const pdfMimeType = "image/pdf"
+
+func init() {
+	// the image loader of the style engine uses [image.DecodeConfig]
+	// to find the intrinsic size
+	image.RegisterFormat("pdf", "%PDF-", decodePDF, decodePDFConfig)
+}
+
+func decodePDF(io.Reader) (image.Image, error) {
+	return nil, errors.New("PDF files are embedded as vector graphics and can't be decoded")
+}
+
+// decodePDFConfig returns the size of the first page, in CSS pixels
+func decodePDFConfig(r io.Reader) (image.Config, error) {
+	page, err := readPDFPage(r, 1)
+	if err != nil {
+		return image.Config{}, err
+	}
+	w, h := page.size()
+	const pxPerPt = 4. / 3
+	return image.Config{
+		ColorModel: color.RGBAModel,
+		Width:      int(math.Round(float64(w * pxPerPt))),
+		Height:     int(math.Round(float64(h * pxPerPt))),
+	}, nil
+}
+
+// importedPage is a page of an existing PDF file,
+// with the inherited attributes resolved
+type importedPage struct {
+	model.PageObject
+	box model.Rectangle // CropBox or MediaBox
+}
+
+// readPDFPage parses the PDF file and returns the page with the
+// given number (starting at 1)
+func readPDFPage(r io.Reader, pageNumber int) (importedPage, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return importedPage{}, err
+	}
+	doc, _, err := reader.ParsePDFReader(bytes.NewReader(content), reader.Options{})
+	if err != nil {
+		return importedPage{}, fmt.Errorf("invalid PDF file: %s", err)
+	}
+	pages := leafPages(&doc.Catalog.Pages, nil, nil)
+	if pageNumber < 1 || pageNumber > len(pages) {
+		return importedPage{}, fmt.Errorf("invalid page number %d (for %d pages)", pageNumber, len(pages))
+	}
+	return newImportedPage(pages[pageNumber-1]), nil
+}
+
+// newImportedPage uses the CropBox (or MediaBox) of [page]
+func newImportedPage(page *model.PageObject) importedPage {
+	return importedPage{PageObject: *page, box: targetBox(page)}
+}
+
+// size returns the displayed dimensions of the page
+func (p importedPage) size() (w, h fl) {
+	w, h = p.box.Width(), p.box.Height()
+	if p.quarterTurns()%2 == 1 {
+		return h, w
+	}
+	return w, h
+}
+
+// quarterTurns returns the clockwise rotation of the page, in [0, 3]
+func (p importedPage) quarterTurns() int { return quarterTurns(p.Rotate) }
+
+// quarterTurns returns the clockwise rotation [r], in [0, 3]
+func quarterTurns(r model.Rotation) int {
+	return ((r.Degrees()/90)%4 + 4) % 4
+}
+
+// formObject returns the page content as a form XObject,
+// mapping the (rotated) page to [0, w] x [0, h], as returned by [importedPage.size]
+func (p importedPage) formObject() *model.XObjectForm {
+	out := pageToForm(&p.PageObject)
+	box := p.box
+	out.BBox = box
+	switch p.quarterTurns() {
+	case 0:
+		out.Matrix = model.Matrix{1, 0, 0, 1, -box.Llx, -box.Lly}
+	case 1:
+		out.Matrix = model.Matrix{0, -1, 1, 0, -box.Lly, box.Urx}
+	case 2:
+		out.Matrix = model.Matrix{-1, 0, 0, -1, box.Urx, box.Ury}
+	case 3:
+		out.Matrix = model.Matrix{0, 1, -1, 0, box.Ury, -box.Llx}
+	}
+	return out
+}
+
+// drawPDFPage draws the first page of the PDF file in [img],
+// scaled to (width, height)
+func (g *group) drawPDFPage(img backend.RasterImage, width, height fl) {
+	form, has := g.pdfPages[img.ID]
+	if !has {
+		page, err := readPDFPage(img.Content, 1)
+		if err != nil {
+			log.Printf("failed to import PDF page: %s", err)
+			return
+		}
+		w, h := page.size()
+		form = pdfPageForm{form: page.formObject(), width: w, height: h}
+		g.pdfPages[img.ID] = form
+	}
+	if form.width <= 0 || form.height <= 0 {
+		return
+	}
+	g.app.SaveState()
+	// the page is in PDF coordinates, with y growing upward
+	g.app.Transform(model.Matrix{width / form.width, 0, 0, -height / form.height, 0, height})
+	g.app.AddXObject(form.form)
+	g.app.RestoreState()
+}
+
+// pdfPageForm is an imported page, with its dimensions
+type pdfPageForm struct {
+	form          *model.XObjectForm
+	width, height fl
+}
+
+// PDFPageFetcher wraps [fetcher] to support the selection of the page
+// of PDF images, with the #page=N URL fragment (the first page is used by default).
+func PDFPageFetcher(fetcher utils.UrlFetcher) utils.UrlFetcher {
+	return func(urlTarget string) (utils.RemoteRessource, error) {
+		out, err := fetcher(urlTarget)
+		if err != nil {
+			return out, err
+		}
+		pageNumber := fragmentPage(urlTarget)
+		if pageNumber <= 1 || out.Content == nil {
+			return out, nil
+		}
+		content, err := io.ReadAll(out.Content)
+		if err != nil {
+			return out, err
+		}
+		if !bytes.HasPrefix(content, []byte("%PDF-")) {
+			out.Content = bytes.NewReader(content)
+			return out, nil
+		}
+		content, err = extractPage(content, pageNumber)
+		if err != nil {
+			return out, fmt.Errorf("invalid PDF image %s: %s", urlTarget, err)
+		}
+		out.Content = bytes.NewReader(content)
+		return out, nil
+	}
+}
+
+// fragmentPage returns the page number of the #page=N fragment, or 0
+func fragmentPage(urlTarget string) int {
+	if strings.HasPrefix(strings.ToLower(urlTarget), "data:") {
+		return 0
+	}
+	u, err := url.Parse(urlTarget)
+	if err != nil {
+		return 0
+	}
+	// the fragment may contain other parameters, as in #page=2&zoom=100
+	for _, param := range strings.Split(u.Fragment, "&") {
+		if value := strings.TrimPrefix(param, "page="); value != param {
+			n, _ := strconv.Atoi(value)
+			return n
+		}
+	}
+	return 0
+}
+
+// extractPage returns a PDF file containing only the page [pageNumber]
+func extractPage(content []byte, pageNumber int) ([]byte, error) {
+	page, err := readPDFPage(bytes.NewReader(content), pageNumber)
+	if err != nil {
+		return nil, err
+	}
+	obj := page.PageObject
+	obj.MediaBox, obj.CropBox = &page.box, nil
+	obj.Annots = nil // may refer to the other pages
+	var doc model.Document
+	doc.Catalog.Pages.Kids = []model.PageNode{&obj}
+	var out bytes.Buffer
+	err = doc.Write(&out, nil)
+	return out.Bytes(), err
+}
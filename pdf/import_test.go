@@ -0,0 +1,138 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/html/document"
+	"github.com/benoitkugler/webrender/html/tree"
+	"github.com/benoitkugler/webrender/utils"
+)
+
+// writeTestPDF returns a file with two pages, of sizes 300x150 and 150x300 points
+func writeTestPDF(t *testing.T) string {
+	t.Helper()
+	output := NewOutput()
+	for _, size := range [2][2]fl{{300, 150}, {150, 300}} {
+		page := output.AddPage(0, 0, size[0], size[1])
+		page.SetMediaBox(0, 0, size[0], size[1])
+		page.Rectangle(0, 0, 10, 10)
+		page.Paint(backend.FillNonZero)
+	}
+	var buf bytes.Buffer
+	if err := output.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "diagram.pdf")
+	if err := os.WriteFile(path, buf.Bytes(), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFragmentPage(t *testing.T) {
+	for url, exp := range map[string]int{
+		"file:///diagram.pdf":                   0,
+		"file:///diagram.pdf#page=3":            3,
+		"https://a.b/diagram.pdf#page=2&zoom=5": 2,
+		"data:application/pdf,%PDF-#page=2":     0,
+	} {
+		if got := fragmentPage(url); got != exp {
+			t.Fatalf("for %s, expected %d, got %d", url, exp, got)
+		}
+	}
+}
+
+func TestPDFImageSize(t *testing.T) {
+	content, err := os.ReadFile(writeTestPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "pdf" || config.Width != 400 || config.Height != 200 {
+		t.Fatalf("unexpected config %s %v", format, config)
+	}
+
+	second, err := extractPage(content, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config, _, _ = image.DecodeConfig(bytes.NewReader(second)); config.Width != 200 || config.Height != 400 {
+		t.Fatalf("unexpected config %v", config)
+	}
+
+	rotated := importedPage{box: model.Rectangle{Llx: 10, Lly: 20, Urx: 110, Ury: 70}}
+	rotated.Rotate = model.NewRotation(90)
+	if w, h := rotated.size(); w != 50 || h != 100 {
+		t.Fatalf("unexpected size %g %g", w, h)
+	}
+	// the bottom left corner is displayed at the top left
+	if m := rotated.formObject().Matrix; m != (model.Matrix{0, -1, 1, 0, -20, 110}) {
+		t.Fatalf("unexpected matrix %v", m)
+	}
+
+	// the rotation read from a file
+	var doc model.Document
+	doc.Catalog.Pages.Kids = []model.PageNode{&model.PageObject{MediaBox: &model.Rectangle{Urx: 300, Ury: 150}, Rotate: model.NewRotation(270)}}
+	var buf bytes.Buffer
+	if err = doc.Write(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	page, err := readPDFPage(&buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := page.size(); page.quarterTurns() != 3 || w != 150 || h != 300 {
+		t.Fatalf("unexpected rotated page %d %g %g", page.quarterTurns(), w, h)
+	}
+}
+
+func TestPDFImage(t *testing.T) {
+	path := writeTestPDF(t)
+	html := fmt.Sprintf(`<style>@page { size: 600px 600px; margin: 0 }</style>
+		<img src="file://%s"><img src="file://%s#page=2" style="width: 100px">`, path, path)
+	parsedHtml, err := tree.NewHTML(utils.InputString(html), ".", PDFPageFetcher(utils.DefaultUrlFetcher), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedHtml.UAStyleSheet = tree.TestUAStylesheet
+	output := NewOutput()
+	doc := document.Render(parsedHtml, nil, false, fontconfig)
+	doc.Write(output, 1, nil)
+	pdfDoc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := pdfDoc.Catalog.Pages.Kids[0].(*model.PageObject)
+	var forms []*model.XObjectForm
+	for _, xo := range page.Resources.XObject {
+		if form, ok := xo.(*model.XObjectForm); ok {
+			forms = append(forms, form)
+		}
+	}
+	if len(forms) != 2 {
+		t.Fatalf("expected two imported pages, got %v", page.Resources.XObject)
+	}
+	content, err := page.Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the canvas uses CSS pixels: the first page has its natural size (400px),
+	// the second is scaled to 100px
+	for _, op := range []string{"1.33333 0 0 -1.33333 0 200 cm", "0.66667 0 0 -0.66667 0 200 cm"} {
+		if !strings.Contains(string(content), op) {
+			t.Fatalf("missing %q in %s", op, content)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package pdf
+
+import (
+	"log"
+	"math"
+
+	cs "github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/pdf/model"
+)
+
+// ImpositionLayout defines how the pages are placed on the sheets
+type ImpositionLayout uint8
+
+const (
+	// TwoUp places two pages side by side on each sheet
+	TwoUp ImpositionLayout = iota + 1
+	// FourUp places four pages on each sheet, in two rows
+	FourUp
+	// Booklet places two pages on each side of the sheets,
+	// so that folding the stacked sheets in the middle produces a
+	// saddle-stitched booklet. The document is padded with blank pages
+	// to a multiple of 4, and the sheets are written front then back.
+	Booklet
+)
+
+// Imposition lays out the pages of the document on bigger sheets.
+// The pages are embedded as isolated transparency groups, clipped to their
+// TrimBox (or MediaBox) and scaled down if needed.
+// As a consequence, it may not be used with [PrinterMarks].
+type Imposition struct {
+	Layout ImpositionLayout
+
+	// SheetWidth and SheetHeight are the dimensions of the sheets, in points.
+	// If zero, the sheets are just big enough for the pages (and gutters), without scaling.
+	SheetWidth, SheetHeight fl
+
+	// Gutter is the space between the pages, in points
+	Gutter fl
+
+	// Creep is the shift, in points, applied towards the spine to the pages of
+	// the innermost sheet of a booklet, compensating the thickness of the
+	// folded paper. The shift decreases linearly to zero for the outermost sheet.
+	Creep fl
+}
+
+// grid returns the number of columns and rows of the sheets
+func (imp *Imposition) grid() (cols, rows int) {
+	if imp.Layout == FourUp {
+		return 2, 2
+	}
+	return 2, 1
+}
+
+// placement is the position of a page on a sheet
+type placement struct {
+	sheet *model.PageObject
+	// the page space to sheet space transformation
+	scale, tx, ty fl
+}
+
+func (p placement) apply(x, y fl) (fl, fl) {
+	return p.scale*x + p.tx, p.scale*y + p.ty
+}
+
+// visibleBox returns the box of the page to impose
+func visibleBox(page *model.PageObject) model.Rectangle {
+	if page.TrimBox != nil {
+		return *page.TrimBox
+	}
+	if page.MediaBox != nil {
+		return *page.MediaBox
+	}
+	return model.Rectangle{}
+}
+
+// pageToForm returns the content of [page] as a form XObject
+func pageToForm(page *model.PageObject) *model.XObjectForm {
+	out := &model.XObjectForm{BBox: visibleBox(page)}
+	if page.Resources != nil {
+		out.Resources = *page.Resources
+	}
+	switch len(page.Contents) {
+	case 0:
+	case 1:
+		out.ContentStream = page.Contents[0]
+	default: // concatenate the streams
+		var content []byte
+		for _, ct := range page.Contents {
+			decoded, err := ct.Decode()
+			if err != nil {
+				log.Printf("invalid page content: %s", err)
+				continue
+			}
+			content = append(append(content, decoded...), '\n')
+		}
+		out.ContentStream = model.ContentStream{Stream: model.NewCompressedStream(content)}
+	}
+	return out
+}
+
+// bookletOrder returns the page indices (or -1 for blank pages)
+// on each side of the booklet sheets, from left to right
+func bookletOrder(nbPages int) [][2]int {
+	n := (nbPages + 3) / 4 * 4
+	index := func(i int) int {
+		if i >= nbPages {
+			return -1
+		}
+		return i
+	}
+	var out [][2]int
+	for s := 0; s < n/4; s++ {
+		out = append(out,
+			[2]int{index(n - 1 - 2*s), index(2 * s)},   // front
+			[2]int{index(2*s + 1), index(n - 2 - 2*s)}, // back
+		)
+	}
+	return out
+}
+
+// impose returns the sheets, and updates the destinations
+// pointing to [pages]
+func (c *Output) impose(imp *Imposition, pages []*model.PageObject) []model.PageNode {
+	if len(pages) == 0 {
+		return nil
+	}
+	cols, rows := imp.grid()
+
+	// the slots of each sheet, with -1 for blank pages
+	var sheets [][]int
+	if imp.Layout == Booklet {
+		for _, side := range bookletOrder(len(pages)) {
+			sheets = append(sheets, side[:])
+		}
+	} else {
+		perSheet := cols * rows
+		for start := 0; start < len(pages); start += perSheet {
+			var sheet []int
+			for i := start; i < start+perSheet; i++ {
+				if i < len(pages) {
+					sheet = append(sheet, i)
+				} else {
+					sheet = append(sheet, -1)
+				}
+			}
+			sheets = append(sheets, sheet)
+		}
+	}
+
+	// the cell size is given by the biggest page
+	var pageW, pageH fl
+	for _, page := range pages {
+		box := visibleBox(page)
+		pageW = fl(math.Max(float64(pageW), float64(box.Width())))
+		pageH = fl(math.Max(float64(pageH), float64(box.Height())))
+	}
+	sheetW, sheetH := imp.SheetWidth, imp.SheetHeight
+	if sheetW == 0 || sheetH == 0 {
+		sheetW = fl(cols)*pageW + fl(cols-1)*imp.Gutter
+		sheetH = fl(rows)*pageH + fl(rows-1)*imp.Gutter
+	}
+	cellW := (sheetW - fl(cols-1)*imp.Gutter) / fl(cols)
+	cellH := (sheetH - fl(rows-1)*imp.Gutter) / fl(rows)
+
+	placements := make(map[*model.PageObject]placement)
+	out := make([]model.PageNode, len(sheets))
+	for s, slots := range sheets {
+		sheet := &model.PageObject{MediaBox: &model.Rectangle{Llx: 0, Lly: 0, Urx: sheetW, Ury: sheetH}}
+		app := cs.NewGraphicStream(*sheet.MediaBox)
+		for slot, index := range slots {
+			if index == -1 {
+				continue // blank page
+			}
+			page := pages[index]
+			box := visibleBox(page)
+			if box.Width() <= 0 || box.Height() <= 0 {
+				continue
+			}
+			col, row := slot%cols, slot/cols
+			scale := fl(math.Min(1, math.Min(float64(cellW/box.Width()), float64(cellH/box.Height()))))
+			w, h := scale*box.Width(), scale*box.Height()
+			// the first row is at the top of the sheet
+			cellX, cellY := fl(col)*(cellW+imp.Gutter), sheetH-fl(row+1)*cellH-fl(row)*imp.Gutter
+			x, y := cellX+(cellW-w)/2, cellY+(cellH-h)/2
+			if imp.Layout == Booklet {
+				// pages are aligned on the spine
+				shift := imp.creep(s/2, len(sheets)/2)
+				if col == 0 {
+					x = cellX + cellW - w + shift
+				} else {
+					x = cellX - shift
+				}
+			}
+			p := placement{sheet: sheet, scale: scale, tx: x - scale*box.Llx, ty: y - scale*box.Lly}
+			placements[page] = p
+
+			app.SaveState()
+			app.Transform(model.Matrix{p.scale, 0, 0, p.scale, p.tx, p.ty})
+			// keep the page compositing, as if the page were not imposed
+			app.AddXObject(&model.XObjectTransparencyGroup{
+				XObjectForm: *pageToForm(page),
+				CS:          c.cache.blendingSpace(),
+				I:           true,
+			})
+			app.RestoreState()
+
+			for _, annot := range page.Annots {
+				// the page annotations are left untouched, so that imposing again is safe
+				moved := *annot
+				moved.Rect.Llx, moved.Rect.Lly = p.apply(annot.Rect.Llx, annot.Rect.Lly)
+				moved.Rect.Urx, moved.Rect.Ury = p.apply(annot.Rect.Urx, annot.Rect.Ury)
+				sheet.Annots = append(sheet.Annots, &moved)
+			}
+		}
+		app.ApplyToPageObject(sheet, compressStreams)
+		out[s] = sheet
+	}
+
+	c.moveDestinations(placements)
+	return out
+}
+
+// creep returns the shift of the pages of the booklet
+// sheet [index], out of [nbSheets]
+func (imp *Imposition) creep(index, nbSheets int) fl {
+	if nbSheets <= 1 {
+		return 0
+	}
+	return imp.Creep * fl(index) / fl(nbSheets-1)
+}
+
+// moveDestinations updates the anchors and the bookmarks
+// to point to the sheets
+func (c *Output) moveDestinations(placements map[*model.PageObject]placement) {
+	move := func(dest model.DestinationExplicit) model.DestinationExplicit {
+		explicit, ok := dest.(model.DestinationExplicitIntern)
+		if !ok {
+			return dest
+		}
+		p, ok := placements[explicit.Page]
+		if !ok {
+			return dest
+		}
+		explicit.Page = p.sheet
+		if xyz, ok := explicit.Location.(model.DestinationLocationXYZ); ok {
+			left, _ := xyz.Left.(model.ObjFloat)
+			top, _ := xyz.Top.(model.ObjFloat)
+			x, y := p.apply(fl(left), fl(top))
+			xyz.Left, xyz.Top = model.ObjFloat(x), model.ObjFloat(y)
+			explicit.Location = xyz
+		}
+		return explicit
+	}
+
+	names := c.document.Catalog.Names.Dests.Names
+	for i := range names {
+		names[i].Destination = move(names[i].Destination)
+	}
+	if outline := c.document.Catalog.Outlines; outline != nil {
+		var walk func(item *model.OutlineItem)
+		walk = func(item *model.OutlineItem) {
+			for ; item != nil; item = item.Next {
+				if dest, ok := item.Dest.(model.DestinationExplicit); ok {
+					item.Dest = move(dest)
+				}
+				walk(item.First)
+			}
+		}
+		walk(outline.First)
+	}
+}
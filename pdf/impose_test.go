@@ -0,0 +1,166 @@
+package pdf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+)
+
+func TestBookletOrder(t *testing.T) {
+	got := bookletOrder(6)
+	exp := [][2]int{{-1, 0}, {1, -1}, {5, 2}, {3, 4}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if got := bookletOrder(4); !reflect.DeepEqual(got, [][2]int{{3, 0}, {1, 2}}) {
+		t.Fatalf("unexpected order %v", got)
+	}
+}
+
+func TestImposition(t *testing.T) {
+	newOutput := func(nbPages int, imp *Imposition) *Output {
+		output := NewOutput()
+		output.Options.Imposition = imp
+		for i := 0; i < nbPages; i++ {
+			page := output.AddPage(0, 0, 100, 200).(*outputPage)
+			page.SetMediaBox(0, 0, 100, 200)
+			page.Rectangle(10, 10, 20, 20)
+			page.Paint(backend.FillNonZero)
+		}
+		return output
+	}
+
+	output := newOutput(5, &Imposition{Layout: FourUp, Gutter: 10})
+	output.CreateAnchors([][]backend.Anchor{nil, {{Name: "second", X: 10, Y: 50}}, nil, nil, nil})
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if L := len(doc.Catalog.Pages.Kids); L != 2 {
+		t.Fatalf("expected 2 sheets, got %d", L)
+	}
+	sheet := doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	if exp := (model.Rectangle{Urx: 210, Ury: 410}); *sheet.MediaBox != exp {
+		t.Fatalf("unexpected sheet %v", *sheet.MediaBox)
+	}
+	if L := len(sheet.Resources.XObject); L != 4 {
+		t.Fatalf("expected 4 pages on the sheet, got %d", L)
+	}
+	for _, xo := range sheet.Resources.XObject {
+		if group, ok := xo.(*model.XObjectTransparencyGroup); !ok || !group.I || group.CS == nil {
+			t.Fatalf("expected an isolated transparency group, got %T", xo)
+		}
+	}
+	content, err := sheet.Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second page is at the top right
+	if !strings.Contains(string(content), "1 0 0 1 110 210 cm") {
+		t.Fatalf("unexpected content %s", content)
+	}
+	dest := doc.Catalog.Names.Dests.Names[0].Destination.(model.DestinationExplicitIntern)
+	if dest.Page != sheet {
+		t.Fatal("destination should point to the sheet")
+	}
+	if loc := dest.Location.(model.DestinationLocationXYZ); loc.Left != model.ObjFloat(120) || loc.Top != model.ObjFloat(260) {
+		t.Fatalf("unexpected location %v", loc)
+	}
+
+	// the marks would be clipped to the TrimBox
+	output = newOutput(1, &Imposition{Layout: TwoUp})
+	output.Options.PrinterMarks = &PrinterMarks{Crop: true}
+	if _, err = output.Finalize(); err == nil {
+		t.Fatal("expected an error for printer marks with imposition")
+	}
+
+	// on A4 landscape, with creep
+	output = newOutput(8, &Imposition{Layout: Booklet, SheetWidth: 842, SheetHeight: 595, Creep: 2})
+	doc, err = output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if L := len(doc.Catalog.Pages.Kids); L != 4 {
+		t.Fatalf("expected 4 sheet sides, got %d", L)
+	}
+	for i, exp := range []string{
+		"1 0 0 1 321 197.5 cm", // outermost sheet, page 8 against the spine
+		"1 0 0 1 421 197.5 cm",
+		"1 0 0 1 323 197.5 cm", // innermost sheet, shifted
+		"1 0 0 1 419 197.5 cm",
+	} {
+		sheet := doc.Catalog.Pages.Kids[i].(*model.PageObject)
+		content, err := sheet.Contents[0].Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), exp) {
+			t.Fatalf("missing %q in %s", exp, content)
+		}
+	}
+
+	// the innermost sheet of a longer booklet is shifted by the whole creep
+	output = newOutput(12, &Imposition{Layout: Booklet, SheetWidth: 842, SheetHeight: 595, Creep: 2})
+	doc, err = output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if L := len(doc.Catalog.Pages.Kids); L != 6 {
+		t.Fatalf("expected 6 sheet sides, got %d", L)
+	}
+	for i, exp := range [][2]string{
+		{"1 0 0 1 321 197.5 cm", "1 0 0 1 421 197.5 cm"}, // outermost sheet
+		{"1 0 0 1 322 197.5 cm", "1 0 0 1 420 197.5 cm"},
+		{"1 0 0 1 323 197.5 cm", "1 0 0 1 419 197.5 cm"}, // innermost sheet
+	} {
+		for _, side := range doc.Catalog.Pages.Kids[2*i : 2*i+2] {
+			content, err := side.(*model.PageObject).Contents[0].Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), exp[0]) || !strings.Contains(string(content), exp[1]) {
+				t.Fatalf("sheet %d: missing %q in %s", i, exp, content)
+			}
+		}
+	}
+
+	// scaled down to fit the sheets
+	output = newOutput(2, &Imposition{Layout: TwoUp, SheetWidth: 100, SheetHeight: 100})
+	doc, err = output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet = doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	if content, _ = sheet.Contents[0].Decode(); !strings.Contains(string(content), "0.5 0 0 0.5 50 0 cm") {
+		t.Fatalf("unexpected content %s", content)
+	}
+}
+
+func TestImposeAnnotations(t *testing.T) {
+	output := NewOutput()
+	for i := 0; i < 2; i++ {
+		page := output.AddPage(0, 0, 100, 200).(*outputPage)
+		page.SetMediaBox(0, 0, 100, 200)
+		page.AddExternalLink(10, 20, 30, 40, "https://example.com")
+		page.finalize()
+	}
+	pages := []*model.PageObject{&output.pages[0].page, &output.pages[1].page}
+
+	// imposing several times must not move the annotations twice
+	for _, imp := range []*Imposition{{Layout: TwoUp}, {Layout: TwoUp, Gutter: 10}} {
+		sheets := output.impose(imp, pages)
+		annots := sheets[0].(*model.PageObject).Annots
+		if len(annots) != 2 {
+			t.Fatalf("expected 2 annotations, got %d", len(annots))
+		}
+		if exp := (model.Rectangle{Llx: 110 + imp.Gutter, Lly: 20, Urx: 130 + imp.Gutter, Ury: 40}); annots[1].Rect != exp {
+			t.Fatalf("expected %v, got %v", exp, annots[1].Rect)
+		}
+	}
+	if exp := (model.Rectangle{Llx: 10, Lly: 20, Urx: 30, Ury: 40}); output.pages[1].page.Annots[0].Rect != exp {
+		t.Fatalf("page annotation should not be modified, got %v", output.pages[1].page.Annots[0].Rect)
+	}
+}
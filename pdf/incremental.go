@@ -0,0 +1,420 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader/file"
+	"github.com/benoitkugler/pdf/reader/parser"
+)
+
+// AppendTo finalizes the document and writes the PDF file [original], followed
+// by an incremental update adding the pages of the document after the existing pages.
+//
+// The original bytes are preserved, so that existing digital signatures stay valid.
+// The metadata of the document (like the title), if set, replace the existing ones,
+// and the modification date is updated.
+// Internal links, anchors and bookmarks of the appended pages are not kept.
+// Encrypted files are not supported.
+func (c *Output) AppendTo(original []byte, w io.Writer) error {
+	doc, err := c.Finalize()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = c.writeDocument(doc, &buf); err != nil {
+		return err
+	}
+	added, err := parseRawPDF(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("invalid PDF output: %s", err)
+	}
+
+	f, err := file.Read(bytes.NewReader(original), nil)
+	if err != nil {
+		return fmt.Errorf("invalid PDF file: %s", err)
+	}
+	if f.Encrypt != nil {
+		return errors.New("incremental updates of encrypted files are not supported")
+	}
+	last, err := readLastXref(original)
+	if err != nil {
+		return err
+	}
+	update := incrementalUpdate{
+		original: rawPDF{objects: f.XrefTable, root: f.Root.ObjectNumber},
+		added:    added,
+		changed:  make(map[int]model.Object),
+		numbers:  make(map[int]int),
+	}
+	if f.Info != nil {
+		update.original.info = f.Info.ObjectNumber
+	}
+	for n := range f.XrefTable {
+		if n >= update.next {
+			update.next = n + 1
+		}
+	}
+	if err = update.appendPages(); err != nil {
+		return err
+	}
+	update.mergeCatalog()
+	update.mergeInfo()
+
+	return update.write(original, last, w)
+}
+
+// lastXref is the last cross-reference section of a file
+type lastXref struct {
+	offset   int
+	isStream bool      // true for a cross-reference stream
+	id       [2]string // empty if absent
+}
+
+// readLastXref locates and parses the trailer of the last cross-reference section of [content]
+func readLastXref(content []byte) (lastXref, error) {
+	offset, err := lastXrefOffset(content)
+	if err != nil {
+		return lastXref{}, err
+	}
+	if offset < 0 || offset >= len(content) {
+		return lastXref{}, fmt.Errorf("invalid PDF file: invalid startxref offset %d", offset)
+	}
+	out := lastXref{offset: offset}
+	section := content[offset:]
+	var trailer model.Object
+	if bytes.HasPrefix(section, []byte("xref")) {
+		index := bytes.Index(section, []byte("trailer"))
+		if index == -1 {
+			return lastXref{}, errors.New("invalid PDF file: missing trailer")
+		}
+		trailer, err = parser.ParseObject(section[index+len("trailer"):])
+	} else { // cross-reference stream
+		out.isStream = true
+		_, _, trailer, err = parser.ParseObjectDefinition(section, false)
+	}
+	dict, ok := trailer.(model.ObjDict)
+	if err != nil || !ok {
+		return lastXref{}, fmt.Errorf("invalid PDF file: invalid trailer %v", err)
+	}
+	if id, _ := dict["ID"].(model.ObjArray); len(id) == 2 {
+		out.id[0], _ = file.IsString(id[0])
+		out.id[1], _ = file.IsString(id[1])
+	}
+	return out, nil
+}
+
+// lastXrefOffset returns the offset of the last cross-reference section
+func lastXrefOffset(content []byte) (int, error) {
+	index := bytes.LastIndex(content, []byte("startxref"))
+	if index == -1 {
+		return 0, errors.New("invalid PDF file: missing startxref")
+	}
+	fields := bytes.Fields(content[index+len("startxref"):])
+	if len(fields) == 0 {
+		return 0, errors.New("invalid PDF file: missing startxref offset")
+	}
+	return strconv.Atoi(string(fields[0]))
+}
+
+// incrementalUpdate stores the objects written after the original file
+type incrementalUpdate struct {
+	original, added rawPDF
+
+	changed map[int]model.Object // new versions of the original objects, and added objects
+	numbers map[int]int          // from added to written object numbers
+	next    int                  // next free object number
+}
+
+// importRef copies the object [ref] from [added], with its references,
+// returning the new reference
+func (u *incrementalUpdate) importRef(ref model.ObjIndirectRef) model.ObjIndirectRef {
+	if n, ok := u.numbers[ref.ObjectNumber]; ok {
+		return model.ObjIndirectRef{ObjectNumber: n}
+	}
+	n := u.next
+	u.next++
+	u.numbers[ref.ObjectNumber] = n
+	u.changed[n] = u.importObject(u.added.objects[ref.ObjectNumber])
+	return model.ObjIndirectRef{ObjectNumber: n}
+}
+
+// importObject returns a copy of [obj], with its references updated
+func (u *incrementalUpdate) importObject(obj model.Object) model.Object {
+	switch obj := obj.(type) {
+	case model.ObjIndirectRef:
+		return u.importRef(obj)
+	case model.ObjDict:
+		out := make(model.ObjDict, len(obj))
+		for k, v := range obj {
+			out[k] = u.importObject(v)
+		}
+		return out
+	case model.ObjArray:
+		out := make(model.ObjArray, len(obj))
+		for i, v := range obj {
+			out[i] = u.importObject(v)
+		}
+		return out
+	case model.ObjStream:
+		return model.ObjStream{Args: u.importObject(obj.Args).(model.ObjDict), Content: obj.Content}
+	default:
+		return obj
+	}
+}
+
+// modify returns the (copied) dictionary of the original object [number],
+// which is written in the update
+func (u *incrementalUpdate) modify(number int) (model.ObjDict, error) {
+	if dict, ok := u.changed[number].(model.ObjDict); ok {
+		return dict, nil
+	}
+	dict, ok := u.original.objects[number].(model.ObjDict)
+	if !ok {
+		return nil, fmt.Errorf("invalid PDF file: object %d is not a dictionary", number)
+	}
+	dict = dict.Clone().(model.ObjDict)
+	u.changed[number] = dict
+	return dict, nil
+}
+
+// appendPages adds the pages of [added] to the root of the original page tree
+func (u *incrementalUpdate) appendPages() error {
+	rootRef, ok := u.original.catalog()["Pages"].(model.ObjIndirectRef)
+	if !ok {
+		return errors.New("invalid PDF file: missing page tree")
+	}
+	root, err := u.modify(rootRef.ObjectNumber)
+	if err != nil {
+		return err
+	}
+	addedRoot, _ := u.added.resolve(u.added.catalog()["Pages"]).(model.ObjDict)
+	addedKids, _ := u.added.resolve(addedRoot["Kids"]).(model.ObjArray)
+
+	kids, _ := u.original.resolve(root["Kids"]).(model.ObjArray)
+	kids = append(model.ObjArray(nil), kids...)
+	count, _ := u.original.resolve(root["Count"]).(model.ObjInt)
+	for _, kid := range addedKids {
+		ref, ok := kid.(model.ObjIndirectRef)
+		if !ok {
+			continue
+		}
+		page, _ := u.added.objects[ref.ObjectNumber].(model.ObjDict)
+		if page == nil {
+			continue
+		}
+		// avoid inheriting the attributes of the original tree
+		page = page.Clone().(model.ObjDict)
+		delete(page, "Parent")
+		if _, has := page["Resources"]; !has {
+			page["Resources"] = model.ObjDict{}
+		}
+		if _, has := root["Rotate"]; has {
+			page["Rotate"] = model.ObjInt(0)
+		}
+		if _, has := root["CropBox"]; has {
+			page["CropBox"] = page["MediaBox"]
+		}
+		u.added.objects[ref.ObjectNumber] = page
+
+		newRef := u.importRef(ref)
+		u.changed[newRef.ObjectNumber].(model.ObjDict)["Parent"] = rootRef
+		kids = append(kids, newRef)
+		count++
+	}
+	root["Kids"] = kids
+	root["Count"] = count
+	return nil
+}
+
+// mergeCatalog copies the catalog entries required by the document options
+func (u *incrementalUpdate) mergeCatalog() {
+	addedCatalog := u.added.catalog()
+	for _, key := range [...]model.Name{"OCProperties", "OutputIntents"} {
+		value, ok := addedCatalog[key]
+		if !ok {
+			continue
+		}
+		catalog, err := u.modify(u.original.root)
+		if err != nil { // should not happen, since the catalog has already been read
+			return
+		}
+		if _, has := catalog[key]; has {
+			continue // do not override the existing settings
+		}
+		catalog[key] = u.importObject(value)
+	}
+}
+
+// mergeInfo updates the document information dictionary,
+// setting the modification date
+func (u *incrementalUpdate) mergeInfo() {
+	addedInfo, _ := u.added.objects[u.added.info].(model.ObjDict)
+	var info model.ObjDict
+	if u.original.info != 0 {
+		var err error
+		if info, err = u.modify(u.original.info); err != nil {
+			return
+		}
+	} else {
+		info = model.ObjDict{}
+		u.original.info = u.next
+		u.next++
+		u.changed[u.original.info] = info
+	}
+	for k, v := range addedInfo {
+		if k == "CreationDate" { // the original document is older
+			continue
+		}
+		info[k] = u.importObject(v)
+	}
+	if _, has := addedInfo["ModDate"]; !has {
+		// the appended pages are created now
+		if created, has := addedInfo["CreationDate"]; has {
+			info["ModDate"] = created
+		} else {
+			info["ModDate"] = model.ObjStringLiteral(model.DateTimeString(time.Now()))
+		}
+	}
+}
+
+// write appends the update to [original], using the same kind of
+// cross-reference section as [last]
+func (u *incrementalUpdate) write(original []byte, last lastXref, w io.Writer) error {
+	numbers := make([]int, 0, len(u.changed))
+	for n := range u.changed {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	out := &rawWriter{next: u.next}
+	out.buf.Write(original)
+	if !bytes.HasSuffix(original, []byte("\n")) {
+		out.buf.WriteByte('\n')
+	}
+	for _, n := range numbers {
+		out.writeObject(n, u.changed[n])
+	}
+	for len(out.pending) != 0 { // objects created while writing
+		pending := out.pending
+		out.pending = nil
+		for _, p := range pending {
+			out.writeIndirect(p.number, p.header, p.content, p.isStream)
+		}
+	}
+
+	var id model.ObjArray
+	if last.id[0] != "" {
+		// the first identifier is permanent, the second one changes with the update
+		updated := md5.Sum(out.buf.Bytes())
+		id = model.ObjArray{model.ObjHexLiteral(last.id[0]), model.ObjHexLiteral(updated[:])}
+	}
+	if last.isStream {
+		u.writeXrefStream(out, last.offset, id)
+	} else {
+		u.writeXrefTable(out, last.offset, id)
+	}
+
+	_, err := w.Write(out.buf.Bytes())
+	return err
+}
+
+// subsections returns the contiguous ranges (start and length)
+// of the written object numbers
+func subsections(written []int) [][2]int {
+	var out [][2]int
+	for start := 0; start < len(written); {
+		end := start + 1
+		for end < len(written) && written[end] == written[end-1]+1 {
+			end++
+		}
+		out = append(out, [2]int{written[start], end - start})
+		start = end
+	}
+	return out
+}
+
+// writtenNumbers returns the sorted numbers of the objects written in [out]
+func writtenNumbers(out *rawWriter) []int {
+	written := make([]int, 0, len(out.offsets))
+	for n := range out.offsets {
+		written = append(written, n)
+	}
+	sort.Ints(written)
+	return written
+}
+
+// writeXrefTable ends the update with a cross-reference table,
+// listing only the modified objects
+func (u *incrementalUpdate) writeXrefTable(out *rawWriter, prev int, id model.ObjArray) {
+	written := writtenNumbers(out)
+	xref := out.buf.Len()
+	out.buf.WriteString("xref\n")
+	for _, sub := range subsections(written) {
+		fmt.Fprintf(&out.buf, "%d %d\n", sub[0], sub[1])
+		for n := sub[0]; n < sub[0]+sub[1]; n++ {
+			fmt.Fprintf(&out.buf, "%010d 00000 n \n", out.offsets[n])
+		}
+	}
+
+	fmt.Fprintf(&out.buf, "trailer\n<<\n/Size %d\n/Root %d 0 R\n/Prev %d\n", out.next, u.original.root, prev)
+	if u.original.info != 0 {
+		fmt.Fprintf(&out.buf, "/Info %d 0 R\n", u.original.info)
+	}
+	if id != nil {
+		fmt.Fprintf(&out.buf, "/ID %s\n", out.format(id, 0))
+	}
+	fmt.Fprintf(&out.buf, ">>\nstartxref\n%d\n%%%%EOF", xref)
+}
+
+// writeXrefStream ends the update with a cross-reference stream,
+// listing only the modified objects and itself
+func (u *incrementalUpdate) writeXrefStream(out *rawWriter, prev int, id model.ObjArray) {
+	xrefNumber := out.next
+	out.next++
+	xref := out.buf.Len()
+	out.offsets[xrefNumber] = xref
+	written := writtenNumbers(out)
+	delete(out.offsets, xrefNumber) // written below
+
+	var (
+		index   model.ObjArray
+		entries []byte
+	)
+	for _, sub := range subsections(written) {
+		index = append(index, model.ObjInt(sub[0]), model.ObjInt(sub[1]))
+		for n := sub[0]; n < sub[0]+sub[1]; n++ {
+			offset := xref
+			if n != xrefNumber {
+				offset = out.offsets[n]
+			}
+			entries = append(entries, 1)
+			entries = appendUint32(entries, uint32(offset))
+			entries = appendUint16(entries, 0)
+		}
+	}
+	args := model.ObjDict{
+		"Type":   model.ObjName("XRef"),
+		"Size":   model.ObjInt(out.next),
+		"Index":  index,
+		"W":      model.ObjArray{model.ObjInt(1), model.ObjInt(4), model.ObjInt(2)},
+		"Root":   model.ObjIndirectRef{ObjectNumber: u.original.root},
+		"Prev":   model.ObjInt(prev),
+		"Filter": model.ObjName("FlateDecode"),
+	}
+	if u.original.info != 0 {
+		args["Info"] = model.ObjIndirectRef{ObjectNumber: u.original.info}
+	}
+	if id != nil {
+		args["ID"] = id
+	}
+	out.writeObject(xrefNumber, model.ObjStream{Args: args, Content: model.NewCompressedStream(entries).Content})
+	fmt.Fprintf(&out.buf, "startxref\n%d\n%%%%EOF", xref)
+}
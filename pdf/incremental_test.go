@@ -0,0 +1,133 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader"
+	"github.com/benoitkugler/pdf/reader/file"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+func TestLastXrefOffset(t *testing.T) {
+	if n, err := lastXrefOffset([]byte("...startxref\n120\n%%EOF\n...startxref\r\n4567\r\n%%EOF")); err != nil || n != 4567 {
+		t.Fatalf("unexpected offset %d %v", n, err)
+	}
+	if _, err := lastXrefOffset([]byte("%PDF-1.7")); err == nil {
+		t.Fatal("expected error for missing startxref")
+	}
+}
+
+func TestAppendTo(t *testing.T) {
+	original := NewOutput()
+	original.SetTitle("Contract")
+	original.SetAuthors([]string{"Legal"})
+	for i := 0; i < 2; i++ {
+		page := original.AddPage(0, 0, 200, 300)
+		page.SetMediaBox(0, 0, 200, 300)
+	}
+	var base bytes.Buffer
+	if err := original.Write(&base); err != nil {
+		t.Fatal(err)
+	}
+
+	appendix := NewOutput()
+	appendix.SetTitle("Contract with appendix")
+	appendix.SetDateCreation(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	page := appendix.AddPage(0, 0, 100, 100)
+	page.SetMediaBox(0, 0, 100, 100)
+	page.State().SetColorRgba(parser.RGBA{R: 1, A: 1}, false)
+	page.Rectangle(0, 0, 50, 50)
+	page.Paint(backend.FillNonZero)
+	page.AddExternalLink(0, 0, 50, 50, "https://example.org")
+
+	var out bytes.Buffer
+	if err := appendix.AppendTo(base.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out.Bytes(), base.Bytes()) {
+		t.Fatal("the original bytes should be preserved")
+	}
+
+	prev, err := lastXrefOffset(base.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out.Bytes()[base.Len():], []byte(fmt.Sprintf("/Prev %d\n", prev))) {
+		t.Fatalf("missing previous xref offset in %s", out.Bytes()[base.Len():])
+	}
+	if _, err := file.Read(bytes.NewReader(out.Bytes()), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, _, err := reader.ParsePDFReader(bytes.NewReader(out.Bytes()), reader.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := doc.Catalog.Pages.Flatten()
+	if len(pages) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(pages))
+	}
+	last := pages[2]
+	if *last.MediaBox != (model.Rectangle{Urx: 100, Ury: 100}) || len(last.Annots) != 1 {
+		t.Fatalf("unexpected appended page %v", last)
+	}
+	content, err := last.DecodeAllContents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(content, []byte("1 0 0 rg")) {
+		t.Fatalf("unexpected content %s", content)
+	}
+	info := doc.Trailer.Info
+	if info.Title != "Contract with appendix" || info.Author != "Legal" || !info.ModDate.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected metadata %v", info)
+	}
+}
+
+func TestAppendToXrefStream(t *testing.T) {
+	original := NewOutput()
+	original.Options.ObjectStreams = true
+	for i := 0; i < 2; i++ {
+		page := original.AddPage(0, 0, 200, 300)
+		page.SetMediaBox(0, 0, 200, 300)
+	}
+	var base bytes.Buffer
+	if err := original.Write(&base); err != nil {
+		t.Fatal(err)
+	}
+	last, err := readLastXref(base.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last.isStream || last.id[0] == "" {
+		t.Fatalf("unexpected last section %v", last)
+	}
+
+	appendix := NewOutput() // no creation date
+	page := appendix.AddPage(0, 0, 100, 100)
+	page.SetMediaBox(0, 0, 100, 100)
+	var out bytes.Buffer
+	if err := appendix.AppendTo(base.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	update := out.Bytes()[base.Len():]
+	if !bytes.Contains(update, []byte("/Type /XRef")) || bytes.Contains(update, []byte("\nxref\n")) {
+		t.Fatalf("expected a cross-reference stream in %s", update)
+	}
+
+	doc, _, err := reader.ParsePDFReader(bytes.NewReader(out.Bytes()), reader.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages := doc.Catalog.Pages.Flatten(); len(pages) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(pages))
+	}
+	if doc.Trailer.Info.ModDate.IsZero() {
+		t.Fatal("missing modification date")
+	}
+}
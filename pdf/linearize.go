@@ -0,0 +1,445 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/benoitkugler/pdf/model"
+)
+
+// Linearized files (see Annex F of the PDF specification) start with the objects
+// required to display the first page, followed by the other pages, in order.
+// A hint stream gives the position of each page, so that viewers may
+// request it before the end of the download.
+//
+// The fields of the hint tables use fixed widths: the size of the hint stream
+// only depends on the number of pages and objects, and the file is laid out in one pass.
+
+// linearization stores the object numbers (in the input file) of each part of the file
+type linearization struct {
+	pages      []int   // the page objects, in order
+	docLevel   []int   // the catalog and the objects required when opening the file
+	firstPage  []int   // the objects used by the first page, starting with the page object
+	otherPages [][]int // the objects used by only one page, for each page after the first
+	shared     []int   // the objects used by several pages (after the first)
+	sharedRefs [][]int // for each page after the first, the indices in [shared]
+	others     []int   // the remaining objects (page tree, outlines, ...)
+}
+
+// catalog entries not required to display the first page
+var deferredCatalogKeys = map[model.Name]bool{
+	"Pages": true, "Outlines": true, "Names": true, "Dests": true, "StructTreeRoot": true, "Threads": true,
+}
+
+// pageNumbers returns the page objects, in order, and the
+// nodes of the page tree, including the pages
+func (raw rawPDF) pageNumbers() (pages []int, nodes map[int]bool) {
+	nodes = make(map[int]bool)
+	var walk func(o model.Object)
+	walk = func(o model.Object) {
+		ref, ok := o.(model.ObjIndirectRef)
+		if !ok || nodes[ref.ObjectNumber] { // avoid cycles
+			return
+		}
+		nodes[ref.ObjectNumber] = true
+		dict, _ := raw.objects[ref.ObjectNumber].(model.ObjDict)
+		if kids, isNode := raw.resolve(dict["Kids"]).(model.ObjArray); isNode && dict["Type"] != model.ObjName("Page") {
+			for _, kid := range kids {
+				walk(kid)
+			}
+			return
+		}
+		pages = append(pages, ref.ObjectNumber)
+	}
+	walk(raw.catalog()["Pages"])
+	return pages, nodes
+}
+
+// references appends to [out] the objects referenced by [obj], recursively and
+// in depth first order, ignoring the Parent entries, the objects in [skip] and the ones already [seen].
+func (raw rawPDF) references(obj model.Object, skip, seen map[int]bool, out []int) []int {
+	switch obj := obj.(type) {
+	case model.ObjIndirectRef:
+		n := obj.ObjectNumber
+		target, ok := raw.objects[n]
+		if !ok || skip[n] || seen[n] {
+			return out
+		}
+		seen[n] = true
+		out = append(out, n)
+		return raw.references(target, skip, seen, out)
+	case model.ObjDict:
+		keys := make([]model.Name, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		for _, k := range keys {
+			if k != "Parent" {
+				out = raw.references(obj[k], skip, seen, out)
+			}
+		}
+	case model.ObjArray:
+		for _, v := range obj {
+			out = raw.references(v, skip, seen, out)
+		}
+	case model.ObjStream:
+		out = raw.references(obj.Args, skip, seen, out)
+	}
+	return out
+}
+
+// pageObjects returns the page object [page] and the objects it uses,
+// ignoring the other pages
+func (raw rawPDF) pageObjects(page int, nodes, seen map[int]bool) []int {
+	seen[page] = true
+	return raw.references(raw.objects[page], nodes, seen, []int{page})
+}
+
+func copySet(set map[int]bool) map[int]bool {
+	out := make(map[int]bool, len(set))
+	for k, v := range set {
+		out[k] = v
+	}
+	return out
+}
+
+// linearize splits the objects in the parts of a linearized file
+func (raw rawPDF) linearize() linearization {
+	var out linearization
+	pages, nodes := raw.pageNumbers()
+	out.pages = pages
+
+	assigned := map[int]bool{raw.root: true}
+	out.docLevel = []int{raw.root}
+	catalog := raw.catalog()
+	keys := make([]model.Name, 0, len(catalog))
+	for k := range catalog {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, k := range keys {
+		if !deferredCatalogKeys[k] {
+			out.docLevel = raw.references(catalog[k], nodes, assigned, out.docLevel)
+		}
+	}
+
+	if len(pages) == 0 {
+		return out
+	}
+	out.firstPage = raw.pageObjects(pages[0], nodes, assigned)
+
+	// objects used by several pages are moved to the shared section
+	used := make([][]int, len(pages)-1)
+	usage := make(map[int]int)
+	for i, page := range pages[1:] {
+		used[i] = raw.pageObjects(page, nodes, copySet(assigned))
+		for _, n := range used[i] {
+			usage[n]++
+		}
+	}
+	sharedIndex := make(map[int]int)
+	out.otherPages = make([][]int, len(used))
+	out.sharedRefs = make([][]int, len(used))
+	for i, objects := range used {
+		for _, n := range objects {
+			if usage[n] == 1 || n == pages[i+1] {
+				out.otherPages[i] = append(out.otherPages[i], n)
+				continue
+			}
+			index, has := sharedIndex[n]
+			if !has {
+				index = len(out.shared)
+				sharedIndex[n] = index
+				out.shared = append(out.shared, n)
+			}
+			out.sharedRefs[i] = append(out.sharedRefs[i], index)
+		}
+		for _, n := range objects {
+			assigned[n] = true
+		}
+	}
+
+	for n := range raw.objects {
+		if !assigned[n] {
+			out.others = append(out.others, n)
+		}
+	}
+	sort.Ints(out.others)
+	return out
+}
+
+// renumber returns a copy of [obj], with its references updated
+// (missing objects are replaced by null)
+func renumber(obj model.Object, numbers map[int]int) model.Object {
+	switch obj := obj.(type) {
+	case model.ObjIndirectRef:
+		n, ok := numbers[obj.ObjectNumber]
+		if !ok {
+			return nil
+		}
+		return model.ObjIndirectRef{ObjectNumber: n}
+	case model.ObjDict:
+		out := make(model.ObjDict, len(obj))
+		for k, v := range obj {
+			out[k] = renumber(v, numbers)
+		}
+		return out
+	case model.ObjArray:
+		out := make(model.ObjArray, len(obj))
+		for i, v := range obj {
+			out[i] = renumber(v, numbers)
+		}
+		return out
+	case model.ObjStream:
+		return model.ObjStream{Args: renumber(obj.Args, numbers).(model.ObjDict), Content: obj.Content}
+	default:
+		return obj
+	}
+}
+
+// writeLinearized serializes the objects as a linearized file
+func (raw rawPDF) writeLinearized(w io.Writer, version Version) error {
+	lin := raw.linearize()
+	if len(lin.pages) == 0 {
+		return raw.write(w, version)
+	}
+
+	// the objects of the first page section are numbered after the other ones
+	var mainOrder []int
+	for _, objects := range lin.otherPages {
+		mainOrder = append(mainOrder, objects...)
+	}
+	mainOrder = append(mainOrder, lin.shared...)
+	mainOrder = append(mainOrder, lin.others...)
+	numbers := make(map[int]int, len(raw.objects))
+	for i, n := range mainOrder {
+		numbers[n] = i + 1
+	}
+	mainSize := len(mainOrder) + 1
+	linNumber := mainSize
+	next := linNumber + 1
+	for _, n := range lin.docLevel {
+		numbers[n] = next
+		next++
+	}
+	hintNumber := next
+	next++
+	for _, n := range lin.firstPage {
+		numbers[n] = next
+		next++
+	}
+	size := next
+
+	chunks := make(map[int][]byte, len(numbers)) // by input object number
+	for n, newNumber := range numbers {
+		var out rawWriter
+		out.writeObject(newNumber, renumber(raw.objects[n], numbers))
+		chunks[n] = out.buf.Bytes()
+	}
+
+	header := version.header()
+	linDict := func(length, hintOffset, hintLength, endFirstPage, mainXrefEntries int) []byte {
+		return []byte(fmt.Sprintf("%d 0 obj\n<</Linearized 1 /L %010d /H [%010d %010d] /O %d /E %010d /N %d /T %010d>>\nendobj\n",
+			linNumber, length, hintOffset, hintLength, numbers[lin.pages[0]], endFirstPage, len(lin.pages), mainXrefEntries))
+	}
+	firstXref := func(offsets map[int]int, mainXref int) []byte {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "xref\n%d %d\n", linNumber, size-linNumber)
+		for n := linNumber; n < size; n++ {
+			fmt.Fprintf(&buf, "%010d 00000 n \n", offsets[n])
+		}
+		fmt.Fprintf(&buf, "trailer\n<<\n/Size %d\n/Prev %010d\n/Root %d 0 R\n", size, mainXref, numbers[raw.root])
+		if info, ok := numbers[raw.info]; ok {
+			fmt.Fprintf(&buf, "/Info %d 0 R\n", info)
+		}
+		buf.WriteString(raw.trailerID())
+		buf.WriteString(">>\nstartxref\n0\n%%EOF\n")
+		return buf.Bytes()
+	}
+
+	// the offsets used by the hint tables ignore the hint stream itself
+	linLength := len(linDict(0, 0, 0, 0, 0))
+	firstXrefOffset := len(header) + linLength
+	pos := firstXrefOffset + len(firstXref(nil, 0))
+	offsets := make(map[int]int, size) // by output object number
+	place := func(objects []int) {
+		for _, n := range objects {
+			offsets[numbers[n]] = pos
+			pos += len(chunks[n])
+		}
+	}
+	place(lin.docLevel)
+	hintOffset := pos
+	place(lin.firstPage)
+	endFirstPage := pos
+	pageEnds := make([]int, len(lin.otherPages))
+	for i, objects := range lin.otherPages {
+		place(objects)
+		pageEnds[i] = pos
+	}
+	place(lin.shared)
+	place(lin.others)
+	mainXref := pos
+
+	hints, sharedTable := lin.hintTables(raw, offsets, chunks, numbers, endFirstPage, pageEnds)
+	hintChunk := []byte(fmt.Sprintf("%d 0 obj\n<</Length %d /S %d>>\nstream\n", hintNumber, len(hints), sharedTable))
+	hintChunk = append(hintChunk, hints...)
+	hintChunk = append(hintChunk, "\nendstream\nendobj\n"...)
+	for n, offset := range offsets {
+		if offset >= hintOffset {
+			offsets[n] = offset + len(hintChunk)
+		}
+	}
+	offsets[linNumber] = len(header)
+	offsets[hintNumber] = hintOffset
+	endFirstPage += len(hintChunk)
+	mainXref += len(hintChunk)
+
+	var tail bytes.Buffer
+	xrefStart := fmt.Sprintf("xref\n0 %d", mainSize)
+	fmt.Fprintf(&tail, "%s\n0000000000 65535 f \n", xrefStart)
+	for n := 1; n < mainSize; n++ {
+		fmt.Fprintf(&tail, "%010d 00000 n \n", offsets[n])
+	}
+	fmt.Fprintf(&tail, "trailer\n<<\n/Size %d\n%s>>\nstartxref\n%d\n%%%%EOF", mainSize, raw.trailerID(), firstXrefOffset)
+
+	var out bytes.Buffer
+	out.WriteString(header)
+	out.Write(linDict(mainXref+tail.Len(), hintOffset, len(hintChunk), endFirstPage, mainXref+len(xrefStart)))
+	out.Write(firstXref(offsets, mainXref))
+	write := func(objects []int) {
+		for _, n := range objects {
+			out.Write(chunks[n])
+		}
+	}
+	write(lin.docLevel)
+	out.Write(hintChunk)
+	write(lin.firstPage)
+	write(mainOrder)
+	out.Write(tail.Bytes())
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// hintTables returns the content of the primary hint stream,
+// with the page offset and shared object hint tables, and
+// the position of the shared object table.
+// [offsets] are indexed by output object number, and [chunks] by input object number.
+func (lin linearization) hintTables(raw rawPDF, offsets map[int]int, chunks map[int][]byte,
+	numbers map[int]int, endFirstPage int, pageEnds []int,
+) ([]byte, int) {
+	sections := append([][]int{lin.firstPage}, lin.otherPages...)
+	nbPages := len(sections)
+	nbObjects, lengths := make([]int, nbPages), make([]int, nbPages)
+	contentOffsets, contentLengths := make([]int, nbPages), make([]int, nbPages)
+	for i, objects := range sections {
+		start := offsets[numbers[objects[0]]]
+		end := endFirstPage
+		if i > 0 {
+			end = pageEnds[i-1]
+		}
+		nbObjects[i], lengths[i] = len(objects), end-start
+
+		// the first content stream, if it belongs to the page section
+		page, _ := raw.objects[lin.pages[i]].(model.ObjDict)
+		contents := page["Contents"]
+		if array, ok := contents.(model.ObjArray); ok && len(array) != 0 {
+			contents = array[0]
+		}
+		if ref, ok := contents.(model.ObjIndirectRef); ok {
+			for _, n := range objects {
+				if n == ref.ObjectNumber {
+					contentOffsets[i] = offsets[numbers[n]] - start
+					contentLengths[i] = len(chunks[n])
+				}
+			}
+		}
+	}
+
+	const bits = 32 // width of every field
+	var out []byte
+	// page offset hint table, header
+	minObjects, minLength := minInt(nbObjects), minInt(lengths)
+	minContentOffset, minContentLength := minInt(contentOffsets), minInt(contentLengths)
+	out = appendUint32(out, uint32(minObjects))
+	out = appendUint32(out, uint32(offsets[numbers[lin.pages[0]]]))
+	out = appendUint16(out, bits)
+	out = appendUint32(out, uint32(minLength))
+	out = appendUint16(out, bits)
+	out = appendUint32(out, uint32(minContentOffset))
+	out = appendUint16(out, bits)
+	out = appendUint32(out, uint32(minContentLength))
+	out = appendUint16(out, bits)
+	out = appendUint16(out, bits) // number of shared references
+	out = appendUint16(out, bits) // shared object identifiers
+	out = appendUint16(out, 0)    // fractional positions are not used
+	out = appendUint16(out, 1)
+	// page offset hint table, entries (each item for all the pages)
+	for _, v := range nbObjects {
+		out = appendUint32(out, uint32(v-minObjects))
+	}
+	for _, v := range lengths {
+		out = appendUint32(out, uint32(v-minLength))
+	}
+	// the objects of the first page are not referenced as shared objects
+	out = appendUint32(out, 0)
+	for _, refs := range lin.sharedRefs {
+		out = appendUint32(out, uint32(len(refs)))
+	}
+	for _, refs := range lin.sharedRefs {
+		for _, index := range refs {
+			out = appendUint32(out, uint32(len(lin.firstPage)+index))
+		}
+	}
+	for _, v := range contentOffsets {
+		out = appendUint32(out, uint32(v-minContentOffset))
+	}
+	for _, v := range contentLengths {
+		out = appendUint32(out, uint32(v-minContentLength))
+	}
+
+	// shared object hint table: one group per object, starting with the first page section
+	sharedTable := len(out)
+	groups := append(append([]int(nil), lin.firstPage...), lin.shared...)
+	groupLengths := make([]int, len(groups))
+	for i, n := range groups {
+		groupLengths[i] = len(chunks[n])
+	}
+	minGroupLength := minInt(groupLengths)
+	firstShared, firstSharedOffset := 0, 0
+	if len(lin.shared) != 0 {
+		firstShared = numbers[lin.shared[0]]
+		firstSharedOffset = offsets[firstShared]
+	}
+	out = appendUint32(out, uint32(firstShared))
+	out = appendUint32(out, uint32(firstSharedOffset))
+	out = appendUint32(out, uint32(len(lin.firstPage)))
+	out = appendUint32(out, uint32(len(groups)))
+	out = appendUint16(out, 0) // one object per group
+	out = appendUint32(out, uint32(minGroupLength))
+	out = appendUint16(out, bits)
+	for _, v := range groupLengths {
+		out = appendUint32(out, uint32(v-minGroupLength))
+	}
+	// no MD5 signatures : one zero bit per group, padded to a byte boundary
+	out = append(out, make([]byte, (len(groups)+7)/8)...)
+
+	return out, sharedTable
+}
+
+func minInt(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	out := values[0]
+	for _, v := range values[1:] {
+		if v < out {
+			out = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,112 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader"
+	"github.com/benoitkugler/pdf/reader/file"
+	"github.com/benoitkugler/webrender/backend"
+)
+
+func TestLinearizeParts(t *testing.T) {
+	ref := func(n int) model.ObjIndirectRef { return model.ObjIndirectRef{ObjectNumber: n} }
+	raw := rawPDF{root: 1, info: 9, objects: map[int]model.Object{
+		1:  model.ObjDict{"Type": model.ObjName("Catalog"), "Pages": ref(2), "Outlines": ref(10)},
+		2:  model.ObjDict{"Type": model.ObjName("Pages"), "Kids": model.ObjArray{ref(3), ref(4), ref(5)}},
+		3:  model.ObjDict{"Type": model.ObjName("Page"), "Parent": ref(2), "Contents": ref(6)},
+		4:  model.ObjDict{"Type": model.ObjName("Page"), "Parent": ref(2), "Contents": ref(7), "Resources": ref(8)},
+		5:  model.ObjDict{"Type": model.ObjName("Page"), "Parent": ref(2), "Contents": ref(6), "Resources": ref(8)},
+		6:  model.ObjStream{Args: model.ObjDict{}},
+		7:  model.ObjStream{Args: model.ObjDict{}},
+		8:  model.ObjDict{"Font": ref(11)},
+		9:  model.ObjDict{"Title": model.ObjStringLiteral("Statement")},
+		10: model.ObjDict{"First": ref(12)},
+		11: model.ObjDict{"Type": model.ObjName("Font")},
+		12: model.ObjDict{"Dest": model.ObjArray{ref(4), model.ObjName("Fit")}},
+	}}
+	lin := raw.linearize()
+	if fmt.Sprint(lin.pages, lin.docLevel, lin.firstPage, lin.otherPages, lin.shared, lin.sharedRefs, lin.others) !=
+		"[3 4 5] [1] [3 6] [[4 7] [5]] [8 11] [[0 1] [0 1]] [2 9 10 12]" {
+		t.Fatalf("unexpected parts %+v", lin)
+	}
+}
+
+func TestLinearize(t *testing.T) {
+	output := NewOutput()
+	output.Options.Linearize = true
+	output.Options.Overlays = []Overlay{{Text: "Draft"}} // shared by every page
+	output.SetTitle("Statement")
+	for i := 0; i < 4; i++ {
+		page := output.AddPage(0, 0, 200, 300)
+		page.SetMediaBox(0, 0, 200, 300)
+		page.Rectangle(0, 0, fl(10*i+10), 10)
+		page.Paint(backend.FillNonZero)
+	}
+	var buf bytes.Buffer
+	if err := output.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	content := buf.Bytes()
+
+	params := regexp.MustCompile(`<</Linearized 1 /L (\d+) /H \[(\d+) (\d+)\] /O (\d+) /E (\d+) /N (\d+) /T (\d+)>>`).FindSubmatch(content)
+	if params == nil || bytes.Index(content, params[0]) > 1024 {
+		t.Fatalf("missing linearization parameters in %s", content[:200])
+	}
+	values := make([]int, len(params)-1)
+	for i, v := range params[1:] {
+		values[i], _ = strconv.Atoi(string(v))
+	}
+	length, hintOffset, hintLength, firstPage, endFirstPage, nbPages, mainXref := values[0], values[1], values[2], values[3], values[4], values[5], values[6]
+	if length != len(content) || nbPages != 4 {
+		t.Fatalf("unexpected parameters %s", params[0])
+	}
+	if !bytes.HasPrefix(content[mainXref:], []byte("\n0000000000 65535 f")) {
+		t.Fatalf("invalid main xref offset %d", mainXref)
+	}
+	if !bytes.HasPrefix(content[hintOffset+hintLength:], []byte(strconv.Itoa(firstPage)+" 0 obj\n<<")) {
+		t.Fatal("the first page should follow the hint stream")
+	}
+	if endFirstPage >= mainXref || endFirstPage <= hintOffset+hintLength {
+		t.Fatalf("invalid end of first page %d", endFirstPage)
+	}
+
+	// the page offset hint table locates every page object
+	hints := content[bytes.Index(content[hintOffset:], []byte("stream\n"))+hintOffset+len("stream\n"):]
+	adjust := func(offset int) int { // offsets ignore the hint stream
+		if offset >= hintOffset {
+			return offset + hintLength
+		}
+		return offset
+	}
+	leastLength := int(binary.BigEndian.Uint32(hints[10:]))
+	offset := int(binary.BigEndian.Uint32(hints[4:]))
+	entries := hints[36+4*nbPages:] // after the number of objects
+	doc, _, err := reader.ParsePDFReader(bytes.NewReader(content), reader.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := file.Read(bytes.NewReader(content), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := doc.Catalog.Pages.Flatten()
+	if len(pages) != 4 || doc.Trailer.Info.Title != "Statement" {
+		t.Fatalf("unexpected document %v", doc.Trailer.Info)
+	}
+	for i := 0; i < nbPages; i++ {
+		if !regexp.MustCompile(`^\d+ 0 obj\n<<[^>]*/Type /Page\b`).Match(content[adjust(offset):]) {
+			t.Fatalf("page %d not found at %d: %s", i, adjust(offset), content[adjust(offset):adjust(offset)+40])
+		}
+		offset += leastLength + int(binary.BigEndian.Uint32(entries[4*i:]))
+	}
+	// the catalog is written before the hint stream
+	if index := bytes.Index(content, []byte("\n"+strconv.Itoa(f.Root.ObjectNumber)+" 0 obj\n")); index == -1 || index > hintOffset {
+		t.Fatalf("unexpected catalog position %d", index)
+	}
+}
@@ -0,0 +1,274 @@
+package pdf
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	cs "github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/pdf/fonts"
+	"github.com/benoitkugler/pdf/fonts/standardfonts"
+	"github.com/benoitkugler/pdf/model"
+)
+
+// PrinterMarks are drawn on the pages having a TrimBox smaller
+// than the MediaBox (that is, when the CSS bleed property is used),
+// between the two boxes.
+// The marks use the Registration color (the /All Separation), so that they
+// appear on every plate.
+//
+// Since the style engine already draws simple (RGB) marks for the CSS marks
+// property, it should be left to none when using this option.
+type PrinterMarks struct {
+	// Crop draws crop marks at the corners of the TrimBox
+	Crop bool
+	// Cross draws registration targets at the middle of each side
+	Cross bool
+	// ColorBars draws CMYK and gray patches above the page
+	ColorBars bool
+	// Slug writes the job name, the creation date and the page number
+	// below the page
+	Slug bool
+	// JobName defaults to the document title
+	JobName string
+}
+
+// ParsePrinterMarks parses the value of the CSS marks property,
+// which is none or a combination of crop and cross.
+func ParsePrinterMarks(value string) (PrinterMarks, error) {
+	var out PrinterMarks
+	fields := strings.Fields(strings.ToLower(value))
+	if len(fields) == 1 && fields[0] == "none" {
+		return out, nil
+	}
+	for _, field := range fields {
+		switch field {
+		case "crop":
+			out.Crop = true
+		case "cross":
+			out.Cross = true
+		default:
+			return out, fmt.Errorf("invalid marks value %s", value)
+		}
+	}
+	if len(fields) == 0 {
+		return out, fmt.Errorf("empty marks value")
+	}
+	return out, nil
+}
+
+const (
+	markLineWidth = 0.25 // in points
+	markLength    = 12   // maximum length of the crop marks
+	markMinOffset = 3    // minimum distance between the marks and the TrimBox
+	slugFontSize  = 6
+)
+
+// slugInfo is the content of the slug line
+type slugInfo struct {
+	font          fonts.BuiltFont
+	jobName       string
+	date          time.Time
+	page, nbPages int
+}
+
+func (s slugInfo) String() string {
+	chunks := []string{}
+	if s.jobName != "" {
+		chunks = append(chunks, s.jobName)
+	}
+	if !s.date.IsZero() {
+		chunks = append(chunks, s.date.Format("2006-01-02 15:04"))
+	}
+	chunks = append(chunks, fmt.Sprintf("Page %d/%d", s.page, s.nbPages))
+	return strings.Join(chunks, "    ")
+}
+
+// encode uses the WinAnsi encoding of the slug font
+func (s slugInfo) encode() string { return encodeWinAnsi(s.String()) }
+
+// encodeWinAnsi encodes [s] for the (non embedded) standard fonts,
+// replacing the unsupported characters by '?'
+func encodeWinAnsi(s string) string {
+	codes := winAnsiEncoding()
+	var out []byte
+	for _, r := range s {
+		if r >= ' ' && r <= '~' { // ASCII is preserved by WinAnsi
+			out = append(out, byte(r))
+		} else if code, ok := codes[r]; ok {
+			out = append(out, code)
+		} else {
+			out = append(out, '?')
+		}
+	}
+	return string(out)
+}
+
+// newSlugFont returns Helvetica, which is not embedded
+func newSlugFont() (fonts.BuiltFont, error) {
+	return fonts.BuildFont(&model.FontDict{Subtype: standardfonts.Helvetica.WesternType1Font()})
+}
+
+// drawMarks draws [marks] in the region between the TrimBox and the MediaBox.
+// It must be called once the content of the page is complete.
+func (cp *outputPage) drawMarks(marks *PrinterMarks, slug slugInfo) {
+	trim := cp.page.TrimBox
+	if trim == nil {
+		return
+	}
+	media := cp.app.BoundingBox
+	if cp.customMediaBox != nil {
+		media = *cp.customMediaBox
+	}
+	bleed := *trim
+	if cp.page.BleedBox != nil {
+		bleed = *cp.page.BleedBox
+	}
+	// the distances from the TrimBox to the marks and to the MediaBox,
+	// in the order left, bottom, right, top
+	margins := [4]fl{trim.Llx - media.Llx, trim.Lly - media.Lly, media.Urx - trim.Urx, media.Ury - trim.Ury}
+	bleeds := [4]fl{trim.Llx - bleed.Llx, trim.Lly - bleed.Lly, bleed.Urx - trim.Urx, bleed.Ury - trim.Ury}
+	var offsets, lengths [4]fl
+	for i, m := range margins {
+		offsets[i] = fl(math.Max(float64(bleeds[i]), markMinOffset))
+		lengths[i] = fl(math.Max(0, math.Min(markLength, float64(m-offsets[i]))))
+	}
+	if lengths == ([4]fl{}) {
+		return
+	}
+
+	cp.OnNewStack(func() {
+		// use the default user space
+		mat := cp.GetTransform()
+		if err := mat.Invert(); err != nil {
+			log.Printf("invalid page transform: %s", err)
+			return
+		}
+		cp.Transform(mat)
+
+		cp.app.Ops(cs.OpSetLineWidth{W: markLineWidth}, cs.OpSetDash{}, cs.OpSetLineCap{Style: 0})
+		cp.registrationColor(true)
+		cp.registrationColor(false)
+
+		if marks.Crop {
+			cp.drawCropMarks(*trim, offsets, lengths)
+		}
+		if marks.Cross {
+			cx, cy := (trim.Llx+trim.Urx)/2, (trim.Lly+trim.Ury)/2
+			centers := [4][2]fl{
+				{trim.Llx - offsets[0] - lengths[0]/2, cy},
+				{cx, trim.Lly - offsets[1] - lengths[1]/2},
+				{trim.Urx + offsets[2] + lengths[2]/2, cy},
+				{cx, trim.Ury + offsets[3] + lengths[3]/2},
+			}
+			for i, c := range centers {
+				if lengths[i] >= 4 {
+					cp.drawTarget(c[0], c[1], lengths[i]/2)
+				}
+			}
+		}
+		if marks.ColorBars && lengths[3] >= 4 {
+			cp.drawColorBars(trim.Llx, trim.Ury+offsets[3], lengths[3])
+		}
+		if marks.Slug && margins[1]-offsets[1] >= slugFontSize {
+			cp.app.BeginText()
+			cp.app.SetFontAndSize(slug.font, slugFontSize)
+			// avoid the crop marks and the bottom registration target
+			x := trim.Llx + markLength
+			if marks.Cross {
+				x = (trim.Llx+trim.Urx)/2 + lengths[1]
+			}
+			cp.app.Ops(cs.OpTextMove{X: x, Y: trim.Lly - offsets[1] - slugFontSize})
+			cp.app.Ops(cs.OpShowText{Text: slug.encode()})
+			cp.app.EndText()
+		}
+	})
+}
+
+// registrationColor selects the Registration color
+func (g *group) registrationColor(stroke bool) {
+	if g.grayOutput() != nil {
+		if stroke {
+			g.app.Ops(cs.OpSetStrokeGray{G: 0})
+		} else {
+			g.app.Ops(cs.OpSetFillGray{G: 0})
+		}
+		return
+	}
+	const name = "Registration"
+	if g.colorSpaces == nil {
+		g.colorSpaces = make(model.ResourcesColorSpace)
+	}
+	all := SpotColor{Name: "All", CMYK: [4]fl{1, 1, 1, 1}}
+	g.colorSpaces[name] = all.colorSpace()
+	if stroke {
+		g.app.Ops(cs.OpSetStrokeColorSpace{ColorSpace: name}, cs.OpSetStrokeColorN{Color: []fl{1}})
+	} else {
+		g.app.Ops(cs.OpSetFillColorSpace{ColorSpace: name}, cs.OpSetFillColorN{Color: []fl{1}})
+	}
+}
+
+func (g *group) strokeLine(x0, y0, x1, y1 fl) {
+	g.app.Ops(cs.OpMoveTo{X: x0, Y: y0}, cs.OpLineTo{X: x1, Y: y1}, cs.OpStroke{})
+}
+
+// drawCropMarks draws two lines at each corner of [trim], aligned with its edges
+func (g *group) drawCropMarks(trim model.Rectangle, offsets, lengths [4]fl) {
+	for _, x := range [2]fl{trim.Llx, trim.Urx} {
+		if lengths[1] > 0 {
+			g.strokeLine(x, trim.Lly-offsets[1], x, trim.Lly-offsets[1]-lengths[1])
+		}
+		if lengths[3] > 0 {
+			g.strokeLine(x, trim.Ury+offsets[3], x, trim.Ury+offsets[3]+lengths[3])
+		}
+	}
+	for _, y := range [2]fl{trim.Lly, trim.Ury} {
+		if lengths[0] > 0 {
+			g.strokeLine(trim.Llx-offsets[0], y, trim.Llx-offsets[0]-lengths[0], y)
+		}
+		if lengths[2] > 0 {
+			g.strokeLine(trim.Urx+offsets[2], y, trim.Urx+offsets[2]+lengths[2], y)
+		}
+	}
+}
+
+// drawTarget draws a registration target: a circle with a cross
+func (g *group) drawTarget(cx, cy, radius fl) {
+	g.strokeLine(cx-radius, cy, cx+radius, cy)
+	g.strokeLine(cx, cy-radius, cx, cy+radius)
+	r := radius * 0.6
+	// approximation of a circle with 4 cubic Bézier curves
+	k := r * 0.5523
+	g.app.Ops(
+		cs.OpMoveTo{X: cx + r, Y: cy},
+		cs.OpCubicTo{X1: cx + r, Y1: cy + k, X2: cx + k, Y2: cy + r, X3: cx, Y3: cy + r},
+		cs.OpCubicTo{X1: cx - k, Y1: cy + r, X2: cx - r, Y2: cy + k, X3: cx - r, Y3: cy},
+		cs.OpCubicTo{X1: cx - r, Y1: cy - k, X2: cx - k, Y2: cy - r, X3: cx, Y3: cy - r},
+		cs.OpCubicTo{X1: cx + k, Y1: cy - r, X2: cx + r, Y2: cy - k, X3: cx + r, Y3: cy},
+		cs.OpStroke{},
+	)
+}
+
+// colorBarPatches are the CMYK patches of the color bars
+var colorBarPatches = [...][4]fl{
+	{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1},
+	{1, 1, 0, 0}, {1, 0, 1, 0}, {0, 1, 1, 0},
+	{0, 0, 0, 0.75}, {0, 0, 0, 0.5}, {0, 0, 0, 0.25},
+}
+
+// drawColorBars draws a row of square patches, starting at (x, y)
+func (g *group) drawColorBars(x, y, size fl) {
+	gr := g.grayOutput()
+	for i, c := range colorBarPatches {
+		if gr != nil {
+			// use the gray level of the printed patch
+			g.app.Ops(cs.OpSetFillGray{G: (1 - c[0]) * (1 - c[1]) * (1 - c[2]) * (1 - c[3])})
+		} else {
+			g.app.Ops(cs.OpSetFillCMYKColor{C: c[0], M: c[1], Y: c[2], K: c[3]})
+		}
+		g.app.Ops(cs.OpRectangle{X: x + fl(i)*size, Y: y, W: size, H: size}, cs.OpFill{})
+	}
+	g.registrationColor(false)
+}
@@ -0,0 +1,70 @@
+package pdf
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/matrix"
+)
+
+func TestParsePrinterMarks(t *testing.T) {
+	if m, err := ParsePrinterMarks("cross crop"); err != nil || m != (PrinterMarks{Crop: true, Cross: true}) {
+		t.Fatalf("unexpected marks %v %v", m, err)
+	}
+	if m, err := ParsePrinterMarks("none"); err != nil || m != (PrinterMarks{}) {
+		t.Fatalf("unexpected marks %v %v", m, err)
+	}
+	for _, value := range []string{"", "crop bleed"} {
+		if _, err := ParsePrinterMarks(value); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+}
+
+func TestPrinterMarks(t *testing.T) {
+	output := NewOutput()
+	output.Options.PrinterMarks = &PrinterMarks{Crop: true, Cross: true, ColorBars: true, Slug: true, JobName: "Flyer"}
+	output.SetDateCreation(time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC))
+	// same setup as the style engine, with a 30pt bleed
+	page := output.AddPage(-30, -30, 260, 360).(*outputPage)
+	page.State().Transform(matrix.New(1, 0, 0, -1, 0, 300))
+	page.SetMediaBox(-30, -30, 230, 330)
+	page.SetTrimBox(0, 0, 200, 300)
+	page.SetBleedBox(-10, -10, 210, 310)
+	// a page without bleed has no marks
+	other := output.AddPage(0, 0, 200, 300).(*outputPage)
+	other.SetMediaBox(0, 0, 200, 300)
+	other.SetTrimBox(0, 0, 200, 300)
+
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageObj := doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	sep, ok := pageObj.Resources.ColorSpace["Registration"].(model.ColorSpaceSeparation)
+	if !ok || sep.Name != "All" {
+		t.Fatalf("expected the Registration color space, got %v", pageObj.Resources.ColorSpace)
+	}
+	content, err := pageObj.Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []string{
+		"1 0 0 -1 0 300 cm",   // back to the default user space
+		"0 -10 m\n0 -22 l\nS", // crop mark below the bottom left corner
+		"/Registration CS",
+		"1 0 0 0 k",
+		"(Flyer    2024-03-01 10:30    Page 1/2)Tj",
+	} {
+		if !strings.Contains(string(content), op) {
+			t.Fatalf("missing %q in %s", op, content)
+		}
+	}
+
+	otherObj := doc.Catalog.Pages.Kids[1].(*model.PageObject)
+	if _, ok := otherObj.Resources.ColorSpace["Registration"]; ok {
+		t.Fatal("unexpected marks")
+	}
+}
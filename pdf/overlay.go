@@ -0,0 +1,284 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	cs "github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/pdf/fonts"
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+	"github.com/benoitkugler/webrender/matrix"
+)
+
+// Overlay is content added above (or below) every page of the document,
+// such as a "DRAFT" watermark, a confidentiality notice or an approval stamp.
+//
+// The overlay covers the TrimBox of the pages (or the MediaBox if there is no bleed).
+// Its content is written in a form XObject, shared by all the pages with the same size,
+// unless [Text] uses the page placeholders.
+type Overlay struct {
+	// Text is written with Helvetica (not embedded), and may contain
+	// the {page} and {pages} placeholders, replaced by the page number
+	// and the number of pages.
+	Text string
+	// FontSize defaults to 12
+	FontSize fl
+	// Color is the color of the text, with its opacity (black if zero).
+	Color parser.RGBA
+	// Angle is the counter-clockwise rotation of the text, in degrees.
+	Angle fl
+	// X and Y are the position of the center of the text, from the top left
+	// corner of the page (y grows downward). If both are zero, the text is centered on the page.
+	X, Y fl
+
+	// Draw, if not nil, is called to draw custom content (images, SVG, rendered HTML),
+	// before the text. The canvas has the size of the page, with the origin
+	// at the top left corner and y growing downward, as the pages
+	// drawn by the style engine.
+	Draw func(dst backend.Canvas, width, height fl)
+
+	// Below draws the overlay under the page content instead of above.
+	Below bool
+
+	// Layer, if not empty, is the name of the optional content group
+	// containing the overlay, which may be hidden by PDF viewers.
+	// Overlays with the same layer share the same group.
+	// Layers require to use [Output.Write], and are ignored for PDF 1.4.
+	Layer string
+}
+
+// perPage returns true if the content depends on the page number
+func (ov *Overlay) perPage() bool {
+	return strings.Contains(ov.Text, "{page}") || strings.Contains(ov.Text, "{pages}")
+}
+
+func (ov *Overlay) text(page, nbPages int) string {
+	out := strings.ReplaceAll(ov.Text, "{pages}", strconv.Itoa(nbPages))
+	return strings.ReplaceAll(out, "{page}", strconv.Itoa(page))
+}
+
+func (ov *Overlay) fontSize() fl {
+	if ov.FontSize == 0 {
+		return 12
+	}
+	return ov.FontSize
+}
+
+// overlayKey identifies the shared overlay contents
+type overlayKey struct {
+	index int
+	box   model.Rectangle
+}
+
+// overlays adds the [Options.Overlays] to the finalized pages
+type overlays struct {
+	output *Output
+	font   fonts.BuiltFont
+	shared map[overlayKey]*model.XObjectForm
+}
+
+func (c *Output) newOverlays() (*overlays, error) {
+	out := &overlays{output: c, shared: make(map[overlayKey]*model.XObjectForm)}
+	for _, ov := range c.Options.Overlays {
+		if ov.Text != "" {
+			var err error
+			if out.font, err = newSlugFont(); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return out, nil
+}
+
+// form draws the overlay [index] for a page with the given [box]
+func (ovs *overlays) form(index int, box model.Rectangle, page, nbPages int) *model.XObjectForm {
+	ov := ovs.output.Options.Overlays[index]
+	key := overlayKey{index: index, box: box}
+	if form := ovs.shared[key]; form != nil && !ov.perPage() {
+		return form
+	}
+
+	width, height := box.Width(), box.Height()
+	gr := newGroup(ovs.output.cache, box.Llx, box.Lly, box.Urx, box.Ury)
+	if ov.Draw != nil {
+		gr.OnNewStack(func() {
+			// use the coordinates of the style engine
+			gr.Transform(matrix.New(1, 0, 0, -1, box.Llx, box.Ury))
+			ov.Draw(&gr, width, height)
+		})
+	}
+	if ov.Text != "" {
+		gr.OnNewStack(func() { gr.drawOverlayText(ov, ovs.font, box, page, nbPages) })
+	}
+	form := gr.formObject()
+	form.BBox = box
+
+	if !ov.perPage() {
+		ovs.shared[key] = form
+	}
+	return form
+}
+
+// drawOverlayText writes the text of [ov], in the default user space
+func (g *group) drawOverlayText(ov Overlay, font fonts.BuiltFont, box model.Rectangle, page, nbPages int) {
+	text := ov.text(page, nbPages)
+	size := ov.fontSize()
+	var textWidth fl
+	for _, r := range text {
+		textWidth += font.GetWidth(r, size)
+	}
+	x, y := (box.Llx+box.Urx)/2, (box.Lly+box.Ury)/2
+	if ov.X != 0 || ov.Y != 0 {
+		x, y = box.Llx+ov.X, box.Ury-ov.Y
+	}
+	color := ov.Color
+	if color == (parser.RGBA{}) {
+		color = parser.RGBA{A: 1}
+	}
+	g.SetColorRgba(color, false)
+
+	angle := float64(ov.Angle) * math.Pi / 180
+	cos, sin := fl(math.Cos(angle)), fl(math.Sin(angle))
+	g.app.BeginText()
+	g.app.SetFontAndSize(font, size)
+	g.app.SetTextMatrix(cos, sin, -sin, cos, x, y)
+	// center the text, using the approximate height of the capital letters
+	g.app.Ops(cs.OpTextMove{X: -textWidth / 2, Y: -size * 0.35}, cs.OpShowText{Text: encodeWinAnsi(text)})
+	g.app.EndText()
+}
+
+// apply adds the overlays to [page], which must be finalized
+func (ovs *overlays) apply(page *model.PageObject, pageNumber, nbPages int) {
+	box := visibleBox(page)
+	if box.Width() <= 0 || box.Height() <= 0 {
+		return
+	}
+	if page.Resources == nil {
+		page.Resources = &model.ResourcesDict{}
+	}
+	res := page.Resources
+	if res.XObject == nil {
+		res.XObject = make(map[model.Name]model.XObject)
+	}
+
+	var below, above bytes.Buffer
+	for i, ov := range ovs.output.Options.Overlays {
+		name := model.Name(fmt.Sprintf("Overlay%d", i))
+		res.XObject[name] = ovs.form(i, box, pageNumber, nbPages)
+
+		target := &above
+		if ov.Below {
+			target = &below
+		}
+		if ov.Layer != "" && ovs.output.Options.Version.pdf15() {
+			layer := model.Name(fmt.Sprintf("Layer%d", i))
+			if res.Properties == nil {
+				res.Properties = make(map[model.Name]model.PropertyList)
+			}
+			res.Properties[layer] = layerDict(ov.Layer)
+			fmt.Fprintf(target, "/OC %s BDC %s Do EMC\n", layer, name)
+		} else {
+			fmt.Fprintf(target, "%s Do\n", name)
+		}
+	}
+
+	var contents []model.ContentStream
+	if below.Len() != 0 {
+		contents = append(contents, newContentStream(below.Bytes()))
+	}
+	if above.Len() != 0 {
+		// isolate the page content, whose graphic state is not restored
+		contents = append(contents, newContentStream([]byte("q\n")))
+		contents = append(contents, page.Contents...)
+		contents = append(contents, newContentStream(append([]byte("Q\n"), above.Bytes()...)))
+	} else {
+		contents = append(contents, page.Contents...)
+	}
+	page.Contents = contents
+}
+
+func newContentStream(content []byte) model.ContentStream {
+	return model.ContentStream{Stream: model.NewCompressedStream(content)}
+}
+
+// layerDict returns the optional content group. Since the model
+// writes one object per page, they are merged in [Output.addLayers]
+func layerDict(name string) model.ObjDict {
+	return model.ObjDict{"Type": model.ObjName("OCG"), "Name": model.ObjStringLiteral(name)}
+}
+
+// hasLayers returns true if an overlay uses an optional content group
+func (c *Output) hasLayers() bool {
+	if !c.Options.Version.pdf15() {
+		return false
+	}
+	for _, ov := range c.Options.Overlays {
+		if ov.Layer != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// addLayers replaces the inline optional content groups by
+// indirect objects, and registers them in the catalog
+func (c *Output) addLayers(raw *rawPDF) {
+	if !c.hasLayers() {
+		return
+	}
+	groups := map[string]model.ObjIndirectRef{}
+	var order model.ObjArray
+	numbers := make([]int, 0, len(raw.objects))
+	for n := range raw.objects {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers) // deterministic output
+	for _, n := range numbers {
+		obj := raw.objects[n]
+		var dict model.ObjDict
+		switch obj := obj.(type) {
+		case model.ObjDict:
+			dict = obj
+		case model.ObjStream:
+			dict = obj.Args
+		}
+		resources, _ := raw.resolve(dict["Resources"]).(model.ObjDict)
+		if resources == nil {
+			continue
+		}
+		properties, _ := raw.resolve(resources["Properties"]).(model.ObjDict)
+		for name, prop := range properties {
+			ocg, _ := raw.resolve(prop).(model.ObjDict)
+			if ocg == nil || ocg["Type"] != model.ObjName("OCG") {
+				continue
+			}
+			layer, _ := ocg["Name"].(model.ObjStringLiteral)
+			ref, ok := groups[string(layer)]
+			if !ok {
+				ref = raw.add(ocg)
+				groups[string(layer)] = ref
+				order = append(order, ref)
+			}
+			if old, isRef := prop.(model.ObjIndirectRef); isRef && old != ref {
+				delete(raw.objects, old.ObjectNumber) // merged in [ref]
+			}
+			properties[name] = ref
+		}
+	}
+	if len(order) == 0 {
+		log.Println("missing optional content groups")
+		return
+	}
+	raw.catalog()["OCProperties"] = model.ObjDict{
+		"OCGs": order,
+		"D":    model.ObjDict{"Order": order, "ON": order},
+	}
+}
@@ -0,0 +1,127 @@
+package pdf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader/file"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+func decodeContents(t *testing.T, page *model.PageObject) []string {
+	t.Helper()
+	var out []string
+	for _, ct := range page.Contents {
+		content, err := ct.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, string(content))
+	}
+	return out
+}
+
+func TestOverlays(t *testing.T) {
+	output := NewOutput()
+	var drawn int
+	output.Options.Overlays = []Overlay{
+		{
+			Text: "DRAFT", FontSize: 60, Angle: 45, Color: parser.RGBA{R: 1, A: 0.3},
+			Draw: func(dst backend.Canvas, width, height fl) {
+				drawn++
+				dst.Rectangle(0, 0, width, 10) // a band at the top of the page
+				dst.Paint(backend.FillNonZero)
+			},
+			Below: true,
+		},
+		{Text: "Page {page} of {pages} - Confidential", X: 100, Y: 190},
+	}
+	for i := 0; i < 3; i++ {
+		page := output.AddPage(0, 0, 200, 200)
+		page.SetMediaBox(0, 0, 200, 200)
+		page.Rectangle(0, 0, 50, 50)
+		page.Paint(backend.FillNonZero)
+	}
+
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drawn != 1 {
+		t.Fatalf("shared overlay should be drawn once, got %d", drawn)
+	}
+	first := doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	second := doc.Catalog.Pages.Kids[1].(*model.PageObject)
+	if first.Resources.XObject["Overlay0"] != second.Resources.XObject["Overlay0"] {
+		t.Fatal("the watermark should be shared")
+	}
+	if first.Resources.XObject["Overlay1"] == second.Resources.XObject["Overlay1"] {
+		t.Fatal("the page numbers should not be shared")
+	}
+
+	contents := decodeContents(t, second)
+	if len(contents) != 4 || !strings.HasPrefix(contents[0], "/Overlay0 Do") ||
+		contents[1] != "q\n" || !strings.HasPrefix(contents[3], "Q\n/Overlay1 Do") {
+		t.Fatalf("unexpected contents %q", contents)
+	}
+
+	form := second.Resources.XObject["Overlay1"].(*model.XObjectForm)
+	content, err := form.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "(Page 2 of 3 - Confidential)Tj") {
+		t.Fatalf("unexpected content %s", content)
+	}
+	form = second.Resources.XObject["Overlay0"].(*model.XObjectForm)
+	if content, _ = form.Decode(); !strings.Contains(string(content), "0.70711 0.70711 -0.70711 0.70711 100 100 Tm") ||
+		!strings.Contains(string(content), "1 0 0 -1 0 200 cm") {
+		t.Fatalf("unexpected content %s", content)
+	}
+}
+
+func TestOverlayLayers(t *testing.T) {
+	output := NewOutput()
+	output.Options.Overlays = []Overlay{
+		{Text: "DRAFT", Layer: "Watermark"},
+		{Text: "Approved", Y: 20, X: 20, Layer: "Watermark"},
+	}
+	for i := 0; i < 2; i++ {
+		page := output.AddPage(0, 0, 200, 200)
+		page.SetMediaBox(0, 0, 200, 200)
+	}
+	var out bytes.Buffer
+	if err := output.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	f, err := file.Read(bytes.NewReader(out.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog := f.ResolveObject(f.Root).(model.ObjDict)
+	properties := f.ResolveObject(catalog["OCProperties"]).(model.ObjDict)
+	groups := f.ResolveObject(properties["OCGs"]).(model.ObjArray)
+	if len(groups) != 1 {
+		t.Fatalf("expected one layer, got %v", groups)
+	}
+	ocg := f.ResolveObject(groups[0]).(model.ObjDict)
+	if ocg["Name"] != model.ObjStringLiteral("Watermark") {
+		t.Fatalf("unexpected layer %v", ocg)
+	}
+	for _, o := range f.XrefTable {
+		page, ok := o.(model.ObjDict)
+		if !ok || page["Type"] != model.ObjName("Page") {
+			continue
+		}
+		resources := f.ResolveObject(page["Resources"]).(model.ObjDict)
+		props := f.ResolveObject(resources["Properties"]).(model.ObjDict)
+		for _, name := range []model.Name{"Layer0", "Layer1"} {
+			if props[name] != groups[0] {
+				t.Fatalf("expected a reference to the layer, got %v", props)
+			}
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package pdf
 
 import (
+	"image"
 	"log"
-	"strings"
 
 	cs "github.com/benoitkugler/pdf/contentstream"
 	"github.com/benoitkugler/pdf/model"
@@ -20,11 +20,6 @@ var (
 	_ backend.GraphicState = (*group)(nil)
 )
 
-func (g *group) SetAlphaMask(mask backend.Canvas) {
-	alphaStream := mask.(*group).app
-	g.drawMask(&alphaStream)
-}
-
 func (g *group) SetColorPattern(p backend.Canvas, contentWidth, contentHeight fl, mt matrix.Transform, stroke bool) {
 	mat := model.Matrix{mt.A, mt.B, mt.C, mt.D, mt.E, mt.F}
 	mat = mat.Multiply(g.app.State.Matrix)
@@ -38,7 +33,7 @@ func (g *group) SetColorPattern(p backend.Canvas, contentWidth, contentHeight fl
 		TilingType: 1,
 	}
 
-	contentXObject := p.(*group).app.ToXFormObject(compressStreams)
+	contentXObject := p.(*group).formObject()
 	// wrap the content into a Do command
 	patternApp := cs.NewGraphicStream(model.Rectangle{Llx: 0, Lly: 0, Urx: contentWidth, Ury: contentHeight})
 	patternApp.AddXObject(contentXObject)
@@ -55,17 +50,16 @@ func (g *group) SetColorPattern(p backend.Canvas, contentWidth, contentHeight fl
 	}
 }
 
-func (g *group) SetBlendingMode(mode string) {
-	// PDF blend modes have TitleCase
-	chunks := strings.Split(mode, "-")
-	for i, s := range chunks {
-		chunks[i] = strings.Title(s)
-	}
-	bm := strings.Join(chunks, "")
-	g.app.SetGraphicState(&model.GraphicState{BM: []model.Name{model.ObjName(bm)}})
-}
-
 func (g *group) Clip(evenOdd bool) {
+	// SVG clip paths are terminated by an empty rectangle:
+	// the text they contain is added to the clipping path
+	if g.emptyRectangle {
+		g.clipWithPathText()
+	}
+	g.pathTexts, g.emptyRectangle = nil, false
+	if g.raster != nil {
+		g.recordClip()
+	}
 	if evenOdd {
 		g.app.Ops(cs.OpEOClip{}, cs.OpEndPath{})
 	} else {
@@ -74,26 +68,42 @@ func (g *group) Clip(evenOdd bool) {
 }
 
 func (g *group) SetColorRgba(color parser.RGBA, stroke bool) {
+	if stroke {
+		g.state.stroke = color
+	} else {
+		g.state.fill = color
+	}
+	g.applyColor(color, stroke)
+}
+
+// applyColor writes the color operations, without updating [g.state]
+func (g *group) applyColor(color parser.RGBA, stroke bool) {
 	alpha := color.A
 	color.A = 1 // do not take into account the opacity, it is handled by `setXXXAlpha`
+	g.writeColor(color, stroke)
 	if stroke {
-		g.app.SetColorStroke(color)
 		g.app.SetStrokeAlpha(alpha)
 	} else {
-		g.app.SetColorFill(color)
 		g.app.SetFillAlpha(alpha)
 	}
 }
 
 func (g *group) SetLineWidth(width fl) {
+	g.state.lineWidth = width
 	g.app.Ops(cs.OpSetLineWidth{W: width})
 }
 
 func (g *group) SetDash(dashes []fl, offset fl) {
+	g.state.dashes, g.state.dashOffset = dashes, offset
 	g.app.Ops(cs.OpSetDash{Dash: model.DashPattern{Array: dashes, Phase: offset}})
 }
 
 func (g *group) SetStrokeOptions(opts backend.StrokeOptions) {
+	g.state.strokeOptions = opts
+	g.applyStrokeOptions(opts)
+}
+
+func (g *group) applyStrokeOptions(opts backend.StrokeOptions) {
 	g.app.Ops(
 		cs.OpSetLineCap{Style: uint8(opts.LineCap)},
 		cs.OpSetLineJoin{Style: uint8(opts.LineJoin)},
@@ -120,6 +130,50 @@ type group struct {
 	cache
 
 	app cs.GraphicStream
+
+	state groupState
+
+	// text waiting to be added to the clip region,
+	// see [group.SetTextClip] and [group.Clip]
+	clipTexts []positionedText
+	pathTexts *svgClipText
+	// true if the last path operation is Rectangle(0, 0, 0, 0)
+	emptyRectangle bool
+
+	// simplified content, only recorded for the groups
+	// created by NewFilterGroup, see [group.DrawFiltered]
+	raster *displayList
+
+	// see [TransparencyGroup]
+	isolated, knockout bool
+
+	// the Separation color spaces used by the content,
+	// see [group.addResources]
+	colorSpaces model.ResourcesColorSpace
+	// the overprint graphic states used by the content,
+	// see [group.addResources]
+	overprintStates map[model.ObjName]*model.GraphicState
+}
+
+// groupState tracks the part of the graphic state required
+// to emulate text effects.
+// It is saved and restored by [group.OnNewStack].
+type groupState struct {
+	fill, stroke  parser.RGBA
+	lineWidth     fl
+	dashes        []fl
+	dashOffset    fl
+	strokeOptions backend.StrokeOptions
+
+	textRender  uint8       // the current text rendering mode, from 0 to 3
+	textClip    bool        // see [group.SetTextClip]
+	textStroke  *TextStroke // see [group.SetTextStroke]
+	strokeFirst bool        // see [group.SetTextPaintOrder]
+
+	clips []*[]polyline // see [group.raster]
+
+	// see [Overprinter]
+	overprint, spotOverprint, overprintGS [2]bool
 }
 
 func newGroup(cache cache,
@@ -127,6 +181,10 @@ func newGroup(cache cache,
 	return group{
 		cache: cache,
 		app:   cs.NewGraphicStream(model.Rectangle{Llx: left, Lly: top, Urx: right, Ury: bottom}), // y grows downward
+		state: groupState{
+			fill: parser.RGBA{A: 1}, stroke: parser.RGBA{A: 1}, lineWidth: 1,
+			strokeOptions: backend.StrokeOptions{MiterLimit: 10},
+		},
 	}
 }
 
@@ -157,6 +215,7 @@ func newContextPage(left, top, right, bottom fl,
 func (cp *outputPage) finalize() {
 	// the MediaBox is the unsclaled BBox. TODO: why ?
 	cp.app.ApplyToPageObject(&cp.page, compressStreams)
+	cp.addResources(cp.page.Resources)
 	if cp.customMediaBox != nil {
 		cp.page.MediaBox = cp.customMediaBox
 	}
@@ -234,29 +293,42 @@ func (g *group) GetRectangle() (left fl, top fl, right fl, bottom fl) {
 // and the error is returned
 func (g *group) OnNewStack(task func()) {
 	g.app.SaveState()
+	state := g.state
 	task()
 	_ = g.app.RestoreState() // the calls are balanced
+	g.state = state
 }
 
 // NewGroup creates a new drawing target with the given
 // bounding box.
 func (g *group) NewGroup(x fl, y fl, width fl, height fl) backend.Canvas {
 	out := newGroup(g.cache, x, y, x+width, y+height)
+	if g.raster != nil { // nested in a filtered group
+		out.raster = &displayList{}
+	}
+	out.isolated = true
 	return &out
 }
 
 // DrawGroup add the `gr` content to the current target. It will panic
 // if `gr` was not created with `AddGroup`
 func (g *group) DrawWithOpacity(opacity fl, gr backend.Canvas) {
-	content := gr.(*group).app.ToXFormObject(compressStreams)
+	g.pathTexts = nil // painting operation, see Clip
+	sub := gr.(*group)
+	content := sub.formObject()
 	form := &model.XObjectTransparencyGroup{
 		XObjectForm: *content,
-		CS:          model.ColorSpaceRGB,
-		I:           true,
+		CS:          g.blendingSpace(),
+		I:           sub.isolated,
+		K:           sub.knockout,
 	}
 	g.app.SetFillAlpha(opacity)
 	g.app.SetStrokeAlpha(opacity)
+	g.state.fill.A, g.state.stroke.A = opacity, opacity
 	g.app.AddXObject(form)
+	if g.raster != nil {
+		g.raster.items = append(g.raster.items, displayItem{group: gr.(*group).raster, opacity: opacity, mat: g.GetTransform(), clips: g.state.clips})
+	}
 }
 
 func (g *group) drawMask(app *cs.GraphicStream) {
@@ -268,7 +340,15 @@ func (g *group) drawMask(app *cs.GraphicStream) {
 // at position ``(x, y)`` in user-space coordinates.
 // (X,Y) coordinates are the top left corner of the rectangle.
 func (g *group) Rectangle(x fl, y fl, width fl, height fl) {
+	g.emptyRectangle = x == 0 && y == 0 && width == 0 && height == 0
 	g.app.Ops(cs.OpRectangle{X: x, Y: y, W: width, H: height})
+	if g.raster != nil {
+		g.raster.moveTo(g.recordPoint(x, y))
+		g.raster.lineTo(g.recordPoint(x+width, y))
+		g.raster.lineTo(g.recordPoint(x+width, y+height))
+		g.raster.lineTo(g.recordPoint(x, y+height))
+		g.raster.closePath()
+	}
 }
 
 // A drawing operator that fills the current path
@@ -276,6 +356,10 @@ func (g *group) Rectangle(x fl, y fl, width fl, height fl) {
 // (each sub-path is implicitly closed before being filled).
 // After `fill`, the current path will is cleared
 func (g *group) Paint(op backend.PaintOp) {
+	g.pathTexts, g.emptyRectangle = nil, false
+	if g.raster != nil {
+		g.recordPaint(op)
+	}
 	fill := op&(backend.FillEvenOdd|backend.FillNonZero) != 0
 	stroke := op&backend.Stroke != 0
 	evenOdd := op&backend.FillEvenOdd != 0
@@ -302,6 +386,9 @@ func (g *group) Paint(op backend.PaintOp) {
 // After this call the current point will be ``(x, y)``.
 func (g *group) MoveTo(x fl, y fl) {
 	g.app.Ops(cs.OpMoveTo{X: x, Y: y})
+	if g.raster != nil {
+		g.raster.moveTo(g.recordPoint(x, y))
+	}
 }
 
 // Adds a line to the path from the current point
@@ -310,6 +397,9 @@ func (g *group) MoveTo(x fl, y fl) {
 // A current point must be defined before using this method.
 func (g *group) LineTo(x fl, y fl) {
 	g.app.Ops(cs.OpLineTo{X: x, Y: y})
+	if g.raster != nil {
+		g.raster.lineTo(g.recordPoint(x, y))
+	}
 }
 
 // Add cubic Bézier curve to current path.
@@ -317,21 +407,51 @@ func (g *group) LineTo(x fl, y fl) {
 // y2)`` as the Bézier control points.
 func (g *group) CubicTo(x1, y1, x2, y2, x3, y3 fl) {
 	g.app.Ops(cs.OpCubicTo{X1: x1, Y1: y1, X2: x2, Y2: y2, X3: x3, Y3: y3})
+	if g.raster != nil {
+		g.raster.cubicTo(g.recordPoint(x1, y1), g.recordPoint(x2, y2), g.recordPoint(x3, y3))
+	}
 }
 
 // ClosePath close the current path, which will apply line join style.
-func (g *group) ClosePath() { g.app.Ops(cs.OpClosePath{}) }
+func (g *group) ClosePath() {
+	g.app.Ops(cs.OpClosePath{})
+	if g.raster != nil {
+		g.raster.closePath()
+	}
+}
 
 // DrawRasterImage draws the given image at the current point
 func (g *group) DrawRasterImage(img backend.RasterImage, width fl, height fl) {
+	g.pathTexts = nil // painting operation, see Clip
+	if img.MimeType == pdfMimeType {
+		g.drawPDFPage(img, width, height)
+		return
+	}
+
 	// check the global cache
 	obj, has := g.images[img.ID]
+	var decoded image.Image
+	if g.raster != nil || (!has && (g.cmykOutput() || g.grayOutput() != nil)) {
+		img.Content, decoded = decodeRaster(img)
+	}
+	if g.raster != nil && decoded != nil {
+		bounds := decoded.Bounds()
+		mat := matrix.New(width/fl(bounds.Dx()), 0, 0, height/fl(bounds.Dy()), 0, 0)
+		mat.LeftMultBy(g.GetTransform())
+		g.raster.items = append(g.raster.items, displayItem{img: decoded, mat: mat, clips: g.state.clips})
+	}
 	if !has {
-		var err error
-		obj, _, err = cs.ParseImage(img.Content, img.MimeType)
-		if err != nil {
-			log.Printf("failed to process image: %s", err)
-			return
+		if gr := g.grayOutput(); gr != nil && decoded != nil {
+			obj = gr.imageToGray(decoded)
+		} else if g.cmykOutput() && isRGBImage(decoded) {
+			obj = g.imageToCMYK(decoded)
+		} else {
+			var err error
+			obj, _, err = cs.ParseImage(img.Content, img.MimeType)
+			if err != nil {
+				log.Printf("failed to process image: %s", err)
+				return
+			}
 		}
 		obj.Interpolate = img.Rendering == "auto"
 		g.images[img.ID] = obj
@@ -344,6 +464,7 @@ func (g *group) DrawRasterImage(img backend.RasterImage, width fl, height fl) {
 // Solid gradient are already handled, meaning that only linear and radial
 // must be taken care of.
 func (g *group) DrawGradient(layout backend.GradientLayout, width fl, height fl) {
+	g.pathTexts = nil // painting operation, see Clip
 	grad := cs.GradientComplex{
 		Offsets:    layout.Positions,
 		Colors:     make([][4]fl, len(layout.Colors)),
@@ -353,16 +474,48 @@ func (g *group) DrawGradient(layout backend.GradientLayout, width fl, height fl)
 		grad.Colors[i] = [4]fl{c.R, c.G, c.B, c.A}
 	}
 
-	if layout.Kind == "linear" {
+	spot, tints := g.gradientSpot(layout.Colors)
+	if spot != -1 && layout.Kind != ConicGradient {
+		// store the tints as gray colors, see [group.spotShading]
+		for i, t := range tints {
+			grad.Colors[i] = [4]fl{t, t, t, layout.Colors[i].A}
+		}
+	}
+
+	var gamut model.ColorSpace
+	if spot == -1 && layout.Kind != ConicGradient {
+		gamut = g.wideGamutGradient(&grad, layout.Colors)
+	}
+
+	var sh, alphaSh *model.ShadingDict
+	switch layout.Kind {
+	case "linear":
 		grad.Direction = cs.GradientLinear{layout.Coords[0], layout.Coords[1], layout.Coords[2], layout.Coords[3]}
-	} else {
+		sh, alphaSh = grad.BuildShadings()
+	case ConicGradient:
+		// not supported by axial and radial shadings
+		sh, alphaSh = g.conicShadings(layout, width, height/layout.ScaleY)
+	default:
 		grad.Direction = cs.GradientRadial(layout.Coords)
+		sh, alphaSh = grad.BuildShadings()
 	}
 
-	sh, alphaSh := grad.BuildShadings()
-
+	switch {
+	case spot != -1 && layout.Kind != ConicGradient:
+		g.spotShading(sh, spot)
+	case gamut != nil:
+		sh.ColorSpace = gamut
+	default:
+		g.convertShading(sh)
+	}
 	g.Transform(matrix.New(1, 0, 0, layout.ScaleY, 0, 0))
 
+	if g.raster != nil {
+		g.recordShape([]polyline{{closed: true, points: []point{
+			g.recordPoint(0, 0), g.recordPoint(width, 0), g.recordPoint(width, height/layout.ScaleY), g.recordPoint(0, height/layout.ScaleY),
+		}}}, averageColor(layout.Colors))
+	}
+
 	if alphaSh != nil {
 		alphaStream := cs.NewGraphicStream(model.Rectangle{Llx: 0, Lly: 0, Urx: width, Ury: height})
 
@@ -2,6 +2,7 @@
 package pdf
 
 import (
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -10,6 +11,7 @@ import (
 	"github.com/benoitkugler/pdf/model"
 	"github.com/benoitkugler/webrender/backend"
 	"github.com/benoitkugler/webrender/text"
+	"github.com/go-text/typesetting/opentype/api/font"
 )
 
 var (
@@ -24,6 +26,9 @@ type cache struct {
 	// global shared cache for image content
 	images map[int]*model.XObjectImage
 
+	// global shared cache for the imported PDF pages
+	pdfPages map[int]pdfPageForm
+
 	// global shared cache for fonts
 	fonts map[backend.Font]pdfFont
 
@@ -31,18 +36,126 @@ type cache struct {
 	// The same face may be used at different sizes
 	// and we don't want to duplicate the font file
 	fontFiles map[text.FontOrigin][]byte
+
+	// how each font file is embedded, according to its license
+	fontEmbeddings map[text.FontOrigin]fontEmbedding
+
+	// parsed fonts, used to draw glyphs as paths
+	outlineFaces map[text.FontOrigin]*font.Face
+
+	// shared ICC based color spaces, see [WideGamutColor],
+	// built on first use
+	iccSpaces map[GamutSpace]*model.ColorSpaceICCBased
+
+	// overprint graphic states used by the content, see [Overprinter]
+	overprints map[[2]bool]*model.GraphicState
+
+	// parsed profiles of the CMYK output intents, nil if invalid
+	outputProfiles map[*OutputIntent]*iccLut
+
+	// the replaced colors, see [cache.logSharedColor]
+	sharedColors map[[3]fl]bool
+
+	// points to the options of the [Output]
+	options *Options
 }
 
-func newCache() cache {
+func newCache(options *Options) cache {
 	return cache{
-		images:    make(map[int]*model.XObjectImage),
-		fonts:     make(map[backend.Font]pdfFont),
-		fontFiles: make(map[text.FontOrigin][]byte),
+		images:         make(map[int]*model.XObjectImage),
+		pdfPages:       make(map[int]pdfPageForm),
+		fonts:          make(map[backend.Font]pdfFont),
+		fontFiles:      make(map[text.FontOrigin][]byte),
+		fontEmbeddings: make(map[text.FontOrigin]fontEmbedding),
+		outlineFaces:   make(map[text.FontOrigin]*font.Face),
+		overprints:     make(map[[2]bool]*model.GraphicState),
+		outputProfiles: make(map[*OutputIntent]*iccLut),
+		sharedColors:   make(map[[3]fl]bool),
+		iccSpaces:      make(map[GamutSpace]*model.ColorSpaceICCBased),
+		options:        options,
 	}
 }
 
+// Options customizes the PDF output.
+type Options struct {
+	// FontLicensing defines how fonts with embedding
+	// restrictions are handled.
+	FontLicensing FontLicensing
+
+	// StandardFonts references the fonts metric-compatible with
+	// Helvetica, Times and Courier as standard 14 fonts, with WinAnsi encoding,
+	// instead of embedding them.
+	// Text which can't be encoded still uses the embedded fonts.
+	StandardFonts bool
+
+	// CJKFonts maps font families to a character collection, so that
+	// these fonts are referenced by their collection, instead of being embedded.
+	// Only the characters of the Basic Multilingual Plane are supported.
+	CJKFonts map[string]CJKCollection
+
+	// TextOutlines draws all the glyphs as filled paths, using
+	// the font outlines, so that no font file is embedded.
+	TextOutlines bool
+
+	// OutlinesTextLayer adds invisible text (rendering mode 3) on top
+	// of the glyphs drawn as paths (see [Options.TextOutlines] and [OutlineRestrictedFont]),
+	// so that the text may still be searched and copied.
+	// The fonts used by this layer are referenced without being embedded.
+	OutlinesTextLayer bool
+
+	// OutputIntent, if not nil, is written in the document catalog,
+	// and the colors are converted to its color space.
+	// Use [Output.Write] to include it in the file.
+	OutputIntent *OutputIntent
+
+	// SpotColors are the named inks replacing the matching
+	// colors and gradients, see [ParseSpotColors].
+	SpotColors []SpotColor
+
+	// WideGamutColors are the CSS Color 4 values replacing their sRGB
+	// fallback in colors and gradients, see [ParseWideGamutColors].
+	// Every use of a fallback color is replaced, see [WideGamutColor].
+	// They are ignored when using a CMYK [Options.OutputIntent].
+	WideGamutColors []WideGamutColor
+
+	// GrayOutput, if not nil, converts all the colors and images to DeviceGray,
+	// ignoring the other color options.
+	GrayOutput *GrayOutput
+
+	// PrinterMarks, if not nil, are drawn outside of the TrimBox of the pages.
+	// They may not be used with [Options.Imposition].
+	PrinterMarks *PrinterMarks
+
+	// Imposition, if not nil, places the finished pages on
+	// bigger sheets, replacing the pages of the document.
+	// It may not be used with [Options.PrinterMarks], since the pages
+	// are clipped to their TrimBox.
+	Imposition *Imposition
+
+	// Overlays are drawn on every page, before the imposition.
+	Overlays []Overlay
+
+	// Linearize orders the objects of the file written by [Output.Write]
+	// so that viewers may display the first page before the whole
+	// file is downloaded ("fast web view").
+	// Object streams are not used in linearized files.
+	Linearize bool
+
+	// Version is the PDF version of the file written by [Output.Write].
+	// The features introduced after this version (like layers) are not used.
+	Version Version
+
+	// ObjectStreams packs the objects which are not streams (like annotations,
+	// font descriptors or outline items) in compressed object streams,
+	// with a cross-reference stream. It is ignored for PDF 1.4.
+	ObjectStreams bool
+}
+
 // Output implements backend.Output
 type Output struct {
+	// Options may be modified before rendering the document.
+	Options Options
+
 	// global map for files embedded in the PDF
 	// and used in file annotations
 	embeddedFiles map[string]*model.FileSpec
@@ -53,19 +166,24 @@ type Output struct {
 
 	// temporary content, will be copied in the document (see `finalize`)
 	pages []*outputPage
+
+	stream *pageStream // nil if not streaming
 }
 
 func NewOutput() *Output {
-	out := Output{
+	out := &Output{
 		embeddedFiles: make(map[string]*model.FileSpec),
-		cache:         newCache(),
 	}
-	return &out
+	out.cache = newCache(&out.Options)
+	return out
 }
 
 func (c *Output) AddPage(left, top, right, bottom fl) backend.Page {
 	out := newContextPage(left, top, right, bottom, c.embeddedFiles, c.cache)
 	c.pages = append(c.pages, out)
+	if c.stream != nil { // the previous page is complete
+		c.streamPages(false)
+	}
 	return out
 }
 
@@ -101,6 +219,9 @@ func (s *Output) SetDateModification(d time.Time) {
 	s.document.Trailer.Info.ModDate = d
 }
 
+// PageCount returns the number of pages added so far.
+func (c *Output) PageCount() int { return len(c.pages) }
+
 func (c *Output) CreateAnchors(anchors [][]backend.Anchor) {
 	// pages have been processed, meaning that len(anchors) == len(c.pages)
 
@@ -211,19 +332,60 @@ func (c *Output) SetBookmarks(root []backend.BookmarkNode) {
 	c.document.Catalog.Outlines = bookmarksToOutline(root, c.pages)
 }
 
-// Finalize setup and returns the final document
-func (c *Output) Finalize() model.Document {
+// Finalize setup and returns the final document.
+// An error is returned if a font may not be embedded (see [FontLicensing]),
+// or if both [Options.PrinterMarks] and [Options.Imposition] are set.
+func (c *Output) Finalize() (model.Document, error) {
+	if c.stream != nil {
+		return model.Document{}, errors.New("a streaming output must be closed instead of finalized")
+	}
+	if c.Options.PrinterMarks != nil && c.Options.Imposition != nil {
+		return model.Document{}, errors.New("printer marks are not supported with imposition")
+	}
 	pages := make([]model.PageNode, len(c.pages))
+	var slug slugInfo
+	if marks := c.Options.PrinterMarks; marks != nil {
+		slug = slugInfo{jobName: marks.JobName, date: c.document.Trailer.Info.CreationDate, nbPages: len(c.pages)}
+		if slug.jobName == "" {
+			slug.jobName = c.document.Trailer.Info.Title
+		}
+		if marks.Slug {
+			var err error
+			if slug.font, err = newSlugFont(); err != nil {
+				return model.Document{}, err
+			}
+		}
+	}
+	ovs, err := c.newOverlays()
+	if err != nil {
+		return model.Document{}, err
+	}
 	for i, p := range c.pages {
+		if marks := c.Options.PrinterMarks; marks != nil {
+			slug.page = i + 1
+			p.drawMarks(marks, slug)
+		}
 		p.finalize()
+		if len(c.Options.Overlays) != 0 {
+			ovs.apply(&p.page, i+1, len(c.pages))
+		}
 		pages[i] = &p.page
 	}
+	if imp := c.Options.Imposition; imp != nil {
+		finished := make([]*model.PageObject, len(c.pages))
+		for i, p := range c.pages {
+			finished[i] = &p.page
+		}
+		pages = c.impose(imp, finished)
+	}
 	c.document.Catalog.Pages = model.PageTree{
 		Kids: pages,
 	}
 
 	// fonts
-	c.writeFonts()
+	if err := c.writeFonts(); err != nil {
+		return model.Document{}, err
+	}
 
-	return c.document
+	return c.document, nil
 }
@@ -41,8 +41,11 @@ func TestPaint(t *testing.T) {
 		page.Paint(backend.FillNonZero)
 	})
 
-	doc := c.Finalize()
-	err := doc.Write(io.Discard, nil)
+	doc, err := c.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = doc.Write(io.Discard, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -74,8 +77,11 @@ func TestGradientOp(t *testing.T) {
 	// 	ScaleY: 1,
 	// }, 50, 50)
 
-	doc := c.Finalize()
-	err := doc.WriteFile("/tmp/op.pdf", nil)
+	doc, err := c.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = doc.WriteFile("/tmp/op.pdf", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,390 @@
+package pdf
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // image decoders
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"math"
+
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+	"github.com/benoitkugler/webrender/matrix"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/vector"
+)
+
+// The content of the groups created by [group.NewFilterGroup] (and of their nested groups) is also recorded
+// in a simplified form, so that it may be rasterized (see [group.DrawFiltered]).
+// The rasterization is an approximation : paths are filled with the non-zero
+// rule, gradients are replaced by their average color, and patterns
+// and masks are ignored.
+
+// point is a point in the group coordinates
+type point struct{ x, y fl }
+
+// polyline is a flattened sub-path
+type polyline struct {
+	points []point
+	closed bool
+}
+
+// displayItem is one recorded drawing operation
+type displayItem struct {
+	// filled shape, in the group coordinates
+	shape []polyline
+	color parser.RGBA
+
+	// or raster image
+	img image.Image
+
+	// or nested group
+	group   *displayList
+	opacity fl
+
+	// from the image pixels or the nested group
+	// to the group coordinates
+	mat matrix.Transform
+
+	// active clip paths
+	clips []*[]polyline
+}
+
+// displayList records the operations of a group
+type displayList struct {
+	items []displayItem
+
+	// current path, and the current point
+	path    []polyline
+	current point
+}
+
+// record the path operations, in group coordinates
+
+func (dl *displayList) moveTo(p point) {
+	dl.path = append(dl.path, polyline{points: []point{p}})
+	dl.current = p
+}
+
+func (dl *displayList) lineTo(p point) {
+	if len(dl.path) == 0 {
+		dl.moveTo(dl.current)
+	}
+	last := &dl.path[len(dl.path)-1]
+	last.points = append(last.points, p)
+	dl.current = p
+}
+
+// cubicTo flattens the curve
+func (dl *displayList) cubicTo(p1, p2, p3 point) {
+	const steps = 16
+	p0 := dl.current
+	for i := 1; i <= steps; i++ {
+		t := fl(i) / steps
+		u := 1 - t
+		dl.lineTo(point{
+			u*u*u*p0.x + 3*u*u*t*p1.x + 3*u*t*t*p2.x + t*t*t*p3.x,
+			u*u*u*p0.y + 3*u*u*t*p1.y + 3*u*t*t*p2.y + t*t*t*p3.y,
+		})
+	}
+}
+
+func (dl *displayList) closePath() {
+	if len(dl.path) == 0 {
+		return
+	}
+	last := &dl.path[len(dl.path)-1]
+	last.closed = true
+	dl.current = last.points[0]
+}
+
+// takePath returns and resets the current path
+func (dl *displayList) takePath() []polyline {
+	out := dl.path
+	dl.path = nil
+	return out
+}
+
+// strokeShape returns the shape covered by stroking [path]
+// with the given [width], using a round-like approximation
+// for the joins and caps.
+func strokeShape(path []polyline, width fl) []polyline {
+	hw := width / 2
+	var out []polyline
+	// square centered on [p], with a fixed orientation
+	square := func(p point) polyline {
+		return polyline{closed: true, points: []point{
+			{p.x - hw, p.y - hw}, {p.x - hw, p.y + hw}, {p.x + hw, p.y + hw}, {p.x + hw, p.y - hw},
+		}}
+	}
+	for _, sub := range path {
+		points := sub.points
+		if sub.closed && len(points) > 1 {
+			points = append(points[:len(points):len(points)], points[0])
+		}
+		for i, p := range points {
+			out = append(out, square(p))
+			if i == 0 {
+				continue
+			}
+			q := points[i-1]
+			dx, dy := p.x-q.x, p.y-q.y
+			length := fl(math.Hypot(float64(dx), float64(dy)))
+			if length == 0 {
+				continue
+			}
+			nx, ny := -dy/length*hw, dx/length*hw
+			quad := []point{{q.x + nx, q.y + ny}, {p.x + nx, p.y + ny}, {p.x - nx, p.y - ny}, {q.x - nx, q.y - ny}}
+			if signedArea(quad) > 0 { // use the same orientation as the squares
+				quad[1], quad[3] = quad[3], quad[1]
+			}
+			out = append(out, polyline{points: quad, closed: true})
+		}
+	}
+	return out
+}
+
+func signedArea(points []point) fl {
+	var area fl
+	for i, p := range points {
+		q := points[(i+1)%len(points)]
+		area += p.x*q.y - q.x*p.y
+	}
+	return area
+}
+
+// rasterizer renders a display list in a pixel grid,
+// using [mat] to map the group coordinates to pixels.
+type rasterizer struct {
+	mat    matrix.Transform
+	bounds image.Rectangle
+
+	clipMasks map[*[]polyline]*image.Alpha
+}
+
+func newRasterizer(mat matrix.Transform, width, height int) *rasterizer {
+	return &rasterizer{
+		mat:       mat,
+		bounds:    image.Rect(0, 0, width, height),
+		clipMasks: make(map[*[]polyline]*image.Alpha),
+	}
+}
+
+// coverage returns the alpha mask of [shape]
+func (r *rasterizer) coverage(shape []polyline) *image.Alpha {
+	z := vector.NewRasterizer(r.bounds.Dx(), r.bounds.Dy())
+	for _, sub := range shape {
+		if len(sub.points) < 2 {
+			continue
+		}
+		for i, p := range sub.points {
+			x, y := r.mat.Apply(p.x, p.y)
+			if i == 0 {
+				z.MoveTo(x, y)
+			} else {
+				z.LineTo(x, y)
+			}
+		}
+		z.ClosePath()
+	}
+	out := image.NewAlpha(r.bounds)
+	z.Draw(out, r.bounds, image.Opaque, image.Point{})
+	return out
+}
+
+// mask returns the intersection of [clips], or nil if [clips] is empty
+func (r *rasterizer) mask(clips []*[]polyline) *image.Alpha {
+	var out *image.Alpha
+	for _, clip := range clips {
+		m := r.clipMasks[clip]
+		if m == nil {
+			m = r.coverage(*clip)
+			r.clipMasks[clip] = m
+		}
+		if out == nil {
+			out = image.NewAlpha(r.bounds)
+			copy(out.Pix, m.Pix)
+			continue
+		}
+		for i, a := range m.Pix {
+			out.Pix[i] = uint8(uint16(out.Pix[i]) * uint16(a) / 255)
+		}
+	}
+	return out
+}
+
+// multiplyAlpha returns [mask] scaled by [opacity]
+func (r *rasterizer) multiplyAlpha(mask *image.Alpha, opacity fl) *image.Alpha {
+	if opacity >= 1 {
+		return mask
+	}
+	out := image.NewAlpha(r.bounds)
+	for i := range out.Pix {
+		a := fl(255)
+		if mask != nil {
+			a = fl(mask.Pix[i])
+		}
+		out.Pix[i] = uint8(a * opacity)
+	}
+	return out
+}
+
+func toNRGBA(c parser.RGBA) color.NRGBA {
+	clamp := func(v fl) uint8 { return uint8(math.Max(0, math.Min(255, float64(v)*255+0.5))) }
+	return color.NRGBA{R: clamp(c.R), G: clamp(c.G), B: clamp(c.B), A: clamp(c.A)}
+}
+
+// draw renders [list] on [dst]
+func (r *rasterizer) draw(dst *image.RGBA, list *displayList) {
+	for _, item := range list.items {
+		mask := r.mask(item.clips)
+		switch {
+		case item.group != nil:
+			sub := image.NewRGBA(r.bounds)
+			subR := newRasterizer(matrix.Mul(r.mat, item.mat), r.bounds.Dx(), r.bounds.Dy())
+			subR.draw(sub, item.group)
+			mask = r.multiplyAlpha(mask, item.opacity)
+			if mask == nil {
+				draw.Draw(dst, r.bounds, sub, image.Point{}, draw.Over)
+			} else {
+				draw.DrawMask(dst, r.bounds, sub, image.Point{}, mask, image.Point{}, draw.Over)
+			}
+		case item.img != nil:
+			m := matrix.Mul(r.mat, item.mat)
+			aff := f64.Aff3{float64(m.A), float64(m.C), float64(m.E), float64(m.B), float64(m.D), float64(m.F)}
+			var opts *xdraw.Options
+			if mask != nil {
+				opts = &xdraw.Options{DstMask: mask}
+			}
+			xdraw.BiLinear.Transform(dst, aff, item.img, item.img.Bounds(), xdraw.Over, opts)
+		default:
+			coverage := r.coverage(item.shape)
+			if mask != nil {
+				for i, a := range mask.Pix {
+					coverage.Pix[i] = uint8(uint16(coverage.Pix[i]) * uint16(a) / 255)
+				}
+			}
+			draw.DrawMask(dst, r.bounds, image.NewUniform(toNRGBA(item.color)), image.Point{}, coverage, image.Point{}, draw.Over)
+		}
+	}
+}
+
+// decodeRaster reads the content of [img], returning
+// a copy to be used for the PDF output, and the decoded image (or nil)
+func decodeRaster(img backend.RasterImage) (io.Reader, image.Image) {
+	content, err := io.ReadAll(img.Content)
+	if err != nil {
+		log.Printf("failed to read image: %s", err)
+		return bytes.NewReader(nil), nil
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil { // SVG or unsupported format
+		decoded = nil
+	}
+	return bytes.NewReader(content), decoded
+}
+
+// averageColor returns the mean of the gradient colors
+func averageColor(colors []parser.RGBA) parser.RGBA {
+	var out parser.RGBA
+	for _, c := range colors {
+		out.R += c.R * c.A
+		out.G += c.G * c.A
+		out.B += c.B * c.A
+		out.A += c.A
+	}
+	if out.A == 0 {
+		return parser.RGBA{}
+	}
+	out.R, out.G, out.B = out.R/out.A, out.G/out.A, out.B/out.A
+	out.A /= fl(len(colors))
+	return out
+}
+
+// recording of the group operations
+
+// recordPoint transforms (x, y) to the group coordinates
+func (g *group) recordPoint(x, y fl) point {
+	x, y = g.GetTransform().Apply(x, y)
+	return point{x, y}
+}
+
+// recordShape records a fill of [shape] with the current clips
+func (g *group) recordShape(shape []polyline, color parser.RGBA) {
+	g.raster.items = append(g.raster.items, displayItem{shape: shape, color: color, clips: g.state.clips})
+}
+
+// recordPaint records the painting of the current path
+func (g *group) recordPaint(op backend.PaintOp) {
+	path := g.raster.takePath()
+	if op&(backend.FillEvenOdd|backend.FillNonZero) != 0 {
+		g.recordShape(path, g.state.fill)
+	}
+	if op&backend.Stroke != 0 {
+		g.recordShape(strokeShape(path, g.state.lineWidth*g.scaleFactor()), g.state.stroke)
+	}
+}
+
+// recordClip adds the current path to the clips
+func (g *group) recordClip() {
+	path := g.raster.takePath()
+	clips := g.state.clips
+	g.state.clips = append(clips[:len(clips):len(clips)], &path)
+}
+
+// scaleFactor returns the mean scaling of the current transformation
+func (g *group) scaleFactor() fl {
+	return fl(math.Sqrt(math.Abs(float64(g.GetTransform().Determinant()))))
+}
+
+// pathRecorder records a path in a display list, without
+// writing to the content stream.
+type pathRecorder struct {
+	list *displayList
+	ctm  matrix.Transform
+}
+
+func (pr pathRecorder) point(x, y fl) point {
+	x, y = pr.ctm.Apply(x, y)
+	return point{x, y}
+}
+
+func (pr pathRecorder) MoveTo(x, y fl) { pr.list.moveTo(pr.point(x, y)) }
+
+func (pr pathRecorder) LineTo(x, y fl) { pr.list.lineTo(pr.point(x, y)) }
+
+func (pr pathRecorder) CubicTo(x1, y1, x2, y2, x3, y3 fl) {
+	pr.list.cubicTo(pr.point(x1, y1), pr.point(x2, y2), pr.point(x3, y3))
+}
+
+func (pr pathRecorder) ClosePath() { pr.list.closePath() }
+
+// recordText records the glyph outlines of [run], drawn with the text matrix [mat]
+// and the rendering mode [textRender].
+// When clipping, the outlines are accumulated in the current path (see [group.clipWithText]).
+func (g *group) recordText(run backend.TextRun, mat matrix.Transform, textRender uint8) {
+	if _, err := g.outlineFace(run.Font); err != nil {
+		return // no outlines: the text is ignored
+	}
+	g.addOutlines(pathRecorder{list: g.raster, ctm: g.GetTransform()}, run, mat)
+	if g.state.textClip {
+		return
+	}
+	path := g.raster.takePath()
+	if textRender == 0 || textRender == 2 {
+		g.recordShape(path, g.state.fill)
+	}
+	if textRender == 1 || textRender == 2 {
+		color := g.state.stroke
+		if ts := g.state.textStroke; ts != nil {
+			color = ts.Color
+		}
+		g.recordShape(strokeShape(path, g.textLineWidth()*g.scaleFactor()), color)
+	}
+}
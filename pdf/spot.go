@@ -0,0 +1,447 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	cs "github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+// SpotColor is a named ink (such as a Pantone color), written
+// as a Separation color space, so that it is printed on its own plate.
+// The colors of the document exactly equal to [SpotColor.RGB] are replaced by the
+// spot color, as well as the gradients using it, whose other stops
+// may be lighter tints of it (that is, mixed with white).
+//
+// Since the backend only sees the sRGB values, every use of [SpotColor.RGB] is
+// replaced, which is logged once per color for 8-bit values (like #e4002b).
+// A value which is not an 8-bit color (like rgb(89.4%, 0.1%, 16.9%)) keeps the
+// unrelated elements unchanged.
+type SpotColor struct {
+	// Name is the name of the colorant, such as "PANTONE 185 C"
+	Name string
+
+	// RGB is the color used in the stylesheets
+	RGB parser.RGBA
+
+	// CMYK is the alternate color, used by the devices
+	// which do not have the colorant.
+	CMYK [4]fl
+	// Lab, if not nil, is used as alternate color instead of [CMYK]
+	Lab *[3]fl
+
+	// Overprint enables the overprint mode when the
+	// spot color is used (see [Overprinter]).
+	Overprint bool
+}
+
+// ParseSpotColors parses the spot colors declared with custom properties
+// in [css], using the following syntax :
+//
+//	--spot-<id>: <color> device-cmyk(<c> <m> <y> <k>) ["<name>"] [overprint];
+//	--spot-<id>: <color> lab(<L> <a> <b>) ["<name>"] [overprint];
+//
+// where <color> is the (RGB) color used in the document, and the
+// CMYK components are numbers between 0 and 1 or percentages.
+// The name defaults to <id>.
+// The declarations may be top level or inside rules (such as :root).
+func ParseSpotColors(css string) ([]SpotColor, error) {
+	var declarations []parser.Token
+	for _, token := range parser.ParseStylesheetBytes([]byte(css), true, true) {
+		if rule, ok := token.(parser.QualifiedRule); ok {
+			declarations = append(declarations, parser.ParseDeclarationList(*rule.Content, true, true)...)
+		}
+	}
+	if len(declarations) == 0 {
+		declarations = parser.ParseDeclarationListString(css, true, true)
+	}
+
+	var out []SpotColor
+	for _, token := range declarations {
+		decl, ok := token.(parser.Declaration)
+		if !ok || !strings.HasPrefix(string(decl.Name), "--spot-") {
+			continue
+		}
+		spot, err := parseSpotColor(decl.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid spot color %s: %s", decl.Name, err)
+		}
+		if spot.Name == "" {
+			spot.Name = strings.TrimPrefix(string(decl.Name), "--spot-")
+		}
+		out = append(out, spot)
+	}
+	return out, nil
+}
+
+func parseSpotColor(tokens []parser.Token) (SpotColor, error) {
+	var (
+		out                    SpotColor
+		hasColor, hasAlternate bool
+	)
+	for _, token := range tokens {
+		switch token := token.(type) {
+		case parser.WhitespaceToken, parser.Comment:
+		case parser.StringToken:
+			out.Name = token.Value
+		case parser.IdentToken:
+			if token.Value.Lower() == "overprint" {
+				out.Overprint = true
+				continue
+			}
+			color := parser.ParseColor(token)
+			if color.Type != parser.ColorRGBA {
+				return out, fmt.Errorf("unexpected keyword %s", token.Value)
+			}
+			out.RGB, hasColor = color.RGBA, true
+		case parser.FunctionBlock:
+			switch token.Name.Lower() {
+			case "device-cmyk":
+				values, err := spotComponents(*token.Arguments, 4)
+				if err != nil {
+					return out, err
+				}
+				copy(out.CMYK[:], values)
+				hasAlternate = true
+			case "lab":
+				values, err := spotComponents(*token.Arguments, 3)
+				if err != nil {
+					return out, err
+				}
+				out.Lab = &[3]fl{values[0], values[1], values[2]}
+				hasAlternate = true
+			default:
+				color := parser.ParseColor(token)
+				if color.Type != parser.ColorRGBA {
+					return out, fmt.Errorf("unsupported function %s", token.Name)
+				}
+				out.RGB, hasColor = color.RGBA, true
+			}
+		default:
+			color := parser.ParseColor(token)
+			if color.Type != parser.ColorRGBA {
+				return out, fmt.Errorf("unexpected token %s", parser.SerializeOne(token))
+			}
+			out.RGB, hasColor = color.RGBA, true
+		}
+	}
+	if !hasColor {
+		return out, fmt.Errorf("missing color")
+	}
+	if !hasAlternate {
+		return out, fmt.Errorf("missing device-cmyk() or lab() alternate")
+	}
+	return out, nil
+}
+
+// spotComponents returns the numbers or percentages in [tokens],
+// separated by spaces or commas
+func spotComponents(tokens []parser.Token, n int) ([]fl, error) {
+	var out []fl
+	for _, token := range tokens {
+		switch token := token.(type) {
+		case parser.NumberToken:
+			out = append(out, token.Value)
+		case parser.PercentageToken:
+			out = append(out, token.Value/100)
+		case parser.WhitespaceToken, parser.Comment:
+		case parser.LiteralToken:
+			if token.Value != "," {
+				return nil, fmt.Errorf("unexpected %s", token.Value)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected token %s", parser.SerializeOne(token))
+		}
+	}
+	if len(out) != n {
+		return nil, fmt.Errorf("expected %d components, got %d", n, len(out))
+	}
+	return out, nil
+}
+
+// tint returns the tint of the spot color matching [color],
+// which must be [RGB] mixed with white.
+func (spot *SpotColor) tint(color parser.RGBA) (fl, bool) {
+	const tolerance = 1. / 255
+	channels := [3][2]fl{{color.R, spot.RGB.R}, {color.G, spot.RGB.G}, {color.B, spot.RGB.B}}
+	// use the most saturated channel to compute the tint
+	var tint, saturation fl = 1, 0
+	for _, c := range channels {
+		if s := 1 - c[1]; s > tolerance && s > saturation {
+			tint, saturation = (1-c[0])/s, s
+		}
+	}
+	if tint < -tolerance || tint > 1+tolerance {
+		return 0, false
+	}
+	tint = fl(math.Min(1, math.Max(0, float64(tint))))
+	for _, c := range channels {
+		if math.Abs(float64(1+tint*(c[1]-1)-c[0])) > tolerance {
+			return 0, false
+		}
+	}
+	return tint, true
+}
+
+// colorSpace returns the Separation color space for [spot]
+func (spot *SpotColor) colorSpace() model.ColorSpaceSeparation {
+	out := model.ColorSpaceSeparation{Name: escapeName(spot.Name)}
+	fn := model.FunctionExpInterpolation{N: 1}
+	if spot.Lab != nil {
+		out.AlternateSpace = labColorSpace
+		fn.C0, fn.C1 = []fl{100, 0, 0}, spot.Lab[:]
+	} else {
+		out.AlternateSpace = model.ColorSpaceCMYK
+		fn.C0, fn.C1 = []fl{0, 0, 0, 0}, spot.CMYK[:]
+	}
+	out.TintTransform = model.FunctionDict{FunctionType: fn, Domain: []model.Range{{0, 1}}}
+	return out
+}
+
+// escapeName uses the #xx notation for the characters
+// not allowed in PDF names, which are not escaped by the model
+func escapeName(name string) model.Name {
+	var out strings.Builder
+	for _, b := range []byte(name) {
+		if b < '!' || b > '~' || strings.IndexByte("#()<>[]{}/%", b) != -1 {
+			fmt.Fprintf(&out, "#%02X", b)
+		} else {
+			out.WriteByte(b)
+		}
+	}
+	return model.Name(out.String())
+}
+
+// findSpot returns the index of the spot color equal to [color], or -1
+func (c cache) findSpot(color parser.RGBA) int {
+	for i, spot := range c.options.SpotColors {
+		if sameRGB(spot.RGB, color) {
+			c.logSharedColor(color, "spot color")
+			return i
+		}
+	}
+	return -1
+}
+
+// useSpot registers the color space of the spot color [index]
+// in the resources of [g], returning its name
+func (g *group) useSpot(index int) model.ColorSpaceName {
+	name := model.ColorSpaceName(fmt.Sprintf("Spot%d", index))
+	if g.colorSpaces == nil {
+		g.colorSpaces = make(model.ResourcesColorSpace)
+	}
+	if _, has := g.colorSpaces[name]; !has {
+		g.colorSpaces[name] = g.options.SpotColors[index].colorSpace()
+	}
+	return name
+}
+
+// writeSpotColor writes the color operations if [color] is
+// a spot color, returning false otherwise
+func (g *group) writeSpotColor(color parser.RGBA, stroke bool) bool {
+	index := g.findSpot(color)
+	isSpot := index != -1
+	if stroke {
+		g.state.spotOverprint[1] = isSpot && g.options.SpotColors[index].Overprint
+	} else {
+		g.state.spotOverprint[0] = isSpot && g.options.SpotColors[index].Overprint
+	}
+	g.updateOverprint()
+	if !isSpot {
+		return false
+	}
+	name := g.useSpot(index)
+	if stroke {
+		g.app.Ops(cs.OpSetStrokeColorSpace{ColorSpace: name}, cs.OpSetStrokeColorN{Color: []fl{1}})
+	} else {
+		g.app.Ops(cs.OpSetFillColorSpace{ColorSpace: name}, cs.OpSetFillColorN{Color: []fl{1}})
+	}
+	return true
+}
+
+// addResources adds the resources not supported by [cs.GraphicStream]
+func (g *group) addResources(res *model.ResourcesDict) {
+	if res == nil {
+		return
+	}
+	if len(g.colorSpaces) != 0 && res.ColorSpace == nil {
+		res.ColorSpace = make(model.ResourcesColorSpace)
+	}
+	for name, space := range g.colorSpaces {
+		res.ColorSpace[name] = space
+	}
+	if len(g.overprintStates) != 0 && res.ExtGState == nil {
+		res.ExtGState = make(map[model.ObjName]*model.GraphicState)
+	}
+	for name, state := range g.overprintStates {
+		res.ExtGState[name] = state
+	}
+}
+
+// formObject returns the content of [g] as a form XObject
+func (g *group) formObject() *model.XObjectForm {
+	out := g.app.ToXFormObject(compressStreams)
+	g.addResources(&out.Resources)
+	return out
+}
+
+// gradientSpot returns the spot color used by one of the [colors],
+// the other ones being tints of it, and their tints, or -1
+func (c cache) gradientSpot(colors []parser.RGBA) (int, []fl) {
+	if len(c.options.SpotColors) == 0 || len(colors) == 0 || c.grayOutput() != nil {
+		return -1, nil
+	}
+	index := -1
+	for _, color := range colors {
+		if index = c.findSpot(color); index != -1 {
+			break
+		}
+	}
+	if index == -1 {
+		return -1, nil
+	}
+	tints := make([]fl, len(colors))
+	for i, color := range colors {
+		tint, ok := c.options.SpotColors[index].tint(color)
+		if !ok {
+			return -1, nil
+		}
+		tints[i] = tint
+	}
+	return index, tints
+}
+
+// spotShading converts the shading [sh], built with gray colors storing the tints,
+// to the Separation color space of the spot color [index]
+func (g *group) spotShading(sh *model.ShadingDict, index int) {
+	sh.ColorSpace = g.options.SpotColors[index].colorSpace()
+	switch st := sh.ShadingType.(type) {
+	case model.ShadingAxial:
+		tintFunctions(st.Function)
+	case model.ShadingRadial:
+		tintFunctions(st.Function)
+	}
+}
+
+// tintFunctions only keeps the first output of [fns]
+func tintFunctions(fns []model.FunctionDict) {
+	for i := range fns {
+		switch ft := fns[i].FunctionType.(type) {
+		case model.FunctionExpInterpolation:
+			if len(ft.C0) == 3 && len(ft.C1) == 3 {
+				ft.C0, ft.C1 = ft.C0[:1], ft.C1[:1]
+				fns[i].FunctionType = ft
+			}
+		case model.FunctionStitching:
+			tintFunctions(ft.Functions)
+		}
+		if len(fns[i].Range) == 3 {
+			fns[i].Range = fns[i].Range[:1]
+		}
+	}
+}
+
+// Overprinter is implemented by the canvas returned by [Output.AddPage],
+// and controls the overprint mode, used when printing
+// with several inks.
+// Note that webrender does not call it (CSS has no overprint property):
+// apart from the spot colors with [SpotColor.Overprint], overprint must be
+// enabled by custom drawing code, with a type assertion on [backend.Canvas].
+//
+// The OP, op and OPM entries of the graphic states are not supported by
+// the model : they are only written by [Output.Write], and the graphic states
+// of the document returned by [Output.Finalize] are empty, so that
+// overprint is ignored.
+type Overprinter interface {
+	// SetOverprint enables or disables overprint
+	// for the following fill and stroke operations.
+	// Overprint is also enabled when using a spot color
+	// with [SpotColor.Overprint].
+	SetOverprint(fill, stroke bool)
+}
+
+var _ Overprinter = (*group)(nil)
+
+func (g *group) SetOverprint(fill, stroke bool) {
+	g.state.overprint = [2]bool{fill, stroke}
+	g.updateOverprint()
+}
+
+// updateOverprint selects the overprint graphic state, if needed
+func (g *group) updateOverprint() {
+	current := [2]bool{
+		g.state.overprint[0] || g.state.spotOverprint[0],
+		g.state.overprint[1] || g.state.spotOverprint[1],
+	}
+	if current == g.state.overprintGS {
+		return
+	}
+	g.state.overprintGS = current
+	// the graphic state is completed by addOverprints
+	state := g.overprints[current]
+	if state == nil {
+		state = &model.GraphicState{}
+		g.overprints[current] = state
+	}
+	name := overprintName(current)
+	if g.overprintStates == nil {
+		g.overprintStates = make(map[model.ObjName]*model.GraphicState)
+	}
+	g.overprintStates[name] = state
+	g.app.Ops(cs.OpSetExtGState{Dict: name})
+}
+
+// overprintName returns the name of the graphic state
+// for the given (fill, stroke) overprint
+func overprintName(overprint [2]bool) model.ObjName {
+	name := "Overprint"
+	for _, op := range overprint {
+		if op {
+			name += "1"
+		} else {
+			name += "0"
+		}
+	}
+	return model.ObjName(name)
+}
+
+// addOverprints completes the overprint graphic states registered in the
+// resources of the content streams (see [group.addResources]), since the
+// OP, op and OPM entries are not supported by the model.
+func (c *Output) addOverprints(raw *rawPDF) {
+	if len(c.cache.overprints) == 0 {
+		return
+	}
+	for _, obj := range raw.objects {
+		var dict model.ObjDict
+		switch obj := obj.(type) {
+		case model.ObjDict:
+			dict = obj
+		case model.ObjStream:
+			dict = obj.Args
+		}
+		resources, _ := raw.resolve(dict["Resources"]).(model.ObjDict)
+		states, _ := raw.resolve(resources["ExtGState"]).(model.ObjDict)
+		for op := range c.cache.overprints {
+			name := model.Name(overprintName(op))
+			state, ok := states[name]
+			if !ok {
+				continue
+			}
+			full := model.ObjDict{
+				"Type": model.ObjName("ExtGState"),
+				"op":   model.ObjBool(op[0]),
+				"OP":   model.ObjBool(op[1]),
+				"OPM":  model.ObjInt(1),
+			}
+			if ref, isRef := state.(model.ObjIndirectRef); isRef {
+				raw.objects[ref.ObjectNumber] = full
+			} else {
+				states[name] = full
+			}
+		}
+	}
+}
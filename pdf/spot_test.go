@@ -0,0 +1,195 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader/file"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+func TestParseSpotColors(t *testing.T) {
+	spots, err := ParseSpotColors(`:root {
+		--spot-red: #e4002b device-cmyk(0 1 81% 0.04) "PANTONE 185 C" overprint;
+		--spot-gold: rgb(200, 150, 0) lab(65 10 70);
+		--other: red;
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spots) != 2 {
+		t.Fatalf("expected 2 spot colors, got %v", spots)
+	}
+	if s := spots[0]; s.Name != "PANTONE 185 C" || !s.Overprint || s.CMYK != [4]fl{0, 1, 0.81, 0.04} || s.RGB.R != 0xe4/255. {
+		t.Fatalf("unexpected spot color %v", s)
+	}
+	if s := spots[1]; s.Name != "gold" || s.Overprint || s.Lab == nil || *s.Lab != [3]fl{65, 10, 70} {
+		t.Fatalf("unexpected spot color %v", s)
+	}
+
+	for _, css := range []string{
+		"--spot-a: red;",
+		"--spot-a: device-cmyk(0 1 1 0);",
+		"--spot-a: red device-cmyk(0 1 1);",
+	} {
+		if _, err := ParseSpotColors(css); err == nil {
+			t.Fatalf("expected error for %s", css)
+		}
+	}
+}
+
+func TestSpotTint(t *testing.T) {
+	spot := SpotColor{RGB: parser.RGBA{R: 1, G: 0.2, B: 0, A: 1}}
+	for _, test := range []struct {
+		color parser.RGBA
+		tint  fl
+		ok    bool
+	}{
+		{parser.RGBA{R: 1, G: 0.2, B: 0, A: 1}, 1, true},
+		{parser.RGBA{R: 1, G: 0.6, B: 0.5, A: 1}, 0.5, true},
+		{parser.RGBA{R: 1, G: 1, B: 1, A: 1}, 0, true},
+		{parser.RGBA{R: 1, G: 0, B: 0, A: 1}, 0, false},
+		{parser.RGBA{R: 0.5, G: 0.1, B: 0, A: 1}, 0, false},
+	} {
+		tint, ok := spot.tint(test.color)
+		if ok != test.ok || (ok && tint != test.tint) {
+			t.Fatalf("for %v, expected %g %v, got %g %v", test.color, test.tint, test.ok, tint, ok)
+		}
+	}
+}
+
+func TestSpotColors(t *testing.T) {
+	output := NewOutput()
+	output.Options.SpotColors = []SpotColor{
+		{Name: "PANTONE 185 C", RGB: parser.RGBA{R: 1, A: 1}, CMYK: [4]fl{0, 1, 0.81, 0.04}, Overprint: true},
+	}
+	page := output.AddPage(0, 0, 100, 100)
+	page.State().SetColorRgba(parser.RGBA{R: 1, A: 1}, false)
+	page.Rectangle(0, 0, 10, 10)
+	page.Paint(backend.FillNonZero)
+	page.State().SetColorRgba(parser.RGBA{B: 1, A: 1}, false)
+	page.Rectangle(10, 0, 10, 10)
+	page.Paint(backend.FillNonZero)
+	// lighter tints are only replaced in gradients
+	page.State().SetColorRgba(parser.RGBA{R: 1, G: 0.5, B: 0.5, A: 1}, false)
+	page.Rectangle(20, 0, 10, 10)
+	page.Paint(backend.FillNonZero)
+	page.(Overprinter).SetOverprint(false, true)
+	page.DrawGradient(backend.GradientLayout{
+		ScaleY:       1,
+		GradientKind: backend.GradientKind{Kind: "linear", Coords: [6]fl{0, 0, 100, 0}},
+		Positions:    []fl{0, 1},
+		Colors:       []parser.RGBA{{R: 1, G: 1, B: 1, A: 1}, {R: 1, A: 1}},
+	}, 100, 100)
+
+	var out bytes.Buffer
+	if err := output.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	f, err := file.Read(bytes.NewReader(out.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content []byte
+	for _, o := range f.XrefTable {
+		page, ok := o.(model.ObjDict)
+		if !ok || page["Type"] != model.ObjName("Page") {
+			continue
+		}
+		resources := f.ResolveObject(page["Resources"]).(model.ObjDict)
+		spaces := f.ResolveObject(resources["ColorSpace"]).(model.ObjDict)
+		separation, _ := f.ResolveObject(spaces["Spot0"]).(model.ObjArray)
+		if len(separation) != 4 || separation[0] != model.ObjName("Separation") || separation[1] != model.ObjName("PANTONE#20185#20C") {
+			t.Fatalf("unexpected color space %v", spaces)
+		}
+		states := f.ResolveObject(resources["ExtGState"]).(model.ObjDict)
+		op := f.ResolveObject(states["Overprint10"]).(model.ObjDict)
+		if op["op"] != model.ObjBool(true) || op["OP"] != model.ObjBool(false) || op["OPM"] != model.ObjInt(1) {
+			t.Fatalf("unexpected overprint state %v", op)
+		}
+		if _, ok := states["Overprint01"]; !ok {
+			t.Fatalf("missing overprint state in %v", states)
+		}
+
+		contents := f.ResolveObject(page["Contents"]).(model.ObjArray)
+		stream := f.ResolveObject(contents[0]).(model.ObjStream)
+		r, err := zlib.NewReader(bytes.NewReader(stream.Content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ = io.ReadAll(r)
+	}
+	for _, op := range []string{"/Spot0 cs", "1 scn", "/Overprint10 gs", "/Overprint00 gs", "0 0 1 rg", "1 0.5 0.5 rg", "/Overprint01 gs"} {
+		if !strings.Contains(string(content), op) {
+			t.Fatalf("missing %s in %s", op, content)
+		}
+	}
+
+	var hasSpotShading bool
+	for _, o := range f.XrefTable {
+		if stream, ok := o.(model.ObjStream); ok && stream.Args["ShadingType"] != nil {
+			cs, _ := f.ResolveObject(stream.Args["ColorSpace"]).(model.ObjArray)
+			hasSpotShading = hasSpotShading || (len(cs) != 0 && cs[0] == model.ObjName("Separation"))
+		}
+		if dict, ok := o.(model.ObjDict); ok && dict["ShadingType"] != nil {
+			cs, _ := f.ResolveObject(dict["ColorSpace"]).(model.ObjArray)
+			hasSpotShading = hasSpotShading || (len(cs) != 0 && cs[0] == model.ObjName("Separation"))
+		}
+	}
+	if !hasSpotShading {
+		t.Fatal("expected a Separation shading")
+	}
+}
+
+func TestOverprintResources(t *testing.T) {
+	output := NewOutput()
+	page := output.AddPage(0, 0, 100, 100)
+	plain := page.NewGroup(0, 0, 10, 10)
+	plain.Rectangle(0, 0, 10, 10)
+	plain.Paint(backend.FillNonZero)
+	page.DrawWithOpacity(0.5, plain)
+	page.(Overprinter).SetOverprint(true, false)
+	page.Rectangle(0, 0, 10, 10)
+	page.Paint(backend.FillNonZero)
+
+	// the graphic states are declared even without Output.Write
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := doc.Catalog.Pages.Kids[0].(*model.PageObject).Resources
+	if _, ok := resources.ExtGState["Overprint10"]; !ok {
+		t.Fatalf("missing overprint state in %v", resources.ExtGState)
+	}
+	for _, form := range resources.XObject {
+		if _, ok := form.(*model.XObjectTransparencyGroup).Resources.ExtGState["Overprint10"]; ok {
+			t.Fatal("unexpected overprint state in a group without overprint")
+		}
+	}
+}
+
+func TestSpotUndeclaredColor(t *testing.T) {
+	// the spot color is not an 8-bit value, unlike the colors of the other elements
+	spots, err := ParseSpotColors(`--spot-red: rgb(89.4%, 0.1%, 16.9%) device-cmyk(0 1 0.81 0.04) "PANTONE 185 C";`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := htmlToModelOptions(t, `<style>@page { size: 100px 100px; margin: 0 } div { height: 10px }</style>
+		<div style="background-color: rgb(89.4%, 0.1%, 16.9%)"></div>
+		<div style="background-color: #e4002b"></div>
+		<div style="background-color: #f27f95"></div>`, 1, ".", nil, Options{SpotColors: spots})
+	content, err := doc.Catalog.Pages.Kids[0].(*model.PageObject).Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the undeclared 8-bit value and the lighter tint are unchanged
+	if strings.Count(string(content), "/Spot0 cs") != 1 || !strings.Contains(string(content), "0.89412 0 0.16863 rg") ||
+		!strings.Contains(string(content), "0.94902 0.49804 0.58431 rg") {
+		t.Fatalf("expected one spot color, got %s", content)
+	}
+}
@@ -0,0 +1,181 @@
+package pdf
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	cs "github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader"
+)
+
+// Stamp places a page of the [Output] on a page of an existing PDF file,
+// see [Output.WriteOnto].
+type Stamp struct {
+	// Source is the index of the rendered page (starting at 0)
+	Source int
+	// Page is the index of the page in the existing file (starting at 0)
+	Page int
+	// Region is the area of the existing page covered by the rendered page,
+	// in PDF coordinates (with y growing upward), ignoring the /Rotate entry of the page.
+	// The rendered page is scaled to fit the region, preserving its
+	// aspect ratio, and aligned on its top left corner, as displayed:
+	// on rotated pages, it is rotated so that it is displayed upright.
+	// If empty, the whole page (its CropBox or MediaBox) is used.
+	Region model.Rectangle
+}
+
+// WriteOnto finalizes the document and draws its pages on the pages
+// of the PDF file [base], as specified by [stamps].
+// The other pages of [base] are not modified, and the resulting file is written in [w].
+// Links and bookmarks of the rendered pages are not copied, and
+// [base] is written without encryption.
+func (c *Output) WriteOnto(base io.ReadSeeker, stamps []Stamp, w io.Writer) error {
+	rendered, err := c.Finalize()
+	if err != nil {
+		return err
+	}
+	sources := rendered.Catalog.Pages.Flatten()
+
+	doc, _, err := reader.ParsePDFReader(base, reader.Options{})
+	if err != nil {
+		return fmt.Errorf("invalid PDF file: %s", err)
+	}
+	targets := leafPages(&doc.Catalog.Pages, nil, nil)
+
+	stamped := make(map[*model.PageObject]*cs.GraphicStream)
+	var order []*model.PageObject // deterministic output
+	for _, stamp := range stamps {
+		if stamp.Source < 0 || stamp.Source >= len(sources) {
+			return fmt.Errorf("invalid source page %d (for %d pages)", stamp.Source, len(sources))
+		}
+		if stamp.Page < 0 || stamp.Page >= len(targets) {
+			return fmt.Errorf("invalid target page %d (for %d pages)", stamp.Page, len(targets))
+		}
+		source, target := sources[stamp.Source], targets[stamp.Page]
+
+		box := visibleBox(source)
+		region := stamp.Region
+		if region.Width() <= 0 || region.Height() <= 0 {
+			region = targetBox(target)
+		}
+		if box.Width() <= 0 || box.Height() <= 0 {
+			continue
+		}
+
+		app := stamped[target]
+		if app == nil {
+			stream := cs.NewGraphicStream(targetBox(target))
+			app = &stream
+			stamped[target] = app
+			order = append(order, target)
+		}
+		app.SaveState()
+		app.Transform(stampMatrix(box, region, quarterTurns(target.Rotate)))
+		// keep the page compositing, as if the page were rendered alone
+		app.AddXObject(&model.XObjectTransparencyGroup{
+			XObjectForm: *pageToForm(source),
+			CS:          c.cache.blendingSpace(),
+			I:           true,
+		})
+		app.RestoreState()
+	}
+
+	for _, page := range order {
+		// the stamps are gathered in one form, whose resources
+		// can't clash with the existing ones
+		name := uniqueXObjectName(page.Resources)
+		page.Resources.XObject[name] = stamped[page].ToXFormObject(compressStreams)
+		// isolate the existing content, whose graphic state may not be restored
+		ct := []model.ContentStream{newContentStream([]byte("q\n"))}
+		ct = append(ct, page.Contents...)
+		ct = append(ct, newContentStream(append([]byte("Q\n"), cs.WriteOperations(cs.OpXObject{XObject: name})...)))
+		page.Contents = ct
+	}
+
+	return c.writeDocument(doc, w)
+}
+
+// stampMatrix maps [box] to the top left corner of [region], on a page
+// displayed with [quarterTurns] clockwise rotations
+func stampMatrix(box, region model.Rectangle, quarterTurns int) model.Matrix {
+	// the region, as displayed
+	width, height := region.Width(), region.Height()
+	if quarterTurns%2 == 1 {
+		width, height = height, width
+	}
+	scale := fl(math.Min(float64(width/box.Width()), float64(height/box.Height())))
+	// translation in the displayed region, whose bottom left corner is the origin
+	tu := -scale * box.Llx
+	tv := height - scale*box.Height() - scale*box.Lly
+	switch quarterTurns {
+	case 1: // the displayed top left corner is the bottom left corner
+		return model.Matrix{0, scale, -scale, 0, region.Urx - tv, region.Lly + tu}
+	case 2:
+		return model.Matrix{-scale, 0, 0, -scale, region.Urx - tu, region.Ury - tv}
+	case 3:
+		return model.Matrix{0, -scale, scale, 0, region.Llx + tv, region.Ury - tu}
+	default:
+		return model.Matrix{scale, 0, 0, scale, region.Llx + tu, region.Lly + tv}
+	}
+}
+
+// leafPages returns the pages of [tree], after setting
+// the inherited resources and MediaBox, so that they may be modified
+// independently.
+func leafPages(tree *model.PageTree, resources *model.ResourcesDict, mediaBox *model.Rectangle) []*model.PageObject {
+	if tree.Resources != nil {
+		resources = tree.Resources
+	}
+	if tree.MediaBox != nil {
+		mediaBox = tree.MediaBox
+	}
+	var out []*model.PageObject
+	for _, kid := range tree.Kids {
+		switch kid := kid.(type) {
+		case *model.PageTree:
+			out = append(out, leafPages(kid, resources, mediaBox)...)
+		case *model.PageObject:
+			// do not modify the shared resources
+			var res model.ResourcesDict
+			if kid.Resources != nil {
+				res = *kid.Resources
+			} else if resources != nil {
+				res = *resources
+			}
+			xObjects := make(map[model.Name]model.XObject, len(res.XObject))
+			for name, xo := range res.XObject {
+				xObjects[name] = xo
+			}
+			res.XObject = xObjects
+			kid.Resources = &res
+			if kid.MediaBox == nil {
+				kid.MediaBox = mediaBox
+			}
+			out = append(out, kid)
+		}
+	}
+	return out
+}
+
+// targetBox returns the CropBox or the MediaBox of [page]
+func targetBox(page *model.PageObject) model.Rectangle {
+	if page.CropBox != nil {
+		return *page.CropBox
+	}
+	if page.MediaBox != nil {
+		return *page.MediaBox
+	}
+	return model.Rectangle{Urx: 612, Ury: 792} // US Letter
+}
+
+// uniqueXObjectName returns a name not used in [res]
+func uniqueXObjectName(res *model.ResourcesDict) model.Name {
+	for suffix := ""; ; suffix += "_" {
+		name := model.Name("Stamp" + suffix)
+		if _, used := res.XObject[name]; !used {
+			return name
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package pdf
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader"
+	"github.com/benoitkugler/webrender/backend"
+)
+
+func TestWriteOnto(t *testing.T) {
+	base, err := os.ReadFile(writeTestPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newOutput := func() *Output {
+		output := NewOutput()
+		page := output.AddPage(0, 0, 100, 100)
+		page.SetMediaBox(0, 0, 100, 100)
+		page.Rectangle(0, 0, 50, 50)
+		page.Paint(backend.FillNonZero)
+		return output
+	}
+
+	var out bytes.Buffer
+	// the whole 300x150 page
+	if err := newOutput().WriteOnto(bytes.NewReader(base), []Stamp{{Source: 0, Page: 0}}, &out); err != nil {
+		t.Fatal(err)
+	}
+	doc, _, err := reader.ParsePDFReader(bytes.NewReader(out.Bytes()), reader.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := doc.Catalog.Pages.Flatten()
+	content, err := pages[0].DecodeAllContents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "q\n") || !strings.HasSuffix(string(content), "Q\n/Stamp Do\n") {
+		t.Fatalf("unexpected content %s", content)
+	}
+	stamps, ok := pages[0].Resources.XObject["Stamp"].(*model.XObjectForm)
+	if !ok {
+		t.Fatalf("unexpected stamps %T", pages[0].Resources.XObject["Stamp"])
+	}
+	if content, err = stamps.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "1.5 0 0 1.5 0 0 cm") {
+		t.Fatalf("unexpected stamps content %s", content)
+	}
+	for _, xo := range stamps.Resources.XObject {
+		if group, ok := xo.(*model.XObjectTransparencyGroup); !ok || !group.I || group.CS == nil {
+			t.Fatalf("expected an isolated transparency group, got %T", xo)
+		}
+	}
+	if res := pages[1].Resources; res != nil && len(res.XObject) != 0 {
+		t.Fatal("second page should not be modified")
+	}
+
+	if err := newOutput().WriteOnto(bytes.NewReader(base), []Stamp{{Source: 0, Page: 2}}, &out); err == nil {
+		t.Fatal("expected error for invalid page")
+	}
+}
+
+func TestStampMatrix(t *testing.T) {
+	box := model.Rectangle{Urx: 100, Ury: 50}
+	region := model.Rectangle{Llx: 10, Lly: 20, Urx: 60, Ury: 220} // displayed as 200x50 when rotated
+	for turns, exp := range [4]model.Matrix{
+		{0.5, 0, 0, 0.5, 10, 195},
+		{0, 1, -1, 0, 60, 20},
+		{-0.5, 0, 0, -0.5, 60, 45},
+		{0, -1, 1, 0, 10, 220},
+	} {
+		if got := stampMatrix(box, region, turns); got != exp {
+			t.Fatalf("for %d turns, expected %v, got %v", turns, exp, got)
+		}
+	}
+	// the displayed top left corner of the source is mapped
+	// to the displayed top left corner of the region
+	m := stampMatrix(box, region, 1)
+	if x, y := m[2]*50+m[4], m[3]*50+m[5]; x != region.Llx || y != region.Lly {
+		t.Fatalf("unexpected corner %g %g", x, y)
+	}
+}
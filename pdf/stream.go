@@ -0,0 +1,543 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/benoitkugler/pdf/model"
+)
+
+// In streaming mode, the content of each page (its content streams and resources)
+// is written as soon as the page is complete, that is when the next page is added,
+// and then released.
+// The content streams are written directly. Since the model only serializes
+// whole documents, the resources are written as a one page document, whose objects
+// are parsed back and copied in the output with new numbers.
+// The shared objects (images, imported PDF pages, overlays and the Display P3 color space)
+// are written with the first page using them, and then replaced in place by small
+// placeholders referencing the written object, so that the next pages neither
+// keep their content nor serialize it again.
+//
+// The fonts, which are only known at the end, and the objects which may reference
+// other pages (page dictionaries, annotations, outlines and anchors) are written by [Output.Close].
+
+// NewStreamingOutput returns an output writing the pages in [w]
+// as they are completed, so that the memory used does not
+// grow with the number of pages.
+// [Output.Close] must be called once the document is rendered,
+// instead of [Output.Write] or [Output.Finalize].
+//
+// The options requiring the whole document ([Options.Imposition], [Options.Linearize],
+// [Options.ObjectStreams], layers, the page count in overlays and the slug of printer marks)
+// are not supported, and make [Output.Close] return an error.
+func NewStreamingOutput(w io.Writer) *Output {
+	out := NewOutput()
+	out.stream = &pageStream{
+		w:       w,
+		offsets: make(map[int]int),
+		next:    1,
+		hash:    md5.New(),
+		fonts:   make(map[*model.FontDict]int),
+	}
+	return out
+}
+
+// pageStream stores the state of the streaming mode
+type pageStream struct {
+	w       io.Writer
+	written int         // number of bytes written
+	hash    hash.Hash   // of the bytes written, used as file identifier
+	offsets map[int]int // offsets of the written objects
+	next    int         // next free object number
+	err     error       // first error encountered
+
+	overlays *overlays
+	streamed int // number of pages written
+	// contents and resources of the written pages, referencing the written objects
+	pages []streamedPage
+
+	fonts map[*model.FontDict]int // object numbers reserved for the fonts
+}
+
+type streamedPage struct {
+	contents, resources model.Object
+}
+
+// streamedObject is an object already numbered in the output
+type streamedObject struct{ model.Object }
+
+// streamFontPrefix marks the fonts replaced while writing the pages
+const streamFontPrefix = "StreamedFont"
+
+// streamObjectPrefix starts the content of the placeholders
+// replacing the shared objects already written
+const streamObjectPrefix = "StreamedObject"
+
+// sharedObject is an object used by several pages, written once
+type sharedObject struct {
+	resource interface{} // model.XObject or *model.ColorSpaceICCBased
+	// replace changes the object in place into a placeholder
+	// for the written object [number]
+	replace func(number int)
+}
+
+func streamPlaceholder(number int) model.Stream {
+	return model.Stream{Content: []byte(streamObjectPrefix + strconv.Itoa(number))}
+}
+
+func isStreamPlaceholder(s model.Stream) bool {
+	return bytes.HasPrefix(s.Content, []byte(streamObjectPrefix))
+}
+
+// sharedObjects returns the shared objects not written yet,
+// in a deterministic order
+func (c *Output) sharedObjects() []sharedObject {
+	var out []sharedObject
+	ids := make([]int, 0, len(c.cache.images))
+	for id := range c.cache.images {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if img := c.cache.images[id]; !isStreamPlaceholder(img.Stream) {
+			out = append(out, sharedObject{img, func(n int) { *img = model.XObjectImage{Image: model.Image{Stream: streamPlaceholder(n)}} }})
+		}
+	}
+	ids = ids[:0]
+	for id := range c.cache.pdfPages {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		out = appendSharedForm(out, c.cache.pdfPages[id].form)
+	}
+	keys := make([]overlayKey, 0, len(c.stream.overlays.shared))
+	for key := range c.stream.overlays.shared {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ki, kj := keys[i], keys[j]
+		if ki.index != kj.index {
+			return ki.index < kj.index
+		}
+		return lessBox(ki.box, kj.box)
+	})
+	for _, key := range keys {
+		out = appendSharedForm(out, c.stream.overlays.shared[key])
+	}
+	if cs := c.cache.iccSpaces[DisplayP3]; cs != nil && !isStreamPlaceholder(cs.Stream) {
+		out = append(out, sharedObject{cs, func(n int) { *cs = model.ColorSpaceICCBased{Stream: streamPlaceholder(n), N: 3} }})
+	}
+	return out
+}
+
+func appendSharedForm(out []sharedObject, form *model.XObjectForm) []sharedObject {
+	if isStreamPlaceholder(form.Stream) {
+		return out
+	}
+	return append(out, sharedObject{form, func(n int) {
+		*form = model.XObjectForm{ContentStream: model.ContentStream{Stream: streamPlaceholder(n)}}
+	}})
+}
+
+// lessBox compares the corners of [a] and [b], in lexicographic order
+func lessBox(a, b model.Rectangle) bool {
+	va, vb := [4]fl{a.Llx, a.Lly, a.Urx, a.Ury}, [4]fl{b.Llx, b.Lly, b.Urx, b.Ury}
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] < vb[i]
+		}
+	}
+	return false
+}
+
+// checkStreaming returns an error if the options require the whole document
+func (c *Output) checkStreaming() error {
+	switch {
+	case c.Options.Imposition != nil:
+		return errors.New("imposition is not supported in streaming mode")
+	case c.Options.Linearize:
+		return errors.New("linearization is not supported in streaming mode")
+	case c.Options.ObjectStreams:
+		return errors.New("object streams are not supported in streaming mode")
+	case c.hasLayers():
+		return errors.New("layers are not supported in streaming mode")
+	case c.Options.PrinterMarks != nil && c.Options.PrinterMarks.Slug:
+		return errors.New("the slug of printer marks is not supported in streaming mode")
+	}
+	for _, ov := range c.Options.Overlays {
+		if strings.Contains(ov.Text, "{pages}") {
+			return errors.New("the page count of overlays is not supported in streaming mode")
+		}
+	}
+	return nil
+}
+
+// streamPages writes the completed pages, all but the last one
+// if [all] is false
+func (c *Output) streamPages(all bool) {
+	s := c.stream
+	end := len(c.pages)
+	if !all {
+		end--
+	}
+	for ; s.streamed < end; s.streamed++ {
+		if s.err != nil {
+			return
+		}
+		if s.streamed == 0 {
+			if s.err = c.checkStreaming(); s.err != nil {
+				return
+			}
+			if s.overlays, s.err = c.newOverlays(); s.err != nil {
+				return
+			}
+			s.write([]byte(c.Options.Version.header()))
+		}
+		c.streamPage(s.streamed)
+	}
+}
+
+// streamPage finalizes and writes the page [index], releasing its content
+func (c *Output) streamPage(index int) {
+	s := c.stream
+	p := c.pages[index]
+	if marks := c.Options.PrinterMarks; marks != nil {
+		p.drawMarks(marks, slugInfo{page: index + 1})
+	}
+	p.finalize()
+	if len(c.Options.Overlays) != 0 {
+		s.overlays.apply(&p.page, index+1, 0)
+	}
+
+	// the fonts are completed at the end: replace them by placeholders
+	fonts := make(map[*model.FontDict]bool)
+	if p.page.Resources != nil {
+		collectFonts(p.page.Resources, fonts)
+	}
+	saved := make(map[*model.FontDict]model.FontDict, len(fonts))
+	for font := range fonts {
+		number, has := s.fonts[font]
+		if !has {
+			number = s.next
+			s.next++
+			s.fonts[font] = number
+		}
+		saved[font] = *font
+		*font = model.FontDict{Subtype: model.FontType1{BaseFont: model.Name(streamFontPrefix + strconv.Itoa(number))}}
+	}
+	// the new shared objects are also referenced by a second page,
+	// so that they are found even if this page does not use them
+	shared := c.sharedObjects()
+	var sharedResources model.ResourcesDict
+	for i, obj := range shared {
+		name := model.ObjName("S" + strconv.Itoa(i))
+		switch resource := obj.resource.(type) {
+		case model.XObject:
+			if sharedResources.XObject == nil {
+				sharedResources.XObject = make(map[model.ObjName]model.XObject)
+			}
+			sharedResources.XObject[name] = resource
+		case *model.ColorSpaceICCBased:
+			if sharedResources.ColorSpace == nil {
+				sharedResources.ColorSpace = make(model.ResourcesColorSpace)
+			}
+			sharedResources.ColorSpace[model.ColorSpaceName(name)] = resource
+		}
+	}
+	var doc model.Document
+	doc.Catalog.Pages.Kids = []model.PageNode{
+		&model.PageObject{Resources: p.page.Resources},
+		&model.PageObject{Resources: &sharedResources},
+	}
+	var buf bytes.Buffer
+	err := doc.Write(&buf, nil)
+	for font, dict := range saved {
+		*font = dict
+	}
+	if err != nil {
+		s.err = err
+		return
+	}
+	raw, err := parseRawPDF(buf.Bytes())
+	if err != nil {
+		s.err = fmt.Errorf("invalid PDF output: %s", err)
+		return
+	}
+	c.addOverprints(&raw)
+
+	pages, _ := raw.pageNumbers()
+	page, _ := raw.objects[pages[0]].(model.ObjDict)
+	numbers, visiting := make(map[int]int), make(map[int]bool)
+	s.pages = append(s.pages, streamedPage{
+		contents:  s.writeContents(p.page.Contents),
+		resources: s.importObject(raw, page["Resources"], numbers, visiting),
+	})
+
+	sharedPage, _ := raw.objects[pages[1]].(model.ObjDict)
+	resources, _ := raw.resolve(sharedPage["Resources"]).(model.ObjDict)
+	xObjects, _ := raw.resolve(resources["XObject"]).(model.ObjDict)
+	colorSpaces, _ := raw.resolve(resources["ColorSpace"]).(model.ObjDict)
+	for i, obj := range shared {
+		name := model.ObjName("S" + strconv.Itoa(i))
+		ref := xObjects[name]
+		if cs, ok := raw.resolve(colorSpaces[name]).(model.ObjArray); ok && len(cs) == 2 { // [/ICCBased ref]
+			ref = cs[1]
+		}
+		if ref, ok := s.importObject(raw, ref, numbers, visiting).(model.ObjIndirectRef); ok {
+			obj.replace(ref.ObjectNumber)
+		}
+	}
+
+	// only the page dictionary, with its annotations, is kept
+	p.page.Contents, p.page.Resources = nil, nil
+	p.group = group{}
+}
+
+// writeContents writes the content streams of a page, returning
+// the value of its /Contents entry
+func (s *pageStream) writeContents(contents []model.ContentStream) model.Object {
+	if len(contents) == 0 {
+		return nil
+	}
+	out := make(model.ObjArray, len(contents))
+	for i, content := range contents {
+		header, stream := content.PDFContent()
+		s.writeObject(s.next, string(header.PDFContent()), stream, true)
+		out[i] = model.ObjIndirectRef{ObjectNumber: s.next}
+		s.next++
+	}
+	return out
+}
+
+// collectFonts adds the fonts used by [res], including by its forms and patterns
+func collectFonts(res *model.ResourcesDict, fonts map[*model.FontDict]bool) {
+	for _, font := range res.Font {
+		fonts[font] = true
+	}
+	for _, state := range res.ExtGState {
+		if state.Font.Font != nil {
+			fonts[state.Font.Font] = true
+		}
+		if state.SMask.G != nil {
+			collectFonts(&state.SMask.G.Resources, fonts)
+		}
+	}
+	for _, xo := range res.XObject {
+		switch xo := xo.(type) {
+		case *model.XObjectForm:
+			collectFonts(&xo.Resources, fonts)
+		case *model.XObjectTransparencyGroup:
+			collectFonts(&xo.Resources, fonts)
+		}
+	}
+	for _, pattern := range res.Pattern {
+		if tiling, ok := pattern.(*model.PatternTiling); ok {
+			collectFonts(&tiling.Resources, fonts)
+		}
+	}
+}
+
+// importRef writes the object [number] of [raw], with the objects it references,
+// and returns its reference in the output
+func (s *pageStream) importRef(raw rawPDF, number int, numbers map[int]int, visiting map[int]bool) model.Object {
+	if n, ok := numbers[number]; ok {
+		return model.ObjIndirectRef{ObjectNumber: n}
+	}
+	obj, ok := raw.objects[number]
+	if !ok {
+		return nil
+	}
+	if dict, _ := obj.(model.ObjDict); dict != nil {
+		if name, _ := dict["BaseFont"].(model.ObjName); strings.HasPrefix(string(name), streamFontPrefix) {
+			n, _ := strconv.Atoi(strings.TrimPrefix(string(name), streamFontPrefix))
+			numbers[number] = n
+			return model.ObjIndirectRef{ObjectNumber: n}
+		}
+	}
+	if stream, ok := obj.(model.ObjStream); ok && isStreamPlaceholder(model.Stream{Content: stream.Content}) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(string(stream.Content), streamObjectPrefix))
+		numbers[number] = n
+		return model.ObjIndirectRef{ObjectNumber: n}
+	}
+	if visiting[number] { // the number is required before the object is complete
+		numbers[number] = s.next
+		s.next++
+		return model.ObjIndirectRef{ObjectNumber: numbers[number]}
+	}
+
+	visiting[number] = true
+	obj = s.importObject(raw, obj, numbers, visiting)
+	delete(visiting, number)
+
+	n, reserved := numbers[number]
+	if !reserved {
+		n = s.next
+		s.next++
+		numbers[number] = n
+	}
+	header, content, isStream := serializeObject(obj)
+	s.writeObject(n, header, content, isStream)
+	return model.ObjIndirectRef{ObjectNumber: n}
+}
+
+// importObject returns a copy of [obj], with its references written and updated
+func (s *pageStream) importObject(raw rawPDF, obj model.Object, numbers map[int]int, visiting map[int]bool) model.Object {
+	switch obj := obj.(type) {
+	case model.ObjIndirectRef:
+		return s.importRef(raw, obj.ObjectNumber, numbers, visiting)
+	case model.ObjDict:
+		out := make(model.ObjDict, len(obj))
+		for k, v := range obj {
+			out[k] = s.importObject(raw, v, numbers, visiting)
+		}
+		return out
+	case model.ObjArray:
+		out := make(model.ObjArray, len(obj))
+		for i, v := range obj {
+			out[i] = s.importObject(raw, v, numbers, visiting)
+		}
+		return out
+	case model.ObjStream:
+		return model.ObjStream{Args: s.importObject(raw, obj.Args, numbers, visiting).(model.ObjDict), Content: obj.Content}
+	case streamedObject:
+		return obj.Object
+	default:
+		return obj
+	}
+}
+
+// serializeObject returns the content of the indirect object [obj]
+func serializeObject(obj model.Object) (header string, content []byte, isStream bool) {
+	var w rawWriter
+	if stream, ok := obj.(model.ObjStream); ok {
+		args := stream.Args.Clone().(model.ObjDict)
+		args["Length"] = model.ObjInt(len(stream.Content))
+		return w.format(args, 0), stream.Content, true
+	}
+	return w.format(obj, 0), nil, false
+}
+
+func (s *pageStream) writeObject(number int, header string, content []byte, isStream bool) {
+	var w rawWriter
+	w.writeIndirect(number, header, content, isStream)
+	s.offsets[number] = s.written
+	s.write(w.buf.Bytes())
+}
+
+func (s *pageStream) write(content []byte) {
+	if s.err != nil {
+		return
+	}
+	n, err := s.w.Write(content)
+	s.written += n
+	s.err = err
+	s.hash.Write(content[:n])
+}
+
+// Close writes the last page, the fonts, the page tree and
+// the document catalog of a streaming output (see [NewStreamingOutput]).
+func (c *Output) Close() error {
+	s := c.stream
+	if s == nil {
+		return errors.New("Close is only supported in streaming mode")
+	}
+	c.streamPages(true)
+	if s.err != nil {
+		return s.err
+	}
+	if len(c.pages) == 0 {
+		return errors.New("a streaming output requires at least one page")
+	}
+
+	if err := c.writeFonts(); err != nil {
+		return err
+	}
+	// the fonts are written as the resources of the first page,
+	// which are then replaced by the streamed ones
+	fonts := make(map[model.Name]*model.FontDict, len(s.fonts))
+	for font, number := range s.fonts {
+		fonts[model.Name(streamFontPrefix+strconv.Itoa(number))] = font
+	}
+	kids := make([]model.PageNode, len(c.pages))
+	for i, p := range c.pages {
+		kids[i] = &p.page
+	}
+	c.pages[0].page.Resources = &model.ResourcesDict{Font: fonts}
+	doc := c.document
+	doc.Catalog.Pages = model.PageTree{Kids: kids}
+	var buf bytes.Buffer
+	err := doc.Write(&buf, nil)
+	c.pages[0].page.Resources = nil
+	if err != nil {
+		return err
+	}
+	raw, err := parseRawPDF(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("invalid PDF output: %s", err)
+	}
+	if err = c.addOutputIntent(&raw); err != nil {
+		return err
+	}
+
+	numbers, visiting := make(map[int]int), make(map[int]bool)
+	pages, _ := raw.pageNumbers()
+	firstResources, _ := raw.resolve(raw.objects[pages[0]].(model.ObjDict)["Resources"]).(model.ObjDict)
+	fontRefs, _ := raw.resolve(firstResources["Font"]).(model.ObjDict)
+	for name, ref := range fontRefs {
+		ref, ok := ref.(model.ObjIndirectRef)
+		if !ok {
+			continue
+		}
+		number, _ := strconv.Atoi(strings.TrimPrefix(string(name), streamFontPrefix))
+		numbers[ref.ObjectNumber] = number
+		header, content, isStream := serializeObject(s.importObject(raw, raw.objects[ref.ObjectNumber], numbers, visiting))
+		s.writeObject(number, header, content, isStream)
+	}
+	for i, number := range pages {
+		page, _ := raw.objects[number].(model.ObjDict)
+		delete(page, "Contents")
+		if contents := s.pages[i].contents; contents != nil {
+			page["Contents"] = streamedObject{contents}
+		}
+		page["Resources"] = model.ObjDict{}
+		if resources := s.pages[i].resources; resources != nil {
+			page["Resources"] = streamedObject{resources}
+		}
+	}
+	root := s.importRef(raw, raw.root, numbers, visiting)
+	var info model.Object
+	if raw.info != 0 {
+		info = s.importRef(raw, raw.info, numbers, visiting)
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	var tail bytes.Buffer
+	xref := s.written
+	fmt.Fprintf(&tail, "xref\n0 %d\n0000000000 65535 f \n", s.next)
+	for n := 1; n < s.next; n++ {
+		if offset, ok := s.offsets[n]; ok {
+			fmt.Fprintf(&tail, "%010d 00000 n \n", offset)
+		} else {
+			tail.WriteString("0000000000 00000 f \n")
+		}
+	}
+	fmt.Fprintf(&tail, "trailer\n<<\n/Size %d\n/Root %d 0 R\n", s.next, root.(model.ObjIndirectRef).ObjectNumber)
+	if info != nil {
+		fmt.Fprintf(&tail, "/Info %d 0 R\n", info.(model.ObjIndirectRef).ObjectNumber)
+	}
+	sum := string(s.hash.Sum(nil))
+	tail.WriteString(rawPDF{id: [2]string{sum, sum}}.trailerID())
+	fmt.Fprintf(&tail, ">>\nstartxref\n%d\n%%%%EOF", xref)
+	s.write(tail.Bytes())
+	return s.err
+}
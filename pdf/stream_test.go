@@ -0,0 +1,152 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader"
+	"github.com/benoitkugler/webrender/html/document"
+	"github.com/benoitkugler/webrender/html/tree"
+	"github.com/benoitkugler/webrender/utils"
+)
+
+func renderStreaming(t *testing.T, html string, options Options) ([]byte, *Output, error) {
+	t.Helper()
+	parsedHtml, err := tree.NewHTML(utils.InputString(html), ".", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedHtml.UAStyleSheet = tree.TestUAStylesheet
+	var buf bytes.Buffer
+	output := NewStreamingOutput(&buf)
+	output.Options = options
+	doc := document.Render(parsedHtml, nil, false, fontconfig)
+	doc.Write(output, 1, nil)
+	err = output.Close()
+	return buf.Bytes(), output, err
+}
+
+func TestStreamingOutput(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < 16; i++ {
+		img.Set(i%4, i/4, color.RGBA{R: uint8(16 * i), A: 255})
+	}
+	var imgBuf bytes.Buffer
+	if err := png.Encode(&imgBuf, img); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "logo.png")
+	if err := os.WriteFile(path, imgBuf.Bytes(), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	var html strings.Builder
+	html.WriteString(`<style>@page { size: 200px 200px; margin: 10px } div { break-after: page }</style><h1>Ledger</h1>`)
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&html, `<div id="p%d"><img src="file://%s"> Line %d <a href="#p0">top</a></div>`, i, path, i)
+	}
+	content, output, err := renderStreaming(t, html.String(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output.pages) != 5 || output.pages[0].page.Contents != nil {
+		t.Fatal("the content of the pages should be released")
+	}
+	if _, err := output.Finalize(); err == nil {
+		t.Fatal("expected error when finalizing a streaming output")
+	}
+	// the image is shared by every page
+	if n := bytes.Count(content, []byte("/Subtype /Image")); n != 1 {
+		t.Fatalf("expected one image, got %d", n)
+	}
+	for _, img := range output.cache.images {
+		if !isStreamPlaceholder(img.Stream) {
+			t.Fatal("the written images should be released")
+		}
+	}
+
+	doc, _, err := reader.ParsePDFReader(bytes.NewReader(content), reader.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := doc.Catalog.Pages.Flatten()
+	if len(pages) != 5 {
+		t.Fatalf("expected 5 pages, got %d", len(pages))
+	}
+	for _, page := range pages {
+		if page.Resources == nil || len(page.Resources.Font) == 0 || len(page.Resources.XObject) == 0 || len(page.Annots) != 1 {
+			t.Fatalf("unexpected page %v", page)
+		}
+		for _, font := range page.Resources.Font {
+			if _, ok := font.Subtype.(model.FontType0); !ok {
+				t.Fatalf("unexpected font %v", font.Subtype)
+			}
+		}
+		if content, err := page.DecodeAllContents(); err != nil || !bytes.Contains(content, []byte("Do")) {
+			t.Fatalf("unexpected content %s %v", content, err)
+		}
+	}
+	if doc.Catalog.Outlines == nil || doc.Catalog.Outlines.First == nil || len(doc.Catalog.Names.Dests.Names) != 5 {
+		t.Fatal("missing bookmarks or anchors")
+	}
+}
+
+func TestStreamingOptions(t *testing.T) {
+	html := `<p>Hello</p>`
+	if _, _, err := renderStreaming(t, html, Options{Imposition: &Imposition{Layout: TwoUp}}); err == nil {
+		t.Fatal("expected error for imposition")
+	}
+	if _, _, err := renderStreaming(t, html, Options{Overlays: []Overlay{{Text: "{page}/{pages}"}}}); err == nil {
+		t.Fatal("expected error for the page count")
+	}
+	content, _, err := renderStreaming(t, html, Options{Version: PDF14, Overlays: []Overlay{{Text: "Page {page}"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(content, []byte("%PDF-1.4")) {
+		t.Fatalf("unexpected header %s", content[:10])
+	}
+	if !fileID.Match(content) {
+		t.Fatal("missing file identifier")
+	}
+	if _, _, err := reader.ParsePDFReader(bytes.NewReader(content), reader.Options{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamingSharedColorSpace(t *testing.T) {
+	colors, err := ParseWideGamutColors("color: #ff0000; color: color(display-p3 1 0 0);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := `<style>@page { size: 200px 200px } p { color: #ff0000; break-after: page }</style><p>A</p><p>B</p><p>C</p>`
+	content, _, err := renderStreaming(t, html, Options{WideGamutColors: colors})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(content, []byte("/N 3")); n != 1 {
+		t.Fatalf("expected one ICC profile, got %d", n)
+	}
+	doc, _, err := reader.ParsePDFReader(bytes.NewReader(content), reader.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range doc.Catalog.Pages.Flatten() {
+		if page.Resources == nil || len(page.Resources.ColorSpace) != 1 {
+			t.Fatalf("expected the Display P3 color space, got %v", page.Resources)
+		}
+		for _, cs := range page.Resources.ColorSpace {
+			if icc, ok := cs.(*model.ColorSpaceICCBased); !ok || icc.N != 3 || len(icc.Content) < 100 {
+				t.Fatalf("unexpected color space %v", cs)
+			}
+		}
+	}
+}
@@ -23,6 +23,12 @@ import (
 type pdfFont struct {
 	*backend.FontChars
 	*model.FontDict
+
+	// optional version of the font, not embedded (see [Options.StandardFonts])
+	referenced *referencedFont
+
+	// synthetic styles, see [SyntheticFont]
+	bold, oblique bool
 }
 
 func (g *group) SetTextPaint(op backend.PaintOp) {
@@ -39,43 +45,181 @@ func (g *group) SetTextPaint(op backend.PaintOp) {
 	} else {
 		tr = 3
 	}
-	g.app.Ops(contentstream.OpSetTextRender{Render: tr})
+	g.state.textRender = tr
+	g.setTextRender(tr)
 }
 
 // DrawText draws the given text using the current fill color.
 func (g *group) DrawText(texts []backend.TextDrawing) {
-	g.app.BeginText()
-	defer g.app.EndText()
+	if g.state.textClip { // see SetTextClip
+		g.clipTexts = append(g.clipTexts, positionedText{texts: texts, ctm: g.GetTransform()})
+		return
+	}
+	g.drawPathText(texts) // see Clip
+}
 
-	for _, text := range texts {
-		mat := matrix.New(text.FontSize, 0, 0, -text.FontSize, text.X, text.Y)
-		if text.Angle != 0 { // avoid useless multiplication if angle == 0
-			mat.RightMultBy(matrix.Rotation(text.Angle))
-		}
-		g.app.SetTextMatrix(mat.A, mat.B, mat.C, mat.D, mat.E, mat.F)
+// textMatrix returns the text matrix of [text], transformed by [ctm]
+func textMatrix(text backend.TextDrawing, ctm matrix.Transform) matrix.Transform {
+	mat := matrix.New(text.FontSize, 0, 0, -text.FontSize, text.X, text.Y)
+	if text.Angle != 0 { // avoid useless multiplication if angle == 0
+		mat.RightMultBy(matrix.Rotation(text.Angle))
+	}
+	mat.LeftMultBy(ctm)
+	return mat
+}
+
+// drawText draws [texts] in one text object (if possible),
+// applying the additional transformations
+func (g *group) drawText(texts []positionedText) {
+	if tr := g.state.textRender; tr == 1 || tr == 2 {
+		g.beginTextStroke()
+		defer g.endTextStroke()
+	}
+
+	inText := false
+	for _, pt := range texts {
+		for _, text := range pt.texts {
+			mat := textMatrix(text, pt.ctm)
 
-		for _, run := range text.Runs {
-			pf := g.fonts[run.Font]
-			g.app.SetFontAndSize(pdfFonts.BuiltFont{Meta: pf.FontDict}, 1)
+			var pos fl // advance of the previous runs
+			setMatrix, oblique := true, false
+			for _, run := range text.Runs {
+				pf := g.fonts[run.Font]
+				runMat := mat
+				if pos != 0 {
+					runMat.RightMultBy(matrix.Translation(pos/1000, 0))
+				}
+				runMat = obliqueMatrix(runMat, pf.oblique)
+				if pf.oblique != oblique {
+					setMatrix, oblique = true, pf.oblique
+				}
 
-			var out []contentstream.SpacedGlyph
-			for _, posGlyph := range run.Glyphs {
-				out = append(out, contentstream.SpacedGlyph{
-					SpaceSubtractedBefore: -int(posGlyph.Offset),
-					GID:                   posGlyph.Glyph,
-					SpaceSubtractedAfter:  posGlyph.Kerning,
-				})
+				textRender := g.state.textRender
+				if pf.bold {
+					// the graphic state can't be saved in text objects
+					if inText {
+						g.app.EndText()
+						inText = false
+					}
+					textRender = g.beginSyntheticBold(text.FontSize)
+				}
+
+				if g.fontEmbeddings[run.Font.Origin()] == embedOutlines {
+					// paths are not allowed in text objects
+					if inText {
+						g.app.EndText()
+						inText = false
+					}
+					for _, pass := range g.textPasses(textRender) {
+						g.addOutlines(g, run, runMat)
+						g.paintOutlines(pass)
+					}
+					setMatrix = true
+					if g.options.OutlinesTextLayer {
+						g.app.BeginText()
+						inText = true
+						g.app.SetTextMatrix(runMat.A, runMat.B, runMat.C, runMat.D, runMat.E, runMat.F)
+						setMatrix = false
+						g.app.Ops(contentstream.OpSetTextRender{Render: 3})
+						g.showRun(run, pf)
+						g.setTextRender(textRender)
+					}
+				} else {
+					if g.raster != nil {
+						g.recordText(run, runMat, textRender)
+					}
+					if !inText {
+						g.app.BeginText()
+						inText = true
+					}
+					if passes := g.textPasses(textRender); len(passes) == 1 {
+						if setMatrix {
+							g.app.SetTextMatrix(runMat.A, runMat.B, runMat.C, runMat.D, runMat.E, runMat.F)
+							setMatrix = false
+						}
+						g.showRun(run, pf)
+					} else {
+						g.showRunPasses(run, pf, runMat, passes)
+						g.setTextRender(textRender)
+						setMatrix = false
+					}
+				}
 
 				// PDF readers don't support colored bitmap glyphs
 				// so we have to add them as an image
-				drawText.DrawEmoji(run.Font, posGlyph.Glyph, pf.Extents[posGlyph.Glyph],
-					text.FontSize, text.X, text.Y, posGlyph.XAdvance, g)
+				for _, posGlyph := range run.Glyphs {
+					drawText.DrawEmoji(run.Font, posGlyph.Glyph, pf.Extents[posGlyph.Glyph],
+						text.FontSize, text.X, text.Y, posGlyph.XAdvance, g)
+				}
 
+				if pf.bold {
+					if inText {
+						g.app.EndText()
+						inText = false
+					}
+					g.endSyntheticBold()
+					setMatrix = true
+				}
+				pos += runAdvance(run, pf.Extents)
 			}
+		}
+	}
+	if inText {
+		g.app.EndText()
+	}
+}
+
+// showRun writes the glyphs of [run], in a text object.
+func (g *group) showRun(run backend.TextRun, pf pdfFont) {
+	clusters := runClusters(run, pf.Cmap)
+	// /ActualText requires PDF 1.5
+	actualText := g.options.Version.pdf15()
+	rtl := actualText && hasRTL(clusters)
+	if rtl {
+		// the glyphs are in visual order
+		g.beginActualText(logicalText(clusters))
+		defer g.endActualText()
+	}
+
+	if pf.referenced != nil {
+		if texts, ok := pf.referenced.encode(run, pf.FontChars); ok {
+			g.app.SetFontAndSize(pdfFonts.BuiltFont{Meta: pf.referenced.dict}, 1)
+			g.app.Ops(contentstream.OpShowSpaceText{Texts: texts})
+			return
+		}
+		// fallback to the embedded font
+		pf.referenced.embedded = true
+	}
 
+	g.app.SetFontAndSize(pdfFonts.BuiltFont{Meta: pf.FontDict}, 1)
+
+	var out []contentstream.SpacedGlyph
+	flush := func() {
+		if len(out) != 0 {
 			g.app.Ops(contentstream.OpShowSpaceGlyph{Glyphs: out})
+			out = nil
+		}
+	}
+	for _, cluster := range clusters {
+		// right-to-left runs are already wrapped
+		wrap := actualText && cluster.isComplex() && !rtl
+		if wrap {
+			flush()
+			g.beginActualText(cluster.text)
+		}
+		for _, posGlyph := range run.Glyphs[cluster.start:cluster.end] {
+			out = append(out, contentstream.SpacedGlyph{
+				SpaceSubtractedBefore: -int(posGlyph.Offset),
+				GID:                   posGlyph.Glyph,
+				SpaceSubtractedAfter:  posGlyph.Kerning,
+			})
+		}
+		if wrap {
+			flush()
+			g.endActualText()
 		}
 	}
+	flush()
 }
 
 func (f pdfFont) newFontDescriptor(font backend.Font, content *model.FontFile) model.FontDescriptor {
@@ -86,9 +230,16 @@ func (f pdfFont) newFontDescriptor(font backend.Font, content *model.FontFile) m
 	hash := string(hex.EncodeToString(hash_[:]))
 
 	flags := model.Symbolic // since we use a custom char set
-	if desc.Style != text.FSyNormal {
+	if desc.Style != text.FSyNormal || f.oblique {
 		flags |= model.Italic
 	}
+	if f.bold {
+		flags |= model.ForceBold
+	}
+	var italicAngle fl
+	if f.oblique {
+		italicAngle = syntheticItalicAngle
+	}
 	if strings.Contains(desc.Family, "Serif") {
 		flags |= model.Serif
 	}
@@ -102,7 +253,7 @@ func (f pdfFont) newFontDescriptor(font backend.Font, content *model.FontFile) m
 		FontFamily:  desc.Family,
 		Flags:       flags,
 		FontBBox:    model.Rectangle{Llx: fl(bbox[0]), Lly: fl(bbox[1]), Urx: fl(bbox[2]), Ury: fl(bbox[3])},
-		ItalicAngle: 0,
+		ItalicAngle: italicAngle,
 		Ascent:      desc.Ascent,
 		Descent:     desc.Descent,
 		CapHeight:   fl(bbox[3]),
@@ -125,17 +276,38 @@ func (g *group) AddFont(font backend.Font, content []byte) *backend.FontChars {
 		Cmap:    make(map[backend.GID][]rune),
 		Extents: make(map[backend.GID]backend.GlyphExtents),
 	}
-	// we only initialize the FontDict pointer,
-	// which will be filled later in `writeFonts`
-	g.fonts[font] = pdfFont{
-		FontChars: out,
-		FontDict:  &model.FontDict{},
-	}
-
 	origin := font.Origin()
 	// until then, we store the content
 	if g.fontFiles[origin] == nil {
-		g.fontFiles[origin] = content
+		// decode web fonts, and for collections, only keep the face used
+		face, err := sfntFace(content, origin.Index)
+		if err != nil {
+			log.Printf("font extraction failed: %s", err)
+			face = content
+		}
+		g.fontFiles[origin] = face
+		if g.options.TextOutlines { // the license is not relevant
+			g.fontEmbeddings[origin] = embedOutlines
+		} else {
+			g.fontEmbeddings[origin] = g.options.FontLicensing.checkLicense(font, face)
+		}
+		if font.Description().IsOpentypeOpentype && !g.options.Version.pdf16() {
+			// embedding OpenType fonts with CFF outlines requires PDF 1.6
+			if e := g.fontEmbeddings[origin]; e == embedSubset || e == embedWhole {
+				g.fontEmbeddings[origin] = embedOutlines
+			}
+		}
+	}
+
+	// we only initialize the FontDict pointer,
+	// which will be filled later in `writeFonts`
+	bold, oblique := syntheticStyles(font, g.fontFiles[origin])
+	g.fonts[font] = pdfFont{
+		FontChars:  out,
+		FontDict:   &model.FontDict{},
+		referenced: newReferencedFont(font, g.options),
+		bold:       bold,
+		oblique:    oblique,
 	}
 
 	return out
@@ -171,19 +343,18 @@ func cidWidths(dict map[backend.GID]backend.GlyphExtents) []model.CIDWidth {
 	return widths
 }
 
-func newFontFile(fontDesc backend.FontDescription, font pdfFont, content []byte) *model.FontFile {
+// newFontFile returns the font file to embed. If [glyphs] is not nil,
+// the font is subsetted.
+func newFontFile(fontDesc backend.FontDescription, glyphs glyphSet, content []byte) *model.FontFile {
 	fs := &model.FontFile{}
 	if fontDesc.IsOpentype {
-		// subset the font
-		set := glyphSet{}
-		for gid := range font.Cmap {
-			set.Add(api.GID(gid))
-		}
-		contentS, err := subset(bytes.NewReader(content), set)
-		if err != nil {
-			log.Printf("font subsetting failed: %s", err)
-		} else {
-			content = contentS
+		if glyphs != nil {
+			contentS, err := subset(bytes.NewReader(content), glyphs)
+			if err != nil {
+				log.Printf("font subsetting failed: %s", err)
+			} else {
+				content = contentS
+			}
 		}
 
 		if fontDesc.IsOpentypeOpentype {
@@ -197,14 +368,62 @@ func newFontFile(fontDesc backend.FontDescription, font pdfFont, content []byte)
 }
 
 // post-process the font used
-func (c *Output) writeFonts() {
+func (c *Output) writeFonts() error {
+	var substitute *substituteFont
 	for bFont, font := range c.cache.fonts {
+		if font.referenced != nil {
+			font.referenced.writeWidths()
+			if !font.referenced.embedded {
+				continue
+			}
+		}
 		if len(font.Cmap) == 0 {
 			continue
 		}
 
-		content := c.cache.fontFiles[bFont.Origin()]
-		fs := newFontFile(bFont.Description(), font, content)
+		origin := bFont.Origin()
+		content := c.cache.fontFiles[origin]
+		fontDesc := bFont.Description()
+		set := glyphSet{}
+		for gid := range font.Cmap {
+			set.Add(api.GID(gid))
+		}
+
+		var cidToGID model.CIDToGIDMap
+		embed := true
+		switch c.cache.fontEmbeddings[origin] {
+		case embedOutlines: // glyphs are drawn as paths
+			if !c.Options.OutlinesTextLayer {
+				continue
+			}
+			// only used for invisible text
+			embed = false
+		case embedForbidden:
+			return fmt.Errorf("font %s (%s) has a restricted license and can't be embedded", fontDesc.Family, origin.File)
+		case embedWhole:
+			set = nil
+		case embedSubstitute:
+			if substitute == nil {
+				var err error
+				substitute, err = c.Options.FontLicensing.loadSubstitute()
+				if err != nil {
+					return err
+				}
+			}
+			glyphs := substitute.glyphs(font)
+			set = glyphSet{}
+			for _, gid := range glyphs {
+				set.Add(gid)
+			}
+			cidToGID = cidToGIDMap(glyphs)
+			content = substitute.content
+			fontDesc.IsOpentype, fontDesc.IsOpentypeOpentype = true, false
+		}
+
+		var fs *model.FontFile
+		if embed {
+			fs = newFontFile(fontDesc, set, content)
+		}
 		desc := font.newFontDescriptor(bFont, fs)
 		widths := cidWidths(font.Extents)
 
@@ -221,9 +440,11 @@ func (c *Output) writeFonts() {
 				},
 				W:              widths,
 				FontDescriptor: desc,
+				CIDToGIDMap:    cidToGID,
 			},
 		}
 		cmap := cmaps.WriteAdobeIdentityUnicodeCMap(font.Cmap)
 		font.FontDict.ToUnicode = &model.UnicodeCMap{Stream: model.Stream{Content: cmap}}
 	}
+	return nil
 }
@@ -0,0 +1,170 @@
+package pdf
+
+import (
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+)
+
+// Glyphs whose mapping to the text is not one to one (ligatures, complex shaping)
+// and right-to-left text can't be extracted from the ToUnicode CMap alone, and are
+// wrapped in /Span marked content with an /ActualText entry.
+// See the PDF specification, section 14.9.4.
+//
+// webrender does not provide the clusters found by the shaping: the text of each
+// glyph is the one recorded in [backend.FontChars.Cmap] the first time the glyph is
+// drawn, which is also used for the ToUnicode CMap. As a consequence, a glyph
+// shaped from different texts (for instance a ligature or a contextual form
+// produced by several sequences of characters) always gets the first text.
+
+// textCluster is a range of glyphs representing [text]
+type textCluster struct {
+	start, end int // glyph indices in the run
+	text       []rune
+}
+
+// isComplex returns true if the mapping between
+// glyphs and characters is not one to one
+func (tc textCluster) isComplex() bool {
+	return tc.end-tc.start != 1 || len(tc.text) != 1
+}
+
+// runClusters groups the glyphs of [run] using the text of [cmap],
+// which is shared by all the runs using the font.
+// The text of a cluster is mapped to its last glyph, so that glyphs without
+// text belong to the following cluster (or to the last one, at the end of the run).
+func runClusters(run backend.TextRun, cmap map[backend.GID][]rune) []textCluster {
+	var (
+		out   []textCluster
+		start int
+	)
+	for i, glyph := range run.Glyphs {
+		runes := cmap[glyph.Glyph]
+		if len(runes) == 0 {
+			continue
+		}
+		out = append(out, textCluster{start: start, end: i + 1, text: runes})
+		start = i + 1
+	}
+	if start < len(run.Glyphs) {
+		if L := len(out); L != 0 {
+			out[L-1].end = len(run.Glyphs)
+		} else {
+			out = append(out, textCluster{start: start, end: len(run.Glyphs)})
+		}
+	}
+	return out
+}
+
+// rtlScripts are the scripts written from right to left
+var rtlScripts = []*unicode.RangeTable{
+	unicode.Hebrew, unicode.Arabic, unicode.Syriac, unicode.Thaana,
+	unicode.Nko, unicode.Samaritan, unicode.Mandaic,
+}
+
+func isRTL(r rune) bool { return unicode.In(r, rtlScripts...) }
+
+// isLTR returns true for letters and digits not written from right to left
+func isLTR(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r)) && !isRTL(r)
+}
+
+// hasRTL returns true if some clusters contain right-to-left text
+func hasRTL(clusters []textCluster) bool {
+	for _, cl := range clusters {
+		for _, r := range cl.text {
+			if isRTL(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// logicalText returns the text of [clusters], given in visual order,
+// in logical (reading) order, assuming a right-to-left paragraph:
+// the clusters are reversed, except for the embedded left-to-right
+// sequences (such as latin words or numbers).
+func logicalText(clusters []textCluster) []rune {
+	const (
+		neutral = iota
+		ltr
+		rtl
+	)
+	L := len(clusters)
+	reversed := make([]textCluster, L)
+	kinds := make([]uint8, L)
+	for i, cl := range clusters {
+		reversed[L-1-i] = cl
+		for _, r := range cl.text {
+			if isRTL(r) {
+				kinds[L-1-i] = rtl
+				break
+			} else if isLTR(r) {
+				kinds[L-1-i] = ltr
+			}
+		}
+	}
+
+	// restore the order of the left-to-right sequences,
+	// including the neutral characters they enclose
+	for start := 0; start < L; {
+		if kinds[start] != ltr {
+			start++
+			continue
+		}
+		end := start // last ltr cluster of the sequence
+		for i := start + 1; i < L && kinds[i] != rtl; i++ {
+			if kinds[i] == ltr {
+				end = i
+			}
+		}
+		for i, j := start, end; i < j; i, j = i+1, j-1 {
+			reversed[i], reversed[j] = reversed[j], reversed[i]
+		}
+		start = end + 1
+	}
+
+	var out []rune
+	for _, cl := range reversed {
+		out = append(out, cl.text...)
+	}
+	return out
+}
+
+// encodeTextString encodes [text] as a PDF text string,
+// as required in content streams
+func encodeTextString(text []rune) string {
+	isASCII := true
+	for _, r := range text {
+		if r >= 0x80 {
+			isASCII = false
+			break
+		}
+	}
+	if isASCII {
+		return string(text)
+	}
+	out := []byte{0xFE, 0xFF} // BOM
+	for _, u := range utf16.Encode(text) {
+		out = append(out, byte(u>>8), byte(u))
+	}
+	return string(out)
+}
+
+// beginActualText starts a marked content sequence replacing
+// the text of the glyphs by [text].
+// It must be followed by [endActualText].
+func (g *group) beginActualText(text []rune) {
+	g.app.Ops(contentstream.OpBeginMarkedContent{
+		Tag: "Span",
+		Properties: contentstream.PropertyListDict{
+			"ActualText": model.ObjStringLiteral(encodeTextString(text)),
+		},
+	})
+}
+
+func (g *group) endActualText() { g.app.Ops(contentstream.OpEndMarkedContent{}) }
@@ -0,0 +1,108 @@
+package pdf
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+)
+
+func TestRunClusters(t *testing.T) {
+	cmap := map[backend.GID][]rune{1: []rune("a"), 2: []rune("fi"), 3: nil}
+	run := backend.TextRun{Glyphs: []backend.TextGlyph{{Glyph: 1}, {Glyph: 2}, {Glyph: 3}, {Glyph: 1}, {Glyph: 3}}}
+	clusters := runClusters(run, cmap)
+	expected := []textCluster{
+		{start: 0, end: 1, text: []rune("a")},
+		{start: 1, end: 2, text: []rune("fi")},
+		{start: 2, end: 5, text: []rune("a")},
+	}
+	if len(clusters) != len(expected) {
+		t.Fatalf("unexpected clusters %v", clusters)
+	}
+	for i, cl := range clusters {
+		exp := expected[i]
+		if cl.start != exp.start || cl.end != exp.end || string(cl.text) != string(exp.text) {
+			t.Fatalf("expected %v, got %v", exp, cl)
+		}
+	}
+	if clusters[0].isComplex() || !clusters[1].isComplex() || !clusters[2].isComplex() {
+		t.Fatal("invalid complex clusters")
+	}
+}
+
+func TestLogicalText(t *testing.T) {
+	for _, test := range []struct {
+		visual, logical string
+	}{
+		{"םולש", "שלום"},
+		{"םלוע םולש", "שלום עולם"},
+		{"PDF 2.0 םע", "עם PDF 2.0"},
+	} {
+		var clusters []textCluster
+		for _, r := range test.visual {
+			clusters = append(clusters, textCluster{text: []rune{r}})
+		}
+		if got := string(logicalText(clusters)); got != test.logical {
+			t.Fatalf("for %s, expected %s, got %s", test.visual, test.logical, got)
+		}
+	}
+}
+
+func TestEncodeTextString(t *testing.T) {
+	if s := encodeTextString([]rune("fi")); s != "fi" {
+		t.Fatalf("unexpected %q", s)
+	}
+	if s := encodeTextString([]rune("ש")); s != "\xfe\xff\x05\xe9" {
+		t.Fatalf("unexpected %q", s)
+	}
+}
+
+func TestActualText(t *testing.T) {
+	otf, err := os.ReadFile("../resources_test/weasyprint.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	font := newTestFont("Test")
+	output := NewOutput()
+	page := output.AddPage(0, 0, 100, 100)
+	chars := page.AddFont(font, otf)
+	for gid, text := range map[backend.GID]string{36: "A", 37: "fi", 38: "ש", 39: "ל"} {
+		chars.Cmap[gid] = []rune(text)
+		chars.Extents[gid] = backend.GlyphExtents{Width: 600}
+	}
+	glyphs := func(gids ...backend.GID) []backend.TextGlyph {
+		var out []backend.TextGlyph
+		for _, gid := range gids {
+			out = append(out, backend.TextGlyph{Glyph: gid})
+		}
+		return out
+	}
+	page.DrawText([]backend.TextDrawing{
+		{Runs: []backend.TextRun{{Font: font, Glyphs: glyphs(36, 37, 36)}}, FontSize: 12},
+		{Runs: []backend.TextRun{{Font: font, Glyphs: glyphs(39, 38)}}, FontSize: 12},
+	})
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := doc.Catalog.Pages.Kids[0].(*model.PageObject).Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(content)
+	if strings.Count(s, "/Span") != 2 || strings.Count(s, "BDC") != 2 || strings.Count(s, "EMC") != 2 {
+		t.Fatalf("expected two spans, got %s", s)
+	}
+	if !strings.Contains(s, "(fi)") {
+		t.Fatalf("missing ligature text in %s", s)
+	}
+	if !strings.Contains(s, "(\xfe\xff\x05\xe9\x05\xdc)") {
+		t.Fatalf("expected logical order for RTL text in %s", s)
+	}
+	// simple clusters are shown in the same text operations
+	if strings.Count(s, "TJ") != 4 {
+		t.Fatalf("unexpected text operations in %s", s)
+	}
+}
@@ -0,0 +1,188 @@
+package pdf
+
+import (
+	"bytes"
+
+	"github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/matrix"
+)
+
+// text may be used as a clipping path, either explicitly with [TextClipper]
+// (text rendering modes 4 to 7), or implicitly, for SVG clip paths, using
+// the glyph outlines.
+//
+// The backend has no notion of clip path: webrender draws the content of an SVG clip path
+// without painting the shapes, but the text is drawn as usual. The end of the
+// clip path is detected by the empty rectangle webrender adds before clipping
+// (see [group.Clip]); the text drawn since the last painting operation is then
+// added to the clip region, and its drawing is disabled afterwards (see [hiddenText]).
+
+// TextClipper is implemented by the canvases of this package,
+// and may be used with a type assertion on [backend.Canvas].
+// Note that webrender does not call it : in particular, the CSS
+// 'background-clip: text' property is not supported.
+type TextClipper interface {
+	// SetTextClip starts (if [clip] is true) or ends a clipping text sequence.
+	// The text drawn in between is painted according to [backend.GraphicState.SetTextPaint]
+	// (use SetTextPaint(0) to only clip), and is added to the clip region when
+	// the sequence ends.
+	// As for [backend.GraphicState.Clip], the clip region can only be reduced,
+	// and is restored by [backend.Canvas.OnNewStack], so that subsequent painting
+	// operations (like [backend.Canvas.DrawGradient] or a pattern fill) are only
+	// visible through the glyph shapes.
+	SetTextClip(clip bool)
+}
+
+var _ TextClipper = (*group)(nil)
+
+// positionedText stores text drawn with the transformation [ctm]
+type positionedText struct {
+	texts []backend.TextDrawing
+	ctm   matrix.Transform
+}
+
+// svgClipText is the text which may belong to an SVG clip path
+type svgClipText struct {
+	texts []positionedText
+	// shared by the [hiddenText] operations wrapping the text
+	hidden *bool
+	// the number of raster items recorded before the text
+	items int
+}
+
+// hiddenText wraps the operations drawing a text : if the text is
+// later used as a clip path, it is hidden by an empty clip region.
+// Otherwise, nothing is written.
+type hiddenText struct {
+	hidden *bool
+	begin  bool
+}
+
+func (op hiddenText) Add(out *bytes.Buffer) {
+	if !*op.hidden {
+		return
+	}
+	if op.begin {
+		out.WriteString("q 0 0 0 0 re W n")
+	} else {
+		out.WriteByte('Q')
+	}
+}
+
+// drawPathText draws [texts], which may belong to an SVG clip path
+func (g *group) drawPathText(texts []backend.TextDrawing) {
+	if g.pathTexts == nil {
+		g.pathTexts = &svgClipText{hidden: new(bool)}
+		if g.raster != nil {
+			g.pathTexts.items = len(g.raster.items)
+		}
+	}
+	pt := g.pathTexts
+	pt.texts = append(pt.texts, positionedText{texts: texts, ctm: g.GetTransform()})
+	g.app.Ops(hiddenText{hidden: pt.hidden, begin: true})
+	g.drawText([]positionedText{{texts: texts, ctm: matrix.Identity()}})
+	g.app.Ops(hiddenText{hidden: pt.hidden})
+}
+
+// clipWithPathText adds the outlines of the pending SVG clip path text
+// to the current path, and hides its drawing.
+func (g *group) clipWithPathText() {
+	pt := g.pathTexts
+	ctm := g.GetTransform()
+	if pt == nil || ctm.Invert() != nil {
+		return
+	}
+	for _, text := range pt.texts {
+		g.addTextOutlines(g, text.texts, matrix.Mul(ctm, text.ctm))
+	}
+	*pt.hidden = true
+	if g.raster != nil {
+		g.raster.items = g.raster.items[:pt.items]
+	}
+}
+
+func (g *group) SetTextClip(clip bool) {
+	if g.state.textClip == clip {
+		return
+	}
+	g.state.textClip = clip
+	if !clip {
+		g.clipWithText()
+	}
+}
+
+// setTextRender writes the text rendering mode [tr] (from 0 to 3),
+// adding the clip flag if required
+func (g *group) setTextRender(tr uint8) {
+	if g.state.textClip {
+		tr += 4
+	}
+	g.app.Ops(contentstream.OpSetTextRender{Render: tr})
+}
+
+// clipWithText draws the text stored by [group.SetTextClip]
+// and adds it to the clip region.
+// Since each text object or path reduces the clip region,
+// all the text is drawn in one operation.
+func (g *group) clipWithText() {
+	texts := g.clipTexts
+	g.clipTexts = nil
+	if len(texts) == 0 {
+		return
+	}
+
+	ctm := g.GetTransform()
+	if err := ctm.Invert(); err != nil {
+		return
+	}
+	var hasOutlines bool
+	for _, pt := range texts {
+		for _, text := range pt.texts {
+			for _, run := range text.Runs {
+				hasOutlines = hasOutlines || g.fontEmbeddings[run.Font.Origin()] == embedOutlines
+			}
+		}
+	}
+
+	if hasOutlines {
+		// text objects and paths can't be combined: use paths only
+		for _, pt := range texts {
+			g.addTextOutlines(g, pt.texts, matrix.Mul(ctm, pt.ctm))
+		}
+		g.app.Ops(contentstream.OpClip{})
+		if g.raster != nil {
+			g.recordClip()
+		}
+		g.paintOutlines(g.state.textRender)
+		return
+	}
+
+	g.state.textClip = true // use clipping modes
+	for i, pt := range texts {
+		texts[i].ctm = matrix.Mul(ctm, pt.ctm)
+	}
+	g.setTextRender(g.state.textRender)
+	g.drawText(texts)
+	if g.raster != nil { // see recordText
+		g.recordClip()
+	}
+	g.state.textClip = false
+	g.setTextRender(g.state.textRender)
+}
+
+// addTextOutlines adds the glyphs of [texts] to the path of [sink],
+// using the transformation [ctm].
+func (g *group) addTextOutlines(sink pathSink, texts []backend.TextDrawing, ctm matrix.Transform) {
+	for _, text := range texts {
+		mat := textMatrix(text, ctm)
+		var pos fl
+		for _, run := range text.Runs {
+			pf := g.fonts[run.Font]
+			runMat := mat
+			runMat.RightMultBy(matrix.Translation(pos/1000, 0))
+			g.addOutlines(sink, run, obliqueMatrix(runMat, pf.oblique))
+			pos += runAdvance(run, pf.Extents)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package pdf
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+// clipText draws two lines of text, clipping a gradient, and
+// returns the content stream
+func clipText(t *testing.T, output *Output, content []byte) string {
+	font := newTestFont("Test")
+	page := output.AddPage(0, 0, 100, 100)
+	chars := page.AddFont(font, content)
+	chars.Cmap[36] = []rune{'A'}
+	chars.Extents[36] = backend.GlyphExtents{Width: 600}
+	line := func(y fl) []backend.TextDrawing {
+		return []backend.TextDrawing{{
+			Runs:     []backend.TextRun{{Font: font, Glyphs: []backend.TextGlyph{{Glyph: 36}, {Glyph: 36}}}},
+			FontSize: 12,
+			X:        10,
+			Y:        y,
+		}}
+	}
+	page.OnNewStack(func() {
+		page.State().SetTextPaint(0)
+		page.(TextClipper).SetTextClip(true)
+		page.DrawText(line(20))
+		page.DrawText(line(40))
+		page.(TextClipper).SetTextClip(false)
+		page.DrawGradient(backend.GradientLayout{
+			ScaleY:       1,
+			GradientKind: backend.GradientKind{Kind: "linear", Coords: [6]fl{0, 0, 100, 0}},
+			Positions:    []fl{0, 1},
+			Colors:       []parser.RGBA{{R: 1, A: 1}, {B: 1, A: 1}},
+		}, 100, 100)
+	})
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := doc.Catalog.Pages.Kids[0].(*model.PageObject).Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestTextClip(t *testing.T) {
+	otf, err := os.ReadFile("../resources_test/weasyprint.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := clipText(t, NewOutput(), otf)
+	// both lines are in the same text object, with the clip mode
+	if strings.Count(content, "BT") != 1 || !strings.Contains(content, "7 Tr") {
+		t.Fatalf("expected one clipping text object, got %s", content)
+	}
+	if strings.Index(content, "ET") > strings.Index(content, "sh") {
+		t.Fatalf("expected the gradient after the clip, got %s", content)
+	}
+
+	output := NewOutput()
+	output.Options.TextOutlines = true
+	content = clipText(t, output, otf)
+	if strings.Contains(content, "BT") || strings.Count(content, "W\nn") != 1 {
+		t.Fatalf("expected one clipping path, got %s", content)
+	}
+}
+
+func TestSVGClipPathText(t *testing.T) {
+	doc := htmlToModel(t, `
+		<style>@font-face {src: url(../resources_test/weasyprint.otf); font-family: weasyprint}</style>
+		<svg width="100" height="100">
+			<defs><clipPath id="clip"><text x="10" y="50" font-family="weasyprint" font-size="20">ABC</text></clipPath></defs>
+			<rect width="100" height="100" fill="red" clip-path="url(#clip)" />
+		</svg>`)
+	content, err := doc.Catalog.Pages.Kids[0].(*model.PageObject).Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the glyph outlines are added to the clipping path
+	clip := strings.LastIndex(string(content), "W\nn")
+	if clip == -1 || !strings.Contains(string(content)[:clip], " m\n") {
+		t.Fatalf("expected glyph outlines in the clipping path, got %s", content)
+	}
+	// the text itself is not painted
+	hide := strings.Index(string(content), "q 0 0 0 0 re W n")
+	if bt := strings.Index(string(content), "BT"); hide == -1 || bt < hide {
+		t.Fatalf("expected hidden text, got %s", content)
+	}
+}
+
+func TestPathTextsReset(t *testing.T) {
+	output := NewOutput()
+	font := newTestFont("Test")
+	page := output.AddPage(0, 0, 100, 100)
+	page.AddFont(font, nil)
+	text := []backend.TextDrawing{{Runs: []backend.TextRun{{Font: font, Glyphs: []backend.TextGlyph{{Glyph: 36}}}}, FontSize: 12}}
+	page.DrawText(text)
+	page.DrawText(text)
+	if pt := page.(*outputPage).pathTexts; pt == nil || len(pt.texts) != 2 {
+		t.Fatal("expected pending text")
+	}
+	page.Rectangle(0, 0, 10, 10)
+	page.Paint(backend.FillNonZero)
+	if page.(*outputPage).pathTexts != nil {
+		t.Fatal("pending text should be released after painting")
+	}
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := doc.Catalog.Pages.Kids[0].(*model.PageObject).Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "re W n") {
+		t.Fatalf("unexpected hidden text in %s", content)
+	}
+}
@@ -0,0 +1,64 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/go-text/typesetting/opentype/loader"
+)
+
+// font collections (.ttc, .otc and .dfont) can't be embedded as such in PDF files:
+// only the face actually used must be written
+
+var ttcTag = loader.MustNewTag("ttcf")
+
+// dfontResourceDataOffset is the magic number of Mac resource fork files
+const dfontResourceDataOffset = 0x00000100
+
+func isCollection(content []byte) bool {
+	if len(content) < 4 {
+		return false
+	}
+	magic := binary.BigEndian.Uint32(content)
+	return magic == uint32(ttcTag) || magic == dfontResourceDataOffset
+}
+
+// extractFace returns the face at [index] in the given collection,
+// as a standalone font file.
+// Other font files are returned unchanged.
+func extractFace(content []byte, index uint16) ([]byte, error) {
+	if !isCollection(content) {
+		return content, nil
+	}
+
+	lds, err := loader.NewLoaders(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid font collection: %s", err)
+	}
+	if int(index) >= len(lds) {
+		return nil, fmt.Errorf("invalid face index %d for a collection of %d fonts", index, len(lds))
+	}
+
+	return writeFace(lds[index])
+}
+
+// writeFace writes all the tables of [ld] in a new font file.
+func writeFace(ld *loader.Loader) ([]byte, error) {
+	tags := ld.Tables()
+	tables := make([]loader.Table, len(tags))
+	for i, tag := range tags {
+		content, err := ld.RawTable(tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid font table %s: %s", tag, err)
+		}
+		tables[i] = loader.Table{Tag: tag, Content: content}
+	}
+
+	out := loader.WriteTTF(tables)
+	// preserve the CFF flavor, since [loader.WriteTTF] always uses the TrueType one
+	if ld.Type == loader.OpenType {
+		binary.BigEndian.PutUint32(out, uint32(loader.OpenType))
+	}
+	return out, nil
+}
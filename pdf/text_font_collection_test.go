@@ -0,0 +1,107 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/go-text/typesetting/opentype/loader"
+)
+
+// buildCollection concatenates the given fonts into a .ttc file,
+// adjusting the table offsets
+func buildCollection(fonts ...[]byte) []byte {
+	headerSize := 12 + 4*len(fonts)
+	header := make([]byte, headerSize)
+	copy(header, "ttcf")
+	binary.BigEndian.PutUint32(header[4:], 0x00010000)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(fonts)))
+
+	out := header
+	for i, font := range fonts {
+		offset := uint32(len(out))
+		binary.BigEndian.PutUint32(out[12+4*i:], offset)
+
+		font = append([]byte(nil), font...)
+		numTables := int(binary.BigEndian.Uint16(font[4:]))
+		for j := 0; j < numTables; j++ {
+			entry := font[12+16*j:]
+			binary.BigEndian.PutUint32(entry[8:], binary.BigEndian.Uint32(entry[8:])+offset)
+		}
+		out = append(out, font...)
+	}
+	return out
+}
+
+func loadTables(t *testing.T, content []byte) map[loader.Tag][]byte {
+	ld, err := loader.NewLoader(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[loader.Tag][]byte)
+	for _, tag := range ld.Tables() {
+		out[tag], err = ld.RawTable(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out
+}
+
+func TestExtractFace(t *testing.T) {
+	font, err := os.ReadFile("../resources_test/weasyprint.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// single files are not modified
+	got, err := extractFace(font, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, font) {
+		t.Fatal("font file should be unchanged")
+	}
+
+	// use a different face to check the index is respected
+	var tables []loader.Table
+	for _, tag := range mustLoader(t, font).Tables() {
+		if tag == gPOSTag {
+			continue
+		}
+		tables = append(tables, loader.Table{Tag: tag, Content: loadTables(t, font)[tag]})
+	}
+	other := loader.WriteTTF(tables)
+
+	collection := buildCollection(font, other)
+	if !isCollection(collection) {
+		t.Fatal("expected a collection")
+	}
+
+	for index, expected := range [][]byte{font, other} {
+		face, err := extractFace(collection, uint16(index))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isCollection(face) {
+			t.Fatal("expected a single font")
+		}
+		if got, exp := loadTables(t, face), loadTables(t, expected); !reflect.DeepEqual(got, exp) {
+			t.Fatalf("invalid tables for face %d", index)
+		}
+	}
+
+	if _, err = extractFace(collection, 2); err == nil {
+		t.Fatal("expected error for invalid index")
+	}
+}
+
+func mustLoader(t *testing.T, content []byte) *loader.Loader {
+	ld, err := loader.NewLoader(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ld
+}
@@ -0,0 +1,308 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/matrix"
+	"github.com/go-text/typesetting/opentype/api"
+	"github.com/go-text/typesetting/opentype/api/font"
+	"github.com/go-text/typesetting/opentype/loader"
+)
+
+// font licenses are described by the 'fsType' field of the OS/2 table
+// See https://learn.microsoft.com/en-us/typography/opentype/spec/os2#fstype
+
+var os2Tag = loader.MustNewTag("OS/2")
+
+const (
+	fsTypeRestricted   = 0x0002
+	fsTypePreviewPrint = 0x0004
+	fsTypeEditable     = 0x0008
+	fsTypeNoSubsetting = 0x0100
+	fsTypeBitmapOnly   = 0x0200
+)
+
+// RestrictedFontPolicy defines how fonts whose license
+// forbids embedding are handled.
+type RestrictedFontPolicy uint8
+
+const (
+	// FailOnRestrictedFont makes [Output.Finalize] return an error.
+	FailOnRestrictedFont RestrictedFontPolicy = iota
+	// SubstituteRestrictedFont embeds [FontLicensing.Substitute] instead
+	// of the restricted font.
+	SubstituteRestrictedFont
+	// OutlineRestrictedFont draws the glyphs as vector paths, so
+	// that the font file is never embedded.
+	OutlineRestrictedFont
+)
+
+// FontLicensing controls how the embedding permissions of
+// the fonts (as specified by their OS/2 fsType field) are respected.
+// Fonts allowing preview and print embedding are embedded with a warning,
+// and fonts forbidding subsetting are embedded whole.
+type FontLicensing struct {
+	// Restricted is the policy applied to fonts which
+	// may not be embedded.
+	Restricted RestrictedFontPolicy
+
+	// Substitute is the font file used in place of restricted fonts,
+	// when [Restricted] is [SubstituteRestrictedFont].
+	// It must be a TrueType font (with 'glyf' outlines), whose glyphs are
+	// selected using the text content, while the original widths are preserved.
+	Substitute []byte
+}
+
+// fontEmbedding is the way a font is written in the output
+type fontEmbedding uint8
+
+const (
+	embedSubset fontEmbedding = iota
+	embedWhole
+	embedSubstitute
+	embedOutlines
+	embedForbidden
+)
+
+// readFsType returns the 'fsType' field of [content].
+// Fonts without OS/2 table are assumed to be installable.
+func readFsType(content []byte) (uint16, error) {
+	ld, err := loader.NewLoader(bytes.NewReader(content))
+	if err != nil {
+		return 0, err
+	}
+	if !ld.HasTable(os2Tag) {
+		return 0, nil
+	}
+	os2, err := ld.RawTable(os2Tag)
+	if err != nil {
+		return 0, err
+	}
+	if len(os2) < 10 {
+		return 0, fmt.Errorf("invalid OS/2 table length %d", len(os2))
+	}
+	return binary.BigEndian.Uint16(os2[8:]), nil
+}
+
+// isRestricted returns true if [fsType] forbids
+// to embed the font outlines.
+// If several permissions are set, the least restrictive one applies.
+func isRestricted(fsType uint16) bool {
+	if fsType&fsTypeBitmapOnly != 0 {
+		// we never embed bitmaps
+		return true
+	}
+	usage := fsType & 0x000F
+	return usage&fsTypeRestricted != 0 && usage&(fsTypePreviewPrint|fsTypeEditable) == 0
+}
+
+// embedding applies the policy to a font with the given [fsType]
+func (lic FontLicensing) embedding(fsType uint16) fontEmbedding {
+	if isRestricted(fsType) {
+		switch lic.Restricted {
+		case SubstituteRestrictedFont:
+			return embedSubstitute
+		case OutlineRestrictedFont:
+			return embedOutlines
+		default:
+			return embedForbidden
+		}
+	}
+	if fsType&fsTypeNoSubsetting != 0 {
+		return embedWhole
+	}
+	return embedSubset
+}
+
+// checkLicense reads the license of the font file [content] and
+// returns how it should be embedded.
+// Only an explicit fsType restricts the embedding: fonts whose license
+// can't be read are embedded as subsets.
+func (lic FontLicensing) checkLicense(font backend.Font, content []byte) fontEmbedding {
+	fsType, err := readFsType(content)
+	if err != nil {
+		log.Printf("reading license of font %s failed: %s", font.Description().Family, err)
+		return embedSubset
+	}
+	if isRestricted(fsType) {
+		log.Printf("font %s has a restricted license", font.Description().Family)
+	} else if fsType&fsTypePreviewPrint != 0 && fsType&fsTypeEditable == 0 {
+		log.Printf("font %s is embedded with a preview and print license: the output should not be edited", font.Description().Family)
+	}
+	return lic.embedding(fsType)
+}
+
+// substituteFont is the parsed [FontLicensing.Substitute]
+type substituteFont struct {
+	content []byte // decoded font file
+	font    *font.Font
+}
+
+// loadSubstitute parses and validates [FontLicensing.Substitute]
+func (lic FontLicensing) loadSubstitute() (*substituteFont, error) {
+	if len(lic.Substitute) == 0 {
+		return nil, fmt.Errorf("missing substitute font")
+	}
+	content, err := sfntFace(lic.Substitute, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid substitute font: %s", err)
+	}
+	ld, err := loader.NewLoader(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid substitute font: %s", err)
+	}
+	if !ld.HasTable(glyfTag) {
+		return nil, fmt.Errorf("invalid substitute font: only TrueType fonts are supported")
+	}
+	if fsType, err := readFsType(content); err == nil && isRestricted(fsType) {
+		return nil, fmt.Errorf("invalid substitute font: restricted license")
+	}
+	ft, err := font.NewFont(ld)
+	if err != nil {
+		return nil, fmt.Errorf("invalid substitute font: %s", err)
+	}
+	return &substituteFont{content: content, font: ft}, nil
+}
+
+// glyphs maps the glyphs used in [font] to the glyphs
+// of [substitute], using the text they represent.
+// The returned slice is indexed by the original glyphs.
+func (substitute *substituteFont) glyphs(font pdfFont) []api.GID {
+	var maxGID backend.GID
+	for gid := range font.Cmap {
+		if gid > maxGID {
+			maxGID = gid
+		}
+	}
+	out := make([]api.GID, maxGID+1)
+	for gid, runes := range font.Cmap {
+		if len(runes) == 0 {
+			continue
+		}
+		// for clusters, only the first rune is used
+		out[gid], _ = substitute.font.NominalGlyph(runes[0])
+	}
+	return out
+}
+
+// cidToGIDMap returns the binary mapping expected by CIDFontType2 fonts
+func cidToGIDMap(glyphs []api.GID) model.CIDToGIDMapStream {
+	content := make([]byte, 2*len(glyphs))
+	for cid, gid := range glyphs {
+		binary.BigEndian.PutUint16(content[2*cid:], uint16(gid))
+	}
+	return model.CIDToGIDMapStream{Stream: model.Stream{Content: content}}
+}
+
+// outlineFace returns the parsed font used to draw glyph outlines
+func (g *group) outlineFace(ft backend.Font) (*font.Face, error) {
+	origin := ft.Origin()
+	if face := g.outlineFaces[origin]; face != nil {
+		return face, nil
+	}
+	ld, err := loader.NewLoader(bytes.NewReader(g.fontFiles[origin]))
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := font.NewFont(ld)
+	if err != nil {
+		return nil, err
+	}
+	face := &font.Face{Font: parsed}
+	g.outlineFaces[origin] = face
+	return face, nil
+}
+
+// pathSink is implemented by [group] and [pathRecorder]
+type pathSink interface {
+	MoveTo(x, y fl)
+	LineTo(x, y fl)
+	CubicTo(x1, y1, x2, y2, x3, y3 fl)
+	ClosePath()
+}
+
+// addOutlines adds the glyphs of [run] to the path of [sink], using the text matrix [mat].
+func (g *group) addOutlines(sink pathSink, run backend.TextRun, mat matrix.Transform) {
+	face, err := g.outlineFace(run.Font)
+	if err != nil {
+		log.Printf("loading glyph outlines failed: %s", err)
+		return
+	}
+	extents := g.fonts[run.Font].Extents
+	scale := 1000 / fl(face.Upem())
+	var pos fl
+	for _, glyph := range run.Glyphs {
+		pos += glyph.Offset
+		if outline, ok := face.GlyphData(api.GID(glyph.Glyph)).(api.GlyphOutline); ok {
+			outlinePath(sink, outline, mat, pos, scale)
+		}
+		pos += fl(extents[glyph.Glyph].Width) - fl(glyph.Kerning)
+	}
+}
+
+// paintOutlines paints the current path according to
+// the text rendering mode [textRender].
+func (g *group) paintOutlines(textRender uint8) {
+	switch textRender {
+	case 0:
+		g.Paint(backend.FillNonZero)
+	case 1:
+		g.Paint(backend.Stroke)
+	case 2:
+		g.Paint(backend.FillNonZero | backend.Stroke)
+	default:
+		g.Paint(0)
+	}
+}
+
+// runAdvance returns the advance of [run],
+// in thousandths of text space units.
+func runAdvance(run backend.TextRun, extents map[backend.GID]backend.GlyphExtents) fl {
+	var advance fl
+	for _, glyph := range run.Glyphs {
+		advance += glyph.Offset + fl(extents[glyph.Glyph].Width) - fl(glyph.Kerning)
+	}
+	return advance
+}
+
+// outlinePath adds the glyph [outline] to the path of [g], translated by [pos]
+// and scaled by [scale], from font units to thousandths of text space units.
+func outlinePath(g pathSink, outline api.GlyphOutline, mat matrix.Transform, pos, scale fl) {
+	point := func(p api.SegmentPoint) (fl, fl) {
+		return mat.Apply((pos+p.X*scale)/1000, p.Y*scale/1000)
+	}
+	var currentX, currentY fl
+	for i, seg := range outline.Segments {
+		switch seg.Op {
+		case api.SegmentOpMoveTo:
+			if i != 0 {
+				g.ClosePath()
+			}
+			currentX, currentY = point(seg.Args[0])
+			g.MoveTo(currentX, currentY)
+		case api.SegmentOpLineTo:
+			currentX, currentY = point(seg.Args[0])
+			g.LineTo(currentX, currentY)
+		case api.SegmentOpQuadTo:
+			// convert to a cubic Bézier curve
+			x1, y1 := point(seg.Args[0])
+			x2, y2 := point(seg.Args[1])
+			g.CubicTo(currentX+2./3*(x1-currentX), currentY+2./3*(y1-currentY),
+				x2+2./3*(x1-x2), y2+2./3*(y1-y2), x2, y2)
+			currentX, currentY = x2, y2
+		case api.SegmentOpCubeTo:
+			x1, y1 := point(seg.Args[0])
+			x2, y2 := point(seg.Args[1])
+			currentX, currentY = point(seg.Args[2])
+			g.CubicTo(x1, y1, x2, y2, currentX, currentY)
+		}
+	}
+	if len(outline.Segments) != 0 {
+		g.ClosePath()
+	}
+}
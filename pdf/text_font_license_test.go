@@ -0,0 +1,214 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/text"
+	"github.com/go-text/typesetting/opentype/loader"
+)
+
+type testFont struct {
+	text.FontOrigin
+	desc backend.FontDescription
+}
+
+func newTestFont(family string) testFont {
+	return testFont{
+		FontOrigin: text.FontOrigin{File: family + ".ttf"},
+		desc:       backend.FontDescription{Family: family, Size: 12, IsOpentype: true},
+	}
+}
+
+func (f testFont) Origin() text.FontOrigin { return f.FontOrigin }
+
+func (f testFont) Description() backend.FontDescription { return f.desc }
+
+// withFsType returns a copy of [font] with the given license
+func withFsType(t *testing.T, font []byte, fsType uint16) []byte {
+	var tables []loader.Table
+	for _, tag := range mustLoader(t, font).Tables() {
+		content := append([]byte(nil), loadTables(t, font)[tag]...)
+		if tag == os2Tag {
+			binary.BigEndian.PutUint16(content[8:], fsType)
+		}
+		tables = append(tables, loader.Table{Tag: tag, Content: content})
+	}
+	return loader.WriteTTF(tables)
+}
+
+func TestFontEmbedding(t *testing.T) {
+	for _, test := range []struct {
+		fsType   uint16
+		policy   RestrictedFontPolicy
+		expected fontEmbedding
+	}{
+		{0, FailOnRestrictedFont, embedSubset},
+		{fsTypePreviewPrint, FailOnRestrictedFont, embedSubset},
+		{fsTypeEditable, FailOnRestrictedFont, embedSubset},
+		{fsTypeNoSubsetting, FailOnRestrictedFont, embedWhole},
+		{fsTypeEditable | fsTypeNoSubsetting, OutlineRestrictedFont, embedWhole},
+		{fsTypeRestricted, FailOnRestrictedFont, embedForbidden},
+		{fsTypeRestricted, SubstituteRestrictedFont, embedSubstitute},
+		{fsTypeRestricted, OutlineRestrictedFont, embedOutlines},
+		{fsTypeRestricted | fsTypePreviewPrint, FailOnRestrictedFont, embedSubset}, // least restrictive
+		{fsTypeBitmapOnly, FailOnRestrictedFont, embedForbidden},
+	} {
+		if got := (FontLicensing{Restricted: test.policy}).embedding(test.fsType); got != test.expected {
+			t.Fatalf("for fsType %x, expected %d, got %d", test.fsType, test.expected, got)
+		}
+	}
+}
+
+// renderText draws a glyph with the given font and returns the output
+func renderText(t *testing.T, output *Output, content []byte) (model.Document, error) {
+	font := newTestFont("Test")
+	page := output.AddPage(0, 0, 100, 100)
+	chars := page.AddFont(font, content)
+	chars.Cmap[36] = []rune{'A'}
+	chars.Extents[36] = backend.GlyphExtents{Width: 600}
+	page.DrawText([]backend.TextDrawing{{
+		Runs:     []backend.TextRun{{Font: font, Glyphs: []backend.TextGlyph{{Glyph: 36}, {Glyph: 36}}}},
+		FontSize: 12,
+		X:        10,
+		Y:        50,
+	}})
+	return output.Finalize()
+}
+
+func TestFontLicensing(t *testing.T) {
+	otf, err := os.ReadFile("../resources_test/weasyprint.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsType, err := readFsType(otf); err != nil || fsType != 0 {
+		t.Fatalf("unexpected fsType %d (%v)", fsType, err)
+	}
+	restricted := withFsType(t, otf, fsTypeRestricted)
+	if fsType, err := readFsType(restricted); err != nil || fsType != fsTypeRestricted {
+		t.Fatalf("unexpected fsType %d (%v)", fsType, err)
+	}
+
+	// default policy
+	if _, err = renderText(t, NewOutput(), restricted); err == nil {
+		t.Fatal("expected error for restricted font")
+	}
+
+	output := NewOutput()
+	output.Options.FontLicensing = FontLicensing{Restricted: SubstituteRestrictedFont, Substitute: []byte("invalid")}
+	if _, err = renderText(t, output, restricted); err == nil {
+		t.Fatal("expected error for invalid substitute font")
+	}
+
+	output = NewOutput()
+	output.Options.FontLicensing = FontLicensing{Restricted: SubstituteRestrictedFont, Substitute: otf}
+	doc, err := renderText(t, output, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	if len(page.Resources.Font) != 1 {
+		t.Fatal("expected one font")
+	}
+	for _, font := range page.Resources.Font {
+		cidFont := font.Subtype.(model.FontType0).DescendantFonts
+		if _, ok := cidFont.CIDToGIDMap.(model.CIDToGIDMapStream); !ok {
+			t.Fatal("expected a CIDToGIDMap for substitute font")
+		}
+		if fsType, _ := readFsType(cidFont.FontDescriptor.FontFile.Content); fsType != 0 {
+			t.Fatal("restricted font should not be embedded")
+		}
+	}
+
+	output = NewOutput()
+	output.Options.FontLicensing.Restricted = OutlineRestrictedFont
+	doc, err = renderText(t, output, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page = doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	if len(page.Resources.Font) != 0 {
+		t.Fatal("restricted font should not be embedded")
+	}
+	content, err := page.Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "Tf") || !strings.HasSuffix(strings.TrimSpace(string(content)), "f") {
+		t.Fatalf("expected glyph outlines, got %s", content)
+	}
+
+	// unreadable license: the font is not restricted
+	if _, err = renderText(t, NewOutput(), []byte("invalid")); err != nil {
+		t.Fatal(err)
+	}
+
+	// no subsetting
+	whole := withFsType(t, otf, fsTypeNoSubsetting)
+	doc, err = renderText(t, NewOutput(), whole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page = doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	for _, font := range page.Resources.Font {
+		fontFile := font.Subtype.(model.FontType0).DescendantFonts.FontDescriptor.FontFile
+		if !bytes.Equal(fontFile.Content, whole) {
+			t.Fatal("font should be embedded whole")
+		}
+	}
+}
+
+func TestTextOutlines(t *testing.T) {
+	otf, err := os.ReadFile("../resources_test/weasyprint.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restricted := withFsType(t, otf, fsTypeRestricted)
+
+	output := NewOutput()
+	output.Options.TextOutlines = true
+	doc, err := renderText(t, output, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	content, err := page.Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Resources.Font) != 0 || strings.Contains(string(content), "BT") {
+		t.Fatalf("expected glyph outlines only, got %s", content)
+	}
+
+	output = NewOutput()
+	output.Options.TextOutlines = true
+	output.Options.OutlinesTextLayer = true
+	doc, err = renderText(t, output, restricted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page = doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	content, err = page.Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "f\nBT") || !strings.Contains(string(content), "3 Tr") {
+		t.Fatalf("expected outlines and invisible text, got %s", content)
+	}
+	if len(page.Resources.Font) != 1 {
+		t.Fatal("expected one font")
+	}
+	for _, font := range page.Resources.Font {
+		if font.ToUnicode == nil {
+			t.Fatal("missing ToUnicode CMap")
+		}
+		if font.Subtype.(model.FontType0).DescendantFonts.FontDescriptor.FontFile != nil {
+			t.Fatal("font should not be embedded")
+		}
+	}
+}
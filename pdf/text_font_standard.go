@@ -0,0 +1,294 @@
+package pdf
+
+import (
+	"strings"
+	"sync"
+
+	pdfFonts "github.com/benoitkugler/pdf/fonts"
+	"github.com/benoitkugler/pdf/fonts/cmaps"
+	"github.com/benoitkugler/pdf/fonts/simpleencodings"
+	"github.com/benoitkugler/pdf/fonts/standardcmaps"
+	"github.com/benoitkugler/pdf/fonts/standardfonts"
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/text"
+)
+
+// fonts may be referenced by name, without embedding their file,
+// either as one of the standard 14 fonts, or as a predefined CJK font
+//
+// CJK fonts use the Identity-H encoding, so that the character codes
+// are the CIDs of the collection: the widths are then registered for the CIDs actually
+// displayed, which is not the case with the Uni*-UCS2-H CMaps, where several CIDs
+// may be used for a character, and whose mappings are not available here.
+// Since the characters may not be deduced from the encoding, a ToUnicode CMap is written.
+
+// CJKCollection is one of the Adobe character collections
+// used to reference CJK fonts without embedding.
+type CJKCollection string
+
+const (
+	Japan1 CJKCollection = "Japan1"
+	GB1    CJKCollection = "GB1"
+	CNS1   CJKCollection = "CNS1"
+	Korea1 CJKCollection = "Korea1"
+)
+
+// cjkFont stores the predefined resources used for a collection
+type cjkFont struct {
+	baseFont   model.ObjName // a font available in PDF readers
+	supplement int
+}
+
+var cjkFonts = map[CJKCollection]cjkFont{
+	Japan1: {baseFont: "HeiseiMin-W3", supplement: 2},
+	GB1:    {baseFont: "STSong-Light", supplement: 4},
+	CNS1:   {baseFont: "MSung-Light", supplement: 4},
+	Korea1: {baseFont: "HYSMyeongJo-Medium", supplement: 1},
+}
+
+var (
+	cjkCIDsLock sync.Mutex
+	cjkCIDs     = map[CJKCollection]map[rune]model.CID{}
+)
+
+// collectionCIDs returns the CIDs of the BMP characters
+// supported by [collection], using the lowest CID for duplicates.
+func collectionCIDs(collection CJKCollection) map[rune]model.CID {
+	cjkCIDsLock.Lock()
+	defer cjkCIDsLock.Unlock()
+
+	if out := cjkCIDs[collection]; out != nil {
+		return out
+	}
+	toUnicode := standardcmaps.ToUnicodeCMaps[model.ObjName("Adobe-"+collection+"-UCS2")]
+	out := make(map[rune]model.CID)
+	for cid, runes := range toUnicode.ProperLookupTable() {
+		if cid == 0 || len(runes) != 1 || runes[0] > 0xFFFF {
+			continue
+		}
+		if other, has := out[runes[0]]; !has || cid < other {
+			out[runes[0]] = cid
+		}
+	}
+	cjkCIDs[collection] = out
+	return out
+}
+
+// families metric-compatible with the standard fonts,
+// normalized by [normalizeFamily]
+var standardFamilies = map[string]string{
+	"helvetica": "Helvetica", "arial": "Helvetica", "liberationsans": "Helvetica",
+	"arimo": "Helvetica", "nimbussans": "Helvetica", "nimbussansl": "Helvetica",
+	"freesans": "Helvetica", "texgyreheros": "Helvetica",
+
+	"times": "Times", "timesnewroman": "Times", "liberationserif": "Times",
+	"tinos": "Times", "nimbusroman": "Times", "nimbusromanno9l": "Times",
+	"freeserif": "Times", "texgyretermes": "Times",
+
+	"courier": "Courier", "couriernew": "Courier", "liberationmono": "Courier",
+	"cousine": "Courier", "nimbusmono": "Courier", "nimbusmonops": "Courier",
+	"freemono": "Courier", "texgyrecursor": "Courier",
+}
+
+func normalizeFamily(family string) string {
+	return strings.ToLower(strings.ReplaceAll(family, " ", ""))
+}
+
+// standardFontName returns the name of the standard font
+// equivalent to [desc], or an empty string.
+func standardFontName(desc backend.FontDescription) string {
+	family := standardFamilies[normalizeFamily(desc.Family)]
+	if family == "" {
+		return ""
+	}
+	bold := desc.Weight >= 600
+	italic := desc.Style != text.FSyNormal
+	switch {
+	case family == "Times" && bold && italic:
+		return "Times-BoldItalic"
+	case family == "Times" && bold:
+		return "Times-Bold"
+	case family == "Times" && italic:
+		return "Times-Italic"
+	case family == "Times":
+		return "Times-Roman"
+	case bold && italic:
+		return family + "-BoldOblique"
+	case bold:
+		return family + "-Bold"
+	case italic:
+		return family + "-Oblique"
+	default:
+		return family
+	}
+}
+
+var (
+	winAnsiOnce  sync.Once
+	winAnsiCodes map[rune]byte
+)
+
+func winAnsiEncoding() map[rune]byte {
+	winAnsiOnce.Do(func() { winAnsiCodes = simpleencodings.WinAnsi.RuneToByte() })
+	return winAnsiCodes
+}
+
+// referencedFont is a font referenced by name, without embedding
+type referencedFont struct {
+	dict *model.FontDict
+
+	// codes maps the supported characters to character codes,
+	// which are the CIDs for CJK fonts
+	codes map[rune]uint16
+	// cids is only used for CJK fonts, and maps the
+	// supported characters to CIDs
+	cids map[rune]model.CID
+	// widths used by the PDF reader, indexed by character code
+	// (for standard fonts) or by CID (for CJK fonts, where
+	// they are registered when drawing)
+	widths map[uint16]int
+	// characters used, indexed by CID, only for CJK fonts
+	used map[uint32][]rune
+
+	// embedded is true if some text was not supported,
+	// so that the embedded font is also required
+	embedded bool
+}
+
+// newReferencedFont returns the non embedded version of [font],
+// or nil if not enabled in [options] or not supported.
+func newReferencedFont(font backend.Font, options *Options) *referencedFont {
+	desc := font.Description()
+	if options.StandardFonts {
+		if name := standardFontName(desc); name != "" {
+			return newStandardFont(name)
+		}
+	}
+	for family, collection := range options.CJKFonts {
+		if normalizeFamily(family) == normalizeFamily(desc.Family) {
+			return newCJKFont(collection, desc)
+		}
+	}
+	return nil
+}
+
+func newStandardFont(name string) *referencedFont {
+	metrics := standardfonts.Fonts[name]
+	ft := metrics.WesternType1Font()
+	out := &referencedFont{
+		dict:   &model.FontDict{Subtype: ft},
+		codes:  make(map[rune]uint16),
+		widths: make(map[uint16]int),
+	}
+	for r, code := range winAnsiEncoding() {
+		out.codes[r] = uint16(code)
+	}
+	for i, w := range ft.Widths {
+		out.widths[uint16(ft.FirstChar)+uint16(i)] = w
+	}
+	return out
+}
+
+func newCJKFont(collection CJKCollection, desc backend.FontDescription) *referencedFont {
+	predefined, ok := cjkFonts[collection]
+	if !ok {
+		return nil
+	}
+	out := &referencedFont{
+		codes:  make(map[rune]uint16),
+		cids:   collectionCIDs(collection),
+		widths: make(map[uint16]int),
+		used:   make(map[uint32][]rune),
+	}
+	for r, cid := range out.cids {
+		out.codes[r] = uint16(cid) // Identity-H
+	}
+	flags := model.Nonsymbolic
+	if desc.Style != text.FSyNormal {
+		flags |= model.Italic
+	}
+	out.dict = &model.FontDict{Subtype: model.FontType0{
+		BaseFont: predefined.baseFont,
+		Encoding: model.CMapEncodingPredefined("Identity-H"),
+		DescendantFonts: model.CIDFontDictionary{
+			Subtype:  "CIDFontType0",
+			BaseFont: predefined.baseFont,
+			CIDSystemInfo: model.CIDSystemInfo{
+				Registry:   "Adobe",
+				Ordering:   string(collection),
+				Supplement: predefined.supplement,
+			},
+			FontDescriptor: model.FontDescriptor{
+				FontName: predefined.baseFont,
+				Flags:    flags,
+				FontBBox: model.Rectangle{Llx: 0, Lly: fl(desc.Descent), Urx: 1000, Ury: fl(desc.Ascent)},
+				Ascent:   desc.Ascent,
+				Descent:  desc.Descent,
+				StemV:    80,
+			},
+			DW: 1000,
+		},
+	}}
+	return out
+}
+
+// encode returns the character codes for the glyphs of [run], with
+// the adjustments required to respect the layout widths,
+// or false if some glyphs are not supported.
+func (rf *referencedFont) encode(run backend.TextRun, chars *backend.FontChars) ([]pdfFonts.TextSpaced, bool) {
+	var out []pdfFonts.TextSpaced
+	for _, glyph := range run.Glyphs {
+		runes := chars.Cmap[glyph.Glyph]
+		if len(runes) != 1 {
+			return nil, false
+		}
+		code, ok := rf.codes[runes[0]]
+		if !ok {
+			return nil, false
+		}
+		layoutWidth := chars.Extents[glyph.Glyph].Width
+		width, has := rf.widths[code]
+		if !has && rf.cids != nil {
+			// register the layout width
+			width = layoutWidth
+			rf.widths[code] = width
+			rf.used[uint32(code)] = runes
+		}
+
+		if glyph.Offset != 0 {
+			out = append(out, pdfFonts.TextSpaced{SpaceSubtractedAfter: -int(glyph.Offset)})
+		}
+		var bytes []byte
+		if rf.cids != nil {
+			bytes = []byte{byte(code >> 8), byte(code)}
+		} else {
+			bytes = []byte{byte(code)}
+		}
+		// merge with the previous chunk if possible
+		if L := len(out); L != 0 && out[L-1].SpaceSubtractedAfter == 0 {
+			out[L-1].CharCodes = append(out[L-1].CharCodes, bytes...)
+		} else {
+			out = append(out, pdfFonts.TextSpaced{CharCodes: bytes})
+		}
+		out[len(out)-1].SpaceSubtractedAfter = glyph.Kerning + width - layoutWidth
+	}
+	return out, true
+}
+
+// writeWidths updates the font dictionary of CJK fonts
+// with the widths and the characters used.
+func (rf *referencedFont) writeWidths() {
+	ft, ok := rf.dict.Subtype.(model.FontType0)
+	if !ok {
+		return
+	}
+	extents := make(map[backend.GID]backend.GlyphExtents, len(rf.widths))
+	for cid, w := range rf.widths {
+		extents[backend.GID(cid)] = backend.GlyphExtents{Width: w}
+	}
+	ft.DescendantFonts.W = cidWidths(extents)
+	rf.dict.Subtype = ft
+	cmap := cmaps.WriteAdobeIdentityUnicodeCMap(rf.used)
+	rf.dict.ToUnicode = &model.UnicodeCMap{Stream: model.Stream{Content: cmap}}
+}
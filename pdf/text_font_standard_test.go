@@ -0,0 +1,144 @@
+package pdf
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+)
+
+func TestStandardFontName(t *testing.T) {
+	for _, test := range []struct {
+		family   string
+		weight   int
+		italic   bool
+		expected string
+	}{
+		{"Arial", 400, false, "Helvetica"},
+		{"Liberation Sans", 700, true, "Helvetica-BoldOblique"},
+		{"Times New Roman", 400, true, "Times-Italic"},
+		{"Tinos", 400, false, "Times-Roman"},
+		{"Courier New", 700, false, "Courier-Bold"},
+		{"DejaVu Sans", 400, false, ""},
+	} {
+		desc := backend.FontDescription{Family: test.family, Weight: test.weight}
+		if test.italic {
+			desc.Style = 2
+		}
+		if got := standardFontName(desc); got != test.expected {
+			t.Fatalf("for %s, expected %s, got %s", test.family, test.expected, got)
+		}
+	}
+}
+
+// drawRuns draws one text per run, using the given glyphs (mapped to runes)
+func drawRuns(t *testing.T, output *Output, font backend.Font, cmap map[backend.GID]rune, runs ...[]backend.GID) *model.PageObject {
+	otf, err := os.ReadFile("../resources_test/weasyprint.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := output.AddPage(0, 0, 100, 100)
+	chars := page.AddFont(font, otf)
+	for gid, r := range cmap {
+		chars.Cmap[gid] = []rune{r}
+		chars.Extents[gid] = backend.GlyphExtents{Width: 1000}
+	}
+	for _, run := range runs {
+		var glyphs []backend.TextGlyph
+		for _, gid := range run {
+			glyphs = append(glyphs, backend.TextGlyph{Glyph: gid})
+		}
+		page.DrawText([]backend.TextDrawing{{
+			Runs:     []backend.TextRun{{Font: font, Glyphs: glyphs}},
+			FontSize: 12,
+		}})
+	}
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc.Catalog.Pages.Kids[0].(*model.PageObject)
+}
+
+func TestStandardFonts(t *testing.T) {
+	cmap := map[backend.GID]rune{36: 'A', 37: '€', 38: '中'}
+
+	output := NewOutput()
+	output.Options.StandardFonts = true
+	page := drawRuns(t, output, newTestFont("Arial"), cmap, []backend.GID{36, 37})
+	if len(page.Resources.Font) != 1 {
+		t.Fatalf("expected one font, got %d", len(page.Resources.Font))
+	}
+	for _, font := range page.Resources.Font {
+		ft, ok := font.Subtype.(model.FontType1)
+		if !ok || ft.BaseFont != "Helvetica" || ft.Encoding != model.WinAnsiEncoding || ft.FontDescriptor.FontFile != nil {
+			t.Fatalf("unexpected font %v", font.Subtype)
+		}
+	}
+	content, err := page.Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the Helvetica widths (667 and 556) are adjusted to the layout ones
+	if !strings.Contains(string(content), "(A)-333(\x80)-444]TJ") {
+		t.Fatalf("unexpected content %s", content)
+	}
+
+	// text not supported by WinAnsi uses the embedded font
+	output = NewOutput()
+	output.Options.StandardFonts = true
+	page = drawRuns(t, output, newTestFont("Arial"), cmap, []backend.GID{36}, []backend.GID{36, 38})
+	if len(page.Resources.Font) != 2 {
+		t.Fatalf("expected two fonts, got %d", len(page.Resources.Font))
+	}
+}
+
+func TestCJKFonts(t *testing.T) {
+	cmap := map[backend.GID]rune{36: 'A', 38: '中', 39: '\U0001F600'}
+
+	output := NewOutput()
+	output.Options.CJKFonts = map[string]CJKCollection{"Noto Sans CJK JP": Japan1}
+	page := drawRuns(t, output, newTestFont("Noto Sans CJK JP"), cmap, []backend.GID{38, 36})
+	if len(page.Resources.Font) != 1 {
+		t.Fatalf("expected one font, got %d", len(page.Resources.Font))
+	}
+	cids := collectionCIDs(Japan1)
+	for _, font := range page.Resources.Font {
+		ft, ok := font.Subtype.(model.FontType0)
+		if !ok || ft.Encoding != model.CMapEncodingPredefined("Identity-H") || font.ToUnicode == nil {
+			t.Fatalf("unexpected font %v", font.Subtype)
+		}
+		cidFont := ft.DescendantFonts
+		if cidFont.CIDSystemInfo.Ordering != "Japan1" || cidFont.FontDescriptor.FontFile != nil {
+			t.Fatalf("unexpected font %v", cidFont)
+		}
+		widths := cidFont.Widths()
+		if widths[cids['中']] != 1000 || widths[cids['A']] != 1000 {
+			t.Fatalf("unexpected widths %v", widths)
+		}
+	}
+	content, err := page.Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the codes are the CIDs
+	codes := string([]byte{byte(cids['中'] >> 8), byte(cids['中']), byte(cids['A'] >> 8), byte(cids['A'])})
+	if !strings.Contains(string(content), codes) {
+		t.Fatalf("unexpected content %q", content)
+	}
+
+	// characters outside the BMP use the embedded font
+	output = NewOutput()
+	output.Options.CJKFonts = map[string]CJKCollection{"Noto Sans CJK JP": Japan1}
+	page = drawRuns(t, output, newTestFont("Noto Sans CJK JP"), cmap, []backend.GID{38, 39})
+	if len(page.Resources.Font) != 1 {
+		t.Fatalf("expected one font, got %d", len(page.Resources.Font))
+	}
+	for _, font := range page.Resources.Font {
+		if ft, ok := font.Subtype.(model.FontType0); !ok || ft.DescendantFonts.FontDescriptor.FontFile == nil {
+			t.Fatal("expected an embedded font")
+		}
+	}
+}
@@ -0,0 +1,627 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-text/typesetting/opentype/loader"
+)
+
+// web fonts (WOFF and WOFF2) must be converted to plain
+// sfnt files before being embedded
+
+var (
+	woffTag  = loader.MustNewTag("wOFF")
+	woff2Tag = loader.MustNewTag("wOF2")
+
+	hheaTag = loader.MustNewTag("hhea")
+	hmtxTag = loader.MustNewTag("hmtx")
+)
+
+// sfntFace decodes web fonts and extracts faces from collections,
+// returning a plain font file, which may be subsetted and embedded.
+// Other font files are returned unchanged.
+func sfntFace(content []byte, index uint16) ([]byte, error) {
+	if len(content) < 4 {
+		return content, nil
+	}
+	switch loader.Tag(binary.BigEndian.Uint32(content)) {
+	case woffTag:
+		ld, err := loader.NewLoader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("invalid WOFF font: %s", err)
+		}
+		return writeFace(ld)
+	case woff2Tag:
+		out, err := decodeWOFF2(content, index)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WOFF2 font: %s", err)
+		}
+		return out, nil
+	default:
+		return extractFace(content, index)
+	}
+}
+
+// See https://www.w3.org/TR/WOFF2/
+
+const woff2HeaderSize = 48
+
+var woff2KnownTags = [63]string{
+	"cmap", "head", "hhea", "hmtx", "maxp", "name", "OS/2", "post", "cvt ",
+	"fpgm", "glyf", "loca", "prep", "CFF ", "VORG", "EBDT", "EBLC", "gasp",
+	"hdmx", "kern", "LTSH", "PCLT", "VDMX", "vhea", "vmtx", "BASE", "GDEF",
+	"GPOS", "GSUB", "EBSC", "JSTF", "MATH", "CBDT", "CBLC", "COLR", "CPAL",
+	"SVG ", "sbix", "acnt", "avar", "bdat", "bloc", "bsln", "cvar", "fdsc",
+	"feat", "fmtx", "fvar", "gvar", "hsty", "just", "lcar", "mort", "morx",
+	"opbd", "prop", "trak", "Zapf", "Silf", "Glat", "Gloc", "Feat", "Sill",
+}
+
+type woff2Table struct {
+	tag             loader.Tag
+	transformed     bool
+	origLength      uint32
+	transformLength uint32
+	data            []byte // slice of the decompressed stream
+}
+
+// woff2Reader reads the various data types found in WOFF2 files
+type woff2Reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *woff2Reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	out := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return out
+}
+
+func (r *woff2Reader) u8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *woff2Reader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *woff2Reader) u32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *woff2Reader) uintBase128() uint32 {
+	var accum uint32
+	for i := 0; i < 5; i++ {
+		b := r.u8()
+		if r.err != nil {
+			return 0
+		}
+		if i == 0 && b == 0x80 { // leading zeros are not allowed
+			r.err = errors.New("invalid UIntBase128 value")
+			return 0
+		}
+		if accum&0xFE000000 != 0 { // overflow
+			r.err = errors.New("invalid UIntBase128 value")
+			return 0
+		}
+		accum = (accum << 7) | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			return accum
+		}
+	}
+	r.err = errors.New("invalid UIntBase128 value")
+	return 0
+}
+
+func (r *woff2Reader) u255() uint16 {
+	const (
+		oneMoreByteCode1 = 255
+		oneMoreByteCode2 = 254
+		wordCode         = 253
+		lowestUCode      = 253
+	)
+	switch code := r.u8(); code {
+	case wordCode:
+		return r.u16()
+	case oneMoreByteCode1:
+		return uint16(r.u8()) + lowestUCode
+	case oneMoreByteCode2:
+		return uint16(r.u8()) + lowestUCode*2
+	default:
+		return uint16(code)
+	}
+}
+
+// decodeWOFF2 returns the face at [index] (always 0 for single fonts)
+// as a plain font file.
+func decodeWOFF2(content []byte, index uint16) ([]byte, error) {
+	r := woff2Reader{data: content}
+	header := r.bytes(woff2HeaderSize)
+	if r.err != nil {
+		return nil, r.err
+	}
+	flavor := loader.Tag(binary.BigEndian.Uint32(header[4:]))
+	numTables := int(binary.BigEndian.Uint16(header[12:]))
+	totalCompressedSize := int(binary.BigEndian.Uint32(header[20:]))
+
+	tables := make([]woff2Table, numTables)
+	var uncompressedSize uint32
+	for i := range tables {
+		table := &tables[i]
+		flags := r.u8()
+		if tagIndex := flags & 0x3F; tagIndex == 63 {
+			table.tag = loader.Tag(r.u32())
+		} else {
+			table.tag = loader.MustNewTag(woff2KnownTags[tagIndex])
+		}
+		transformVersion := flags >> 6
+		table.origLength = r.uintBase128()
+		if table.tag == glyfTag || table.tag == locaTag {
+			table.transformed = transformVersion == 0
+		} else {
+			table.transformed = transformVersion != 0
+		}
+		table.transformLength = table.origLength
+		if table.transformed {
+			table.transformLength = r.uintBase128()
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		uncompressedSize += table.transformLength
+	}
+
+	// the faces of the file, as table indices
+	faces := [][]int{make([]int, numTables)}
+	if flavor == ttcTag {
+		r.u32() // version
+		numFonts := int(r.u255())
+		faces = make([][]int, numFonts)
+		for i := range faces {
+			faceTables := make([]int, r.u255())
+			r.u32() // flavor
+			for j := range faceTables {
+				faceTables[j] = int(r.u255())
+				if faceTables[j] >= numTables {
+					return nil, fmt.Errorf("invalid table index %d", faceTables[j])
+				}
+			}
+			faces[i] = faceTables
+		}
+	} else {
+		for i := range faces[0] {
+			faces[0][i] = i
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if int(index) >= len(faces) {
+		return nil, fmt.Errorf("invalid face index %d for a collection of %d fonts", index, len(faces))
+	}
+
+	compressed := r.bytes(totalCompressedSize)
+	if r.err != nil {
+		return nil, r.err
+	}
+	stream, err := io.ReadAll(io.LimitReader(brotli.NewReader(bytes.NewReader(compressed)), int64(uncompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(stream) != int(uncompressedSize) {
+		return nil, errors.New("invalid size for the compressed stream")
+	}
+	var offset uint32
+	for i := range tables {
+		end := offset + tables[i].transformLength
+		// limit the capacity: the checksum computed by [loader.WriteTTF]
+		// pads the table content using append, which would otherwise
+		// overwrite the next table
+		tables[i].data = stream[offset:end:end]
+		offset = end
+	}
+
+	face := make(map[loader.Tag]*woff2Table)
+	for _, tableIndex := range faces[index] {
+		face[tables[tableIndex].tag] = &tables[tableIndex]
+	}
+	return reconstructFace(face)
+}
+
+// reconstructFace applies the reverse transforms and writes the font file
+func reconstructFace(face map[loader.Tag]*woff2Table) ([]byte, error) {
+	out := make([]loader.Table, 0, len(face))
+
+	var xMins []int16 // needed to reconstruct 'hmtx'
+	if glyf := face[glyfTag]; glyf != nil && glyf.transformed {
+		if face[locaTag] == nil {
+			return nil, errors.New("missing 'loca' table")
+		}
+		glyfData, locaData, mins, err := reconstructGlyf(glyf.data)
+		if err != nil {
+			return nil, err
+		}
+		xMins = mins
+		out = append(out, loader.Table{Tag: glyfTag, Content: glyfData}, loader.Table{Tag: locaTag, Content: locaData})
+	}
+
+	for tag, table := range face {
+		if table.transformed && (tag == glyfTag || tag == locaTag) {
+			continue // already handled
+		}
+		content := table.data
+		if table.transformed {
+			if tag != hmtxTag {
+				return nil, fmt.Errorf("unsupported transform for table %s", tag)
+			}
+			var err error
+			content, err = reconstructHmtx(table.data, face, xMins)
+			if err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, loader.Table{Tag: tag, Content: content})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Tag < out[j].Tag })
+
+	font := loader.WriteTTF(out)
+	if _, isCFF := face[loader.MustNewTag("CFF ")]; isCFF {
+		binary.BigEndian.PutUint32(font, uint32(loader.OpenType))
+	}
+	return font, nil
+}
+
+// simple glyph flags
+const (
+	glyfOnCurve       = 0x01
+	glyfXShort        = 0x02
+	glyfYShort        = 0x04
+	glyfXSameOrPos    = 0x10
+	glyfYSameOrPos    = 0x20
+	glyfOverlapSimple = 0x40
+)
+
+// composite glyph flags
+const (
+	glyfArgsAreWords    = 0x0001
+	glyfHaveScale       = 0x0008
+	glyfMoreComponents  = 0x0020
+	glyfHaveXYScale     = 0x0040
+	glyfHaveTwoByTwo    = 0x0080
+	glyfHaveInstruction = 0x0100
+)
+
+func withSign(flag uint8, value int) int {
+	if flag&1 != 0 {
+		return value
+	}
+	return -value
+}
+
+// decodeTriplet returns the delta encoded by [flag] and the
+// data read from [glyphs]
+func decodeTriplet(flag uint8, glyphs *woff2Reader) (dx, dy int) {
+	switch {
+	case flag < 10:
+		b := int(glyphs.u8())
+		dy = withSign(flag, int(flag&14)<<7+b)
+	case flag < 20:
+		b := int(glyphs.u8())
+		dx = withSign(flag, int((flag-10)&14)<<7+b)
+	case flag < 84:
+		b0, b1 := int(flag-20), int(glyphs.u8())
+		dx = withSign(flag, 1+(b0&0x30)+(b1>>4))
+		dy = withSign(flag>>1, 1+((b0&0x0c)<<2)+(b1&0x0f))
+	case flag < 120:
+		b0 := int(flag - 84)
+		in := glyphs.bytes(2)
+		if in == nil {
+			return
+		}
+		dx = withSign(flag, 1+((b0/12)<<8)+int(in[0]))
+		dy = withSign(flag>>1, 1+(((b0%12)>>2)<<8)+int(in[1]))
+	case flag < 124:
+		in := glyphs.bytes(3)
+		if in == nil {
+			return
+		}
+		dx = withSign(flag, int(in[0])<<4+int(in[1])>>4)
+		dy = withSign(flag>>1, int(in[1]&0x0f)<<8+int(in[2]))
+	default:
+		in := glyphs.bytes(4)
+		if in == nil {
+			return
+		}
+		dx = withSign(flag, int(in[0])<<8+int(in[1]))
+		dy = withSign(flag>>1, int(in[2])<<8+int(in[3]))
+	}
+	return dx, dy
+}
+
+// reconstructGlyf returns the 'glyf' and 'loca' tables, and the xMin of each glyph
+func reconstructGlyf(data []byte) (glyf, loca []byte, xMins []int16, err error) {
+	header := woff2Reader{data: data}
+	header.u16() // reserved
+	optionFlags := header.u16()
+	numGlyphs := int(header.u16())
+	indexFormat := header.u16()
+	var sizes [7]uint32
+	for i := range sizes {
+		sizes[i] = header.u32()
+	}
+	var streams [7]woff2Reader
+	for i, size := range sizes {
+		streams[i] = woff2Reader{data: header.bytes(int(size))}
+	}
+	var overlapBitmap []byte
+	if optionFlags&1 != 0 {
+		overlapBitmap = header.bytes((numGlyphs + 7) / 8)
+	}
+	if header.err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid transformed 'glyf' table: %s", header.err)
+	}
+	nContours, nPoints, flags, glyphs, composites, bboxes, instructions := &streams[0], &streams[1], &streams[2], &streams[3], &streams[4], &streams[5], &streams[6]
+
+	bboxBitmap := bboxes.bytes(((numGlyphs + 31) >> 5) << 2)
+	if bboxes.err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid transformed 'glyf' table: %s", bboxes.err)
+	}
+	hasBbox := func(gid int) bool { return bboxBitmap[gid>>3]&(0x80>>(gid&7)) != 0 }
+
+	var (
+		out     bytes.Buffer
+		offsets = make([]uint32, numGlyphs+1)
+	)
+	xMins = make([]int16, numGlyphs)
+	for gid := 0; gid < numGlyphs; gid++ {
+		offsets[gid] = uint32(out.Len())
+		n := int16(nContours.u16())
+		switch {
+		case n == 0: // empty glyph
+			if hasBbox(gid) {
+				return nil, nil, nil, fmt.Errorf("invalid bounding box for empty glyph %d", gid)
+			}
+		case n < 0: // composite glyph
+			if !hasBbox(gid) {
+				return nil, nil, nil, fmt.Errorf("missing bounding box for composite glyph %d", gid)
+			}
+			bbox := bboxes.bytes(8)
+			if bboxes.err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid bounding box for composite glyph %d: %s", gid, bboxes.err)
+			}
+			start := composites.pos
+			var haveInstructions bool
+			for more := true; more; {
+				flag := composites.u16()
+				more = flag&glyfMoreComponents != 0
+				haveInstructions = haveInstructions || flag&glyfHaveInstruction != 0
+				argSize := 2
+				if flag&glyfArgsAreWords != 0 {
+					argSize = 4
+				}
+				switch {
+				case flag&glyfHaveScale != 0:
+					argSize += 2
+				case flag&glyfHaveXYScale != 0:
+					argSize += 4
+				case flag&glyfHaveTwoByTwo != 0:
+					argSize += 8
+				}
+				composites.bytes(2 + argSize) // glyph index and arguments
+				if composites.err != nil {
+					return nil, nil, nil, fmt.Errorf("invalid composite glyph %d: %s", gid, composites.err)
+				}
+			}
+			binary.Write(&out, binary.BigEndian, n)
+			out.Write(bbox)
+			out.Write(composites.data[start:composites.pos])
+			if haveInstructions {
+				instructionLength := glyphs.u255()
+				binary.Write(&out, binary.BigEndian, instructionLength)
+				out.Write(instructions.bytes(int(instructionLength)))
+			}
+			xMins[gid] = int16(binary.BigEndian.Uint16(bbox))
+		default: // simple glyph
+			endPoints := make([]uint16, n)
+			var totalPoints int
+			for i := range endPoints {
+				totalPoints += int(nPoints.u255())
+				endPoints[i] = uint16(totalPoints - 1)
+			}
+			var (
+				xs, ys     = make([]int, totalPoints), make([]int, totalPoints)
+				onCurves   = make([]bool, totalPoints)
+				x, y       int
+				pointFlags = flags.bytes(totalPoints)
+			)
+			if flags.err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid simple glyph %d: %s", gid, flags.err)
+			}
+			for i, flag := range pointFlags {
+				onCurves[i] = flag>>7 == 0
+				dx, dy := decodeTriplet(flag&0x7F, glyphs)
+				x += dx
+				y += dy
+				xs[i], ys[i] = x, y
+			}
+			instructionLength := glyphs.u255()
+			instructionData := instructions.bytes(int(instructionLength))
+
+			var bbox [4]int16
+			if hasBbox(gid) {
+				for i := range bbox {
+					bbox[i] = int16(bboxes.u16())
+				}
+			} else if totalPoints != 0 {
+				bbox = [4]int16{int16(xs[0]), int16(ys[0]), int16(xs[0]), int16(ys[0])}
+				for i := range xs {
+					bbox[0], bbox[2] = min16(bbox[0], int16(xs[i])), max16(bbox[2], int16(xs[i]))
+					bbox[1], bbox[3] = min16(bbox[1], int16(ys[i])), max16(bbox[3], int16(ys[i]))
+				}
+			}
+			xMins[gid] = bbox[0]
+
+			overlap := overlapBitmap != nil && overlapBitmap[gid>>3]&(0x80>>(gid&7)) != 0
+
+			binary.Write(&out, binary.BigEndian, n)
+			binary.Write(&out, binary.BigEndian, bbox)
+			binary.Write(&out, binary.BigEndian, endPoints)
+			binary.Write(&out, binary.BigEndian, instructionLength)
+			out.Write(instructionData)
+			writeSimpleGlyphPoints(&out, xs, ys, onCurves, overlap)
+		}
+
+		for _, stream := range streams {
+			if stream.err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid glyph %d: %s", gid, stream.err)
+			}
+		}
+
+		// pad to 4 bytes, so that short offsets are valid
+		for out.Len()%4 != 0 {
+			out.WriteByte(0)
+		}
+	}
+	offsets[numGlyphs] = uint32(out.Len())
+
+	return out.Bytes(), writeLoca(offsets, indexFormat == 1), xMins, nil
+}
+
+// writeSimpleGlyphPoints encodes the flags and the coordinates of a simple glyph
+func writeSimpleGlyphPoints(out *bytes.Buffer, xs, ys []int, onCurves []bool, overlap bool) {
+	var (
+		flags            = make([]byte, len(xs))
+		xCoords, yCoords []byte
+		lastX, lastY     int
+	)
+	for i := range xs {
+		var flag byte
+		if onCurves[i] {
+			flag = glyfOnCurve
+		}
+		if i == 0 && overlap {
+			flag |= glyfOverlapSimple
+		}
+
+		dx := xs[i] - lastX
+		if dx == 0 {
+			flag |= glyfXSameOrPos
+		} else if -256 < dx && dx < 256 {
+			flag |= glyfXShort
+			if dx > 0 {
+				flag |= glyfXSameOrPos
+			} else {
+				dx = -dx
+			}
+			xCoords = append(xCoords, byte(dx))
+		} else {
+			xCoords = append(xCoords, byte(dx>>8), byte(dx))
+		}
+
+		dy := ys[i] - lastY
+		if dy == 0 {
+			flag |= glyfYSameOrPos
+		} else if -256 < dy && dy < 256 {
+			flag |= glyfYShort
+			if dy > 0 {
+				flag |= glyfYSameOrPos
+			} else {
+				dy = -dy
+			}
+			yCoords = append(yCoords, byte(dy))
+		} else {
+			yCoords = append(yCoords, byte(dy>>8), byte(dy))
+		}
+
+		flags[i] = flag
+		lastX, lastY = xs[i], ys[i]
+	}
+	out.Write(flags)
+	out.Write(xCoords)
+	out.Write(yCoords)
+}
+
+// reconstructHmtx applies the reverse transform of the 'hmtx' table,
+// using the left side bearings of the glyphs, given by [xMins]
+func reconstructHmtx(data []byte, face map[loader.Tag]*woff2Table, xMins []int16) ([]byte, error) {
+	hhea := face[hheaTag]
+	if hhea == nil || len(hhea.data) < 36 {
+		return nil, errors.New("missing or invalid 'hhea' table")
+	}
+	if xMins == nil {
+		return nil, errors.New("transformed 'hmtx' requires a transformed 'glyf' table")
+	}
+	numHMetrics := int(binary.BigEndian.Uint16(hhea.data[34:]))
+	numGlyphs := len(xMins)
+	if numHMetrics < 1 || numHMetrics > numGlyphs {
+		return nil, errors.New("invalid number of horizontal metrics")
+	}
+
+	r := woff2Reader{data: data}
+	flags := r.u8()
+	advances := make([]uint16, numHMetrics)
+	for i := range advances {
+		advances[i] = r.u16()
+	}
+	lsbs := append([]int16(nil), xMins...)
+	if flags&1 == 0 { // proportional lsb are present
+		for i := 0; i < numHMetrics; i++ {
+			lsbs[i] = int16(r.u16())
+		}
+	}
+	if flags&2 == 0 { // monospaced lsb are present
+		for i := numHMetrics; i < numGlyphs; i++ {
+			lsbs[i] = int16(r.u16())
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid transformed 'hmtx' table: %s", r.err)
+	}
+
+	out := make([]byte, 2*numHMetrics+2*numGlyphs)
+	for i, advance := range advances {
+		binary.BigEndian.PutUint16(out[4*i:], advance)
+		binary.BigEndian.PutUint16(out[4*i+2:], uint16(lsbs[i]))
+	}
+	for i := numHMetrics; i < numGlyphs; i++ {
+		binary.BigEndian.PutUint16(out[2*numHMetrics+2*i:], uint16(lsbs[i]))
+	}
+	return out, nil
+}
+
+func min16(a, b int16) int16 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max16(a, b int16) int16 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,355 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	font_ "github.com/go-text/typesetting/opentype/api/font"
+	"github.com/go-text/typesetting/opentype/loader"
+	"github.com/go-text/typesetting/opentype/tables"
+)
+
+// encodeWOFF compresses each table of [font]
+func encodeWOFF(t *testing.T, font []byte) []byte {
+	ld := mustLoader(t, font)
+	tags := ld.Tables()
+
+	var data bytes.Buffer
+	directory := make([]byte, 20*len(tags))
+	offset := 44 + len(directory)
+	for i, tag := range tags {
+		table, err := ld.RawTable(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var compressed bytes.Buffer
+		w := zlib.NewWriter(&compressed)
+		w.Write(table)
+		w.Close()
+		// as required by the specification, tables not shrinking are stored uncompressed
+		if compressed.Len() >= len(table) {
+			compressed.Reset()
+			compressed.Write(table)
+		}
+
+		entry := directory[20*i:]
+		binary.BigEndian.PutUint32(entry, uint32(tag))
+		binary.BigEndian.PutUint32(entry[4:], uint32(offset+data.Len()))
+		binary.BigEndian.PutUint32(entry[8:], uint32(compressed.Len()))
+		binary.BigEndian.PutUint32(entry[12:], uint32(len(table)))
+		data.Write(compressed.Bytes())
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+
+	header := make([]byte, 44)
+	copy(header, "wOFF")
+	binary.BigEndian.PutUint32(header[4:], uint32(loader.TrueType))
+	binary.BigEndian.PutUint32(header[8:], uint32(offset+data.Len()))
+	binary.BigEndian.PutUint16(header[12:], uint16(len(tags)))
+	return append(append(header, directory...), data.Bytes()...)
+}
+
+func appendBase128(out []byte, v uint32) []byte {
+	var chunks []byte
+	for {
+		chunks = append([]byte{byte(v & 0x7F)}, chunks...)
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := range chunks[:len(chunks)-1] {
+		chunks[i] |= 0x80
+	}
+	return append(out, chunks...)
+}
+
+func appendU255(out []byte, v uint16) []byte {
+	if v < 253 {
+		return append(out, byte(v))
+	}
+	return append(out, 253, byte(v>>8), byte(v))
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func encodeTriplet(onCurve bool, dx, dy int) (byte, []byte) {
+	var flag byte
+	if !onCurve {
+		flag = 128
+	}
+	absX, absY := abs(dx), abs(dy)
+	var xSign, ySign byte
+	if dx >= 0 {
+		xSign = 1
+	}
+	if dy >= 0 {
+		ySign = 1
+	}
+	xySigns := xSign + 2*ySign
+	switch {
+	case dx == 0 && absY < 1280:
+		return flag + byte((absY&0xf00)>>7) + ySign, []byte{byte(absY)}
+	case dy == 0 && absX < 1280:
+		return flag + 10 + byte((absX&0xf00)>>7) + xSign, []byte{byte(absX)}
+	case absX < 65 && absY < 65:
+		return flag + 20 + byte((absX-1)&0x30) + byte(((absY-1)&0x30)>>2) + xySigns,
+			[]byte{byte(((absX-1)&0xf)<<4 | (absY-1)&0xf)}
+	case absX < 769 && absY < 769:
+		return flag + 84 + 12*byte(((absX-1)&0x300)>>8) + byte(((absY-1)&0x300)>>6) + xySigns,
+			[]byte{byte(absX - 1), byte(absY - 1)}
+	case absX < 4096 && absY < 4096:
+		return flag + 120 + xySigns, []byte{byte(absX >> 4), byte((absX&0xf)<<4 | absY>>8), byte(absY)}
+	default:
+		return flag + 124 + xySigns, []byte{byte(absX >> 8), byte(absX), byte(absY >> 8), byte(absY)}
+	}
+}
+
+// transformGlyf applies the WOFF2 'glyf' transform,
+// only supporting simple glyphs
+func transformGlyf(t *testing.T, glyf tables.Glyf, indexFormat int16) []byte {
+	var nContours, nPoints, flags, glyphs, bboxes, instructions []byte
+	bboxBitmap := make([]byte, ((len(glyf)+31)>>5)<<2)
+	for gid, glyph := range glyf {
+		switch data := glyph.Data.(type) {
+		case nil:
+			nContours = append(nContours, 0, 0)
+		case tables.SimpleGlyph:
+			nContours = appendUint16(nContours, uint16(len(data.EndPtsOfContours)))
+			previous := -1
+			for _, end := range data.EndPtsOfContours {
+				nPoints = appendU255(nPoints, uint16(int(end)-previous))
+				previous = int(end)
+			}
+			var x, y int
+			for _, point := range data.Points {
+				flag, coords := encodeTriplet(point.Flag&glyfOnCurve != 0, int(point.X)-x, int(point.Y)-y)
+				flags = append(flags, flag)
+				glyphs = append(glyphs, coords...)
+				x, y = int(point.X), int(point.Y)
+			}
+			glyphs = appendU255(glyphs, uint16(len(data.Instructions)))
+			instructions = append(instructions, data.Instructions...)
+
+			// use an explicit bounding box for odd glyphs
+			if gid%2 == 1 {
+				bboxBitmap[gid>>3] |= 0x80 >> (gid & 7)
+				for _, v := range [4]int16{glyph.XMin, glyph.YMin, glyph.XMax, glyph.YMax} {
+					bboxes = appendUint16(bboxes, uint16(v))
+				}
+			}
+		default:
+			t.Fatal("unsupported composite glyph")
+		}
+	}
+	bboxes = append(bboxBitmap, bboxes...)
+
+	out := make([]byte, 8)
+	binary.BigEndian.PutUint16(out[4:], uint16(len(glyf)))
+	binary.BigEndian.PutUint16(out[6:], uint16(indexFormat))
+	streams := [][]byte{nContours, nPoints, flags, glyphs, nil, bboxes, instructions}
+	for _, stream := range streams {
+		out = appendUint32(out, uint32(len(stream)))
+	}
+	for _, stream := range streams {
+		out = append(out, stream...)
+	}
+	return out
+}
+
+// encodeWOFF2 applies the 'glyf', 'loca' and 'hmtx' transforms
+// and compresses the tables of [font]
+func encodeWOFF2(t *testing.T, font []byte) []byte {
+	ld := mustLoader(t, font)
+	glyf := loadGlyf(t, ld)
+
+	head, _, err := font_.LoadHeadTable(ld, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var directory, stream []byte
+	tags := ld.Tables()
+	for _, tag := range tags {
+		table, err := ld.RawTable(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tagIndex := byte(63)
+		for i, known := range woff2KnownTags {
+			if loader.MustNewTag(known) == tag {
+				tagIndex = byte(i)
+			}
+		}
+
+		var transformed []byte
+		switch tag {
+		case glyfTag:
+			transformed = transformGlyf(t, glyf, head.IndexToLocFormat)
+		case locaTag:
+			transformed = []byte{}
+		case hmtxTag:
+			// drop the left side bearings, which are deduced from the glyphs
+			transformed = []byte{3}
+			for i := range glyf {
+				if 4*i+4 > len(table) {
+					t.Fatal("unsupported hmtx table")
+				}
+				transformed = append(transformed, table[4*i:4*i+2]...)
+				if lsb := int16(binary.BigEndian.Uint16(table[4*i+2:])); lsb != glyf[i].XMin {
+					t.Fatalf("unsupported left side bearing for glyph %d", i)
+				}
+			}
+			tagIndex |= 1 << 6
+		}
+
+		directory = append(directory, tagIndex)
+		if tagIndex&0x3F == 63 {
+			directory = appendUint32(directory, uint32(tag))
+		}
+		directory = appendBase128(directory, uint32(len(table)))
+		if transformed != nil {
+			directory = appendBase128(directory, uint32(len(transformed)))
+			table = transformed
+		}
+		stream = append(stream, table...)
+	}
+
+	var compressed bytes.Buffer
+	w := brotli.NewWriter(&compressed)
+	w.Write(stream)
+	w.Close()
+
+	header := make([]byte, woff2HeaderSize)
+	copy(header, "wOF2")
+	binary.BigEndian.PutUint32(header[4:], uint32(loader.TrueType))
+	binary.BigEndian.PutUint16(header[12:], uint16(len(tags)))
+	binary.BigEndian.PutUint32(header[20:], uint32(compressed.Len()))
+	out := append(append(header, directory...), compressed.Bytes()...)
+	binary.BigEndian.PutUint32(out[8:], uint32(len(out)))
+	return out
+}
+
+func loadGlyf(t *testing.T, ld *loader.Loader) tables.Glyf {
+	head, _, err := font_.LoadHeadTable(ld, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxp, err := ld.RawTable(maxpTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxpT, _, err := tables.ParseMaxp(maxp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locaRaw, err := ld.RawTable(locaTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loca, err := tables.ParseLoca(locaRaw, int(maxpT.NumGlyphs), head.IndexToLocFormat == 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	glyfRaw, err := ld.RawTable(glyfTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	glyf, err := tables.ParseGlyf(glyfRaw, loca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return glyf
+}
+
+func TestDecodeWOFF(t *testing.T) {
+	otf, err := os.ReadFile("../resources_test/weasyprint.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sfntFace(encodeWOFF(t, otf), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loadTables(t, got), loadTables(t, otf)) {
+		t.Fatal("invalid WOFF decoding")
+	}
+}
+
+func TestDecodeWOFF2(t *testing.T) {
+	otf, err := os.ReadFile("../resources_test/weasyprint.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sfntFace(encodeWOFF2(t, otf), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the 'glyf' and 'loca' tables are reconstructed,
+	// and may differ from the original ones
+	expected, actual := loadTables(t, otf), loadTables(t, got)
+	for _, tag := range []loader.Tag{glyfTag, locaTag} {
+		if actual[tag] == nil {
+			t.Fatalf("missing table %s", tag)
+		}
+		delete(expected, tag)
+		delete(actual, tag)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatal("invalid WOFF2 decoding")
+	}
+
+	expectedGlyf, actualGlyf := loadGlyf(t, mustLoader(t, otf)), loadGlyf(t, mustLoader(t, got))
+	if len(expectedGlyf) != len(actualGlyf) {
+		t.Fatalf("expected %d glyphs, got %d", len(expectedGlyf), len(actualGlyf))
+	}
+	for gid, glyph := range expectedGlyf {
+		got := actualGlyf[gid]
+		if [4]int16{glyph.XMin, glyph.YMin, glyph.XMax, glyph.YMax} != [4]int16{got.XMin, got.YMin, got.XMax, got.YMax} {
+			t.Fatalf("invalid bounding box for glyph %d", gid)
+		}
+		exp, _ := glyph.Data.(tables.SimpleGlyph)
+		act, _ := got.Data.(tables.SimpleGlyph)
+		if !reflect.DeepEqual(exp.EndPtsOfContours, act.EndPtsOfContours) || !bytes.Equal(exp.Instructions, act.Instructions) ||
+			len(exp.Points) != len(act.Points) {
+			t.Fatalf("invalid glyph %d", gid)
+		}
+		for i, point := range exp.Points {
+			if p := act.Points[i]; p.X != point.X || p.Y != point.Y || p.Flag&glyfOnCurve != point.Flag&glyfOnCurve {
+				t.Fatalf("invalid point %d for glyph %d", i, gid)
+			}
+		}
+	}
+
+	if _, err = sfntFace(encodeWOFF2(t, otf)[:100], 0); err == nil {
+		t.Fatal("expected error for truncated file")
+	}
+}
+
+func TestReconstructGlyfTruncatedBbox(t *testing.T) {
+	// one composite glyph, with a bounding box flag but no bounding box
+	var data bytes.Buffer
+	binary.Write(&data, binary.BigEndian, [4]uint16{0, 0, 1, 0}) // reserved, flags, numGlyphs, indexFormat
+	binary.Write(&data, binary.BigEndian, [7]uint32{2, 0, 0, 0, 6, 4, 0})
+	binary.Write(&data, binary.BigEndian, int16(-1)) // nContours
+	data.Write(make([]byte, 6))                      // one component, without flags
+	data.Write([]byte{0x80, 0, 0, 0})                // bbox bitmap
+	if _, _, _, err := reconstructGlyf(data.Bytes()); err == nil {
+		t.Fatal("expected error for truncated bounding box")
+	}
+}
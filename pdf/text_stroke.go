@@ -0,0 +1,117 @@
+package pdf
+
+import (
+	"github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+	"github.com/benoitkugler/webrender/matrix"
+)
+
+// TextStroke is the stroke applied to text, independently
+// of the stroke settings used for paths (like for the CSS
+// '-webkit-text-stroke' property).
+type TextStroke struct {
+	Color parser.RGBA
+	Width fl
+
+	// Dashes and DashOffset have the same meaning as for [backend.GraphicState.SetDash]
+	Dashes     []fl
+	DashOffset fl
+
+	// Options default to a miter join, with a limit of 10
+	// when MiterLimit is zero.
+	Options backend.StrokeOptions
+}
+
+// TextPainter is implemented by the canvases of this package,
+// and may be used with a type assertion on [backend.Canvas].
+// The settings are restored by [backend.Canvas.OnNewStack].
+//
+// The style engine (webrender v0.0.9) does not support the '-webkit-text-stroke'
+// and 'paint-order' properties, and never calls these methods, so that the text
+// of rendered HTML documents uses the path stroke settings. They are only useful when
+// drawing on the canvas directly, for instance in [Overlay.Draw].
+type TextPainter interface {
+	// SetTextStroke sets the stroke used by the text drawn with a
+	// stroking mode (see [backend.GraphicState.SetTextPaint]).
+	// If [stroke] is nil, the current path stroke settings are used.
+	SetTextStroke(stroke *TextStroke)
+
+	// SetTextPaintOrder controls whether text filled and stroked is
+	// stroked before being filled (like the CSS 'paint-order: stroke' property),
+	// so that the fill covers the inner half of the stroke.
+	SetTextPaintOrder(strokeFirst bool)
+}
+
+var _ TextPainter = (*group)(nil)
+
+func (g *group) SetTextStroke(stroke *TextStroke) { g.state.textStroke = stroke }
+
+func (g *group) SetTextPaintOrder(strokeFirst bool) { g.state.strokeFirst = strokeFirst }
+
+// textLineWidth returns the line width used to stroke text
+func (g *group) textLineWidth() fl {
+	if ts := g.state.textStroke; ts != nil {
+		return ts.Width
+	}
+	return g.state.lineWidth
+}
+
+// beginTextStroke applies the text stroke, if any.
+// It must be followed by [endTextStroke].
+func (g *group) beginTextStroke() {
+	ts := g.state.textStroke
+	if ts == nil {
+		return
+	}
+	opts := ts.Options
+	if opts.MiterLimit == 0 {
+		opts.MiterLimit = 10
+	}
+	g.applyColor(ts.Color, true)
+	g.app.Ops(
+		contentstream.OpSetLineWidth{W: ts.Width},
+		contentstream.OpSetDash{Dash: model.DashPattern{Array: ts.Dashes, Phase: ts.DashOffset}},
+	)
+	g.applyStrokeOptions(opts)
+}
+
+// endTextStroke restores the stroke settings modified by [beginTextStroke]
+func (g *group) endTextStroke() {
+	if g.state.textStroke == nil {
+		return
+	}
+	g.applyColor(g.state.stroke, true)
+	g.app.Ops(
+		contentstream.OpSetLineWidth{W: g.state.lineWidth},
+		contentstream.OpSetDash{Dash: model.DashPattern{Array: g.state.dashes, Phase: g.state.dashOffset}},
+	)
+	g.applyStrokeOptions(g.state.strokeOptions)
+}
+
+// textPasses returns the text rendering modes used to
+// draw text with the mode [textRender], according to the paint order.
+func (g *group) textPasses(textRender uint8) []uint8 {
+	if textRender == 2 && g.state.strokeFirst {
+		return []uint8{1, 0}
+	}
+	return []uint8{textRender}
+}
+
+// showRunPasses shows [run] several times, with the given
+// text rendering modes and the text matrix [mat].
+// Only the first pass is used for text extraction.
+func (g *group) showRunPasses(run backend.TextRun, pf pdfFont, mat matrix.Transform, passes []uint8) {
+	for i, pass := range passes {
+		g.app.SetTextMatrix(mat.A, mat.B, mat.C, mat.D, mat.E, mat.F)
+		g.setTextRender(pass)
+		if i == 0 {
+			g.showRun(run, pf)
+			continue
+		}
+		g.app.Ops(contentstream.OpBeginMarkedContent{Tag: "Artifact"})
+		g.showRun(run, pf)
+		g.app.Ops(contentstream.OpEndMarkedContent{})
+	}
+}
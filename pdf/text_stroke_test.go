@@ -0,0 +1,77 @@
+package pdf
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+func TestTextStrokeOptions(t *testing.T) {
+	otf, err := os.ReadFile("../resources_test/weasyprint.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	font := newTestFont("Test")
+	output := NewOutput()
+	page := output.AddPage(0, 0, 100, 100)
+	chars := page.AddFont(font, otf)
+	chars.Cmap[36] = []rune{'A'}
+	chars.Extents[36] = backend.GlyphExtents{Width: 600}
+
+	page.State().SetTextPaint(backend.FillNonZero | backend.Stroke)
+	page.(TextPainter).SetTextStroke(&TextStroke{Color: parser.RGBA{G: 1, A: 1}, Width: 2.5, Dashes: []fl{1, 2}})
+	page.(TextPainter).SetTextPaintOrder(true)
+	page.DrawText([]backend.TextDrawing{{
+		Runs:     []backend.TextRun{{Font: font, Glyphs: []backend.TextGlyph{{Glyph: 36}, {Glyph: 36}}}},
+		FontSize: 12,
+		X:        10,
+		Y:        50,
+	}})
+	page.Rectangle(0, 0, 10, 10)
+	page.Paint(backend.Stroke)
+
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := doc.Catalog.Pages.Kids[0].(*model.PageObject).Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(content)
+	for _, expected := range []string{
+		"0 1 0 RG", "2.5 w", "[1 2] 0 d",
+		"1 Tr", "/Artifact BMC", "0 Tr", "2 Tr", // stroke, then fill
+		"0 0 0 RG\n1 w\n[] 0 d", // restored for paths
+	} {
+		if !strings.Contains(s, expected) {
+			t.Fatalf("missing %s in %s", expected, s)
+		}
+	}
+	if strings.Index(s, "1 Tr") > strings.Index(s, "0 Tr") {
+		t.Fatalf("expected the stroke before the fill, got %s", s)
+	}
+	if strings.Count(s, "TJ") != 2 {
+		t.Fatalf("expected two text passes, got %s", s)
+	}
+}
+
+func TestTextPainterOverlay(t *testing.T) {
+	output := NewOutput()
+	var ok bool
+	output.Options.Overlays = []Overlay{{
+		Draw: func(dst backend.Canvas, width, height fl) { _, ok = dst.(TextPainter) },
+	}}
+	page := output.AddPage(0, 0, 100, 100)
+	page.SetMediaBox(0, 0, 100, 100)
+	if _, err := output.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("the overlay canvas should implement TextPainter")
+	}
+}
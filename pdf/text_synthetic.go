@@ -0,0 +1,131 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/matrix"
+	"github.com/benoitkugler/webrender/text"
+	"github.com/go-text/typesetting/opentype/loader"
+)
+
+// When the requested face is missing, the font configuration may
+// select a regular face and ask for synthetic bold or oblique styles,
+// which are emulated using the text rendering mode and the text matrix.
+
+const (
+	// syntheticBoldWidth is the stroke width used to embolden text,
+	// relative to the font size
+	syntheticBoldWidth = 1. / 30
+	// syntheticObliqueSkew is the horizontal skew used to slant text,
+	// matching the one used by fontconfig
+	syntheticObliqueSkew = 0.2
+	// syntheticItalicAngle is the angle (in degrees) matching [syntheticObliqueSkew]
+	syntheticItalicAngle = -11
+)
+
+// SyntheticFont may be implemented by [backend.Font] to
+// request synthetic styles.
+// Otherwise, as done by fontconfig, the styles are synthesized when
+// the weight or the style of the font description is not provided
+// by the face, as read in its OS/2 and post tables.
+type SyntheticFont interface {
+	// Synthetic returns true if the glyphs should be
+	// emboldened and/or slanted.
+	Synthetic() (bold, oblique bool)
+}
+
+var postTag = loader.MustNewTag("post")
+
+const (
+	fsSelectionItalic  = 0x0001
+	fsSelectionOblique = 0x0200
+)
+
+// readFaceStyle returns the weight (usWeightClass) of the face [content],
+// and whether it is italic or oblique.
+// Faces without OS/2 table are assumed to be regular.
+func readFaceStyle(content []byte) (weight uint16, italic bool, err error) {
+	ld, err := loader.NewLoader(bytes.NewReader(content))
+	if err != nil {
+		return 0, false, err
+	}
+	weight = 400
+	if ld.HasTable(os2Tag) {
+		os2, err := ld.RawTable(os2Tag)
+		if err != nil {
+			return 0, false, err
+		}
+		if len(os2) < 64 {
+			return 0, false, fmt.Errorf("invalid OS/2 table length %d", len(os2))
+		}
+		weight = binary.BigEndian.Uint16(os2[4:])
+		italic = binary.BigEndian.Uint16(os2[62:])&(fsSelectionItalic|fsSelectionOblique) != 0
+	}
+	if !italic && ld.HasTable(postTag) {
+		post, err := ld.RawTable(postTag)
+		if err != nil {
+			return 0, false, err
+		}
+		if len(post) < 8 {
+			return 0, false, fmt.Errorf("invalid post table length %d", len(post))
+		}
+		italic = binary.BigEndian.Uint32(post[4:]) != 0 // italicAngle
+	}
+	return weight, italic, nil
+}
+
+// syntheticStyles returns the synthetic styles needed to render [font]
+// with the face [content], see [SyntheticFont].
+// As in the fontconfig configuration, bold (700 and more) is synthesized
+// for faces not heavier than medium (500), and oblique for upright faces.
+func syntheticStyles(font backend.Font, content []byte) (bold, oblique bool) {
+	if sf, ok := font.(SyntheticFont); ok {
+		return sf.Synthetic()
+	}
+	weight, italic, err := readFaceStyle(content)
+	if err != nil {
+		log.Printf("reading style of font %s failed: %s", font.Description().Family, err)
+		return false, false
+	}
+	desc := font.Description()
+	bold = desc.Weight >= 700 && weight <= 500
+	oblique = desc.Style != text.FSyNormal && !italic
+	return bold, oblique
+}
+
+// obliqueMatrix returns the text matrix [mat], slanted if required.
+func obliqueMatrix(mat matrix.Transform, oblique bool) matrix.Transform {
+	if oblique {
+		mat.RightMultBy(matrix.New(1, 0, syntheticObliqueSkew, 1, 0, 0))
+	}
+	return mat
+}
+
+// beginSyntheticBold saves the graphic state and updates it to embolden text,
+// and returns the text rendering mode to use.
+// It must be called outside of text objects, and followed by a call to [endSyntheticBold].
+func (g *group) beginSyntheticBold(fontSize fl) uint8 {
+	g.app.SaveState()
+	width := fontSize * syntheticBoldWidth
+	switch g.state.textRender {
+	case 0: // stroke with the fill color, without dashes
+		g.applyColor(g.state.fill, true)
+		g.app.Ops(contentstream.OpSetLineWidth{W: width}, contentstream.OpSetDash{})
+		g.setTextRender(2)
+		return 2
+	case 1, 2: // widen the stroke
+		g.app.Ops(contentstream.OpSetLineWidth{W: g.textLineWidth() + width})
+	}
+	return g.state.textRender
+}
+
+// endSyntheticBold restores the graphic state saved by [beginSyntheticBold].
+// It must be called outside of text objects.
+func (g *group) endSyntheticBold() {
+	_ = g.app.RestoreState() // the calls are balanced
+}
@@ -1,11 +1,14 @@
 package pdf
 
 import (
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/benoitkugler/pdf/model"
 	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
 )
 
 func TestCIDWidths(t *testing.T) {
@@ -26,3 +29,101 @@ func TestCIDWidths(t *testing.T) {
 		t.Fatalf("expected %v, got %v", expected, got)
 	}
 }
+
+// syntheticFont requests synthetic styles
+type syntheticFont struct {
+	testFont
+	bold, oblique bool
+}
+
+func (f syntheticFont) Synthetic() (bold, oblique bool) { return f.bold, f.oblique }
+
+func TestSyntheticStyles(t *testing.T) {
+	otf, err := os.ReadFile("../resources_test/weasyprint.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		bold, oblique    bool
+		expectedInStream string
+	}{
+		{false, false, "12 0 0 -12 "},
+		{true, false, "q\n1 0 0 RG\n0.4 w\n[] 0 d\n2 Tr\nBT"},
+		{false, true, "12 0 2.4 -12 "},
+	} {
+		font := syntheticFont{testFont: newTestFont("Test"), bold: test.bold, oblique: test.oblique}
+		output := NewOutput()
+		page := output.AddPage(0, 0, 100, 100)
+		page.State().SetDash([]fl{2, 2}, 0)
+		page.State().SetColorRgba(parser.RGBA{R: 1, A: 1}, false)
+		chars := page.AddFont(font, otf)
+		chars.Cmap[36] = []rune{'A'}
+		page.DrawText([]backend.TextDrawing{{
+			Runs:     []backend.TextRun{{Font: font, Glyphs: []backend.TextGlyph{{Glyph: 36}}}},
+			FontSize: 12,
+		}})
+		doc, err := output.Finalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageObject := doc.Catalog.Pages.Kids[0].(*model.PageObject)
+		for _, font := range pageObject.Resources.Font {
+			desc := font.Subtype.(model.FontType0).DescendantFonts.FontDescriptor
+			if bold := desc.Flags&model.ForceBold != 0; bold != test.bold {
+				t.Fatalf("for %v, unexpected bold flag", test)
+			}
+			if oblique := desc.ItalicAngle != 0; oblique != test.oblique {
+				t.Fatalf("for %v, unexpected italic angle", test)
+			}
+		}
+		content, err := pageObject.Contents[0].Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), test.expectedInStream) {
+			t.Fatalf("for %v, unexpected content %s", test, content)
+		}
+		// the graphic state is restored after the text
+		if test.bold && !strings.HasSuffix(strings.TrimSpace(string(content)), "ET\nQ") {
+			t.Fatalf("expected restored state in %s", content)
+		}
+	}
+}
+
+func TestSyntheticStylesHTML(t *testing.T) {
+	// the family only provides a regular face
+	for _, test := range []struct {
+		style                       string
+		bold, oblique               bool
+		expectedInStream, forbidden string
+	}{
+		{"", false, false, "", "2 Tr"},
+		{"font-weight: bold", true, false, "2 Tr", ""},
+		{"font-style: italic", false, true, " 0 2.4 -12 ", "2 Tr"},
+	} {
+		doc := htmlToModel(t, `
+			<style>@font-face {src: url(../resources_test/weasyprint.otf); font-family: weasyprint}</style>
+			<p style="font-family: weasyprint; font-size: 12px; `+test.style+`">ABC</p>`)
+		page := doc.Catalog.Pages.Kids[0].(*model.PageObject)
+		if L := len(page.Resources.Font); L != 1 {
+			t.Fatalf("expected one font, got %d", L)
+		}
+		for _, font := range page.Resources.Font {
+			desc := font.Subtype.(model.FontType0).DescendantFonts.FontDescriptor
+			if bold := desc.Flags&model.ForceBold != 0; bold != test.bold {
+				t.Fatalf("for %q, unexpected bold flag", test.style)
+			}
+			if oblique := desc.ItalicAngle != 0; oblique != test.oblique {
+				t.Fatalf("for %q, unexpected italic angle", test.style)
+			}
+		}
+		content, err := page.Contents[0].Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), test.expectedInStream) ||
+			(test.forbidden != "" && strings.Contains(string(content), test.forbidden)) {
+			t.Fatalf("for %q, unexpected content %s", test.style, content)
+		}
+	}
+}
@@ -0,0 +1,807 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	cs "github.com/benoitkugler/pdf/contentstream"
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+// GamutSpace is the color space of a [WideGamutColor]
+type GamutSpace uint8
+
+const (
+	// DisplayP3 is used by color(display-p3 r g b),
+	// with components between 0 and 1.
+	DisplayP3 GamutSpace = iota + 1
+	// CIELab is used by lab(L a b), with L between 0 and 100
+	CIELab
+	// OkLCh is used by oklch(L C h), with L between 0 and 1
+	// and h in degrees.
+	OkLCh
+)
+
+// WideGamutColor is a CSS Color 4 value, which may be outside of sRGB.
+// Since the colors reaching the backend are sRGB values, the wide gamut colors are
+// identified by the sRGB color they replace, [Fallback], and written in the PDF
+// as ICCBased (Display P3) or Lab colors.
+//
+// The backend does not know which element or property a color comes from, so that
+// the replacement applies to every use of the exact [Fallback] value in the document
+// (text, borders, backgrounds, SVG, gradients, whatever the selector of the declaration).
+// As a consequence, the fallback colors should not be used elsewhere in the document.
+// The fallbacks generated by [AddWideGamutFallbacks] are unique values, which
+// differ from the 8-bit colors (like #ff0000) of the other elements; the other
+// fallbacks are replaced everywhere, which is logged once per color.
+type WideGamutColor struct {
+	Space      GamutSpace
+	Components [3]fl
+
+	// Fallback is the sRGB color used in the document
+	Fallback parser.RGBA
+}
+
+// ParseWideGamutColors returns the wide gamut colors used in [css].
+// Since these colors are not supported by the style engine, they must follow a
+// declaration of the same property using sRGB colors, which are then replaced in the PDF :
+//
+//	color: #ff0000;
+//	color: color(display-p3 1 0 0);
+//	background: linear-gradient(#00f, white);
+//	background: linear-gradient(oklch(0.45 0.31 264), white);
+//
+// The colors are paired in order, so that the two values must use the
+// same number of colors.
+// The sRGB declarations may be generated with [AddWideGamutFallbacks].
+// The rules nested in at-rules (like @media) are ignored.
+// See [WideGamutColor] for the colors replaced.
+func ParseWideGamutColors(css string) ([]WideGamutColor, error) {
+	var blocks [][]parser.Token
+	for _, token := range parser.ParseStylesheetBytes([]byte(css), true, true) {
+		if rule, ok := token.(parser.QualifiedRule); ok {
+			blocks = append(blocks, parser.ParseDeclarationList(*rule.Content, true, true))
+		}
+	}
+	if len(blocks) == 0 {
+		blocks = append(blocks, parser.ParseDeclarationListString(css, true, true))
+	}
+
+	var out []WideGamutColor
+	for _, declarations := range blocks {
+		// sRGB colors of the last declaration for each property
+		fallbacks := map[string][]*parser.RGBA{}
+		for _, token := range declarations {
+			decl, ok := token.(parser.Declaration)
+			if !ok {
+				continue
+			}
+			var (
+				rgbs   []*parser.RGBA
+				wides  []*WideGamutColor
+				isWide bool
+			)
+			if err := collectColors(decl.Value, &rgbs, &wides); err != nil {
+				return nil, fmt.Errorf("invalid property %s: %s", decl.Name, err)
+			}
+			for _, w := range wides {
+				isWide = isWide || w != nil
+			}
+			name := decl.Name.Lower()
+			if !isWide {
+				fallbacks[name] = rgbs
+				continue
+			}
+			fallback := fallbacks[name]
+			if len(fallback) != len(wides) {
+				continue
+			}
+			for i, w := range wides {
+				if w != nil && fallback[i] != nil {
+					w.Fallback = *fallback[i]
+					out = append(out, *w)
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// AddWideGamutFallbacks returns [css] where each declaration using wide gamut colors,
+// if not preceded by an sRGB declaration of the same property, is preceded by a copy
+// using the closest sRGB colors, so that
+//
+//	color: color(display-p3 1 0 0);
+//
+// becomes
+//
+//	color: rgb(99.9939%, 0.0000%, 0.0000%);
+//	color: color(display-p3 1 0 0);
+//
+// where the fallback is the closest sRGB color (#ff0000), shifted by less than 1/255
+// so that it is not used by the other elements of the document (see [WideGamutColor]).
+// The result is meant to be used as stylesheet and with [ParseWideGamutColors].
+// The rules nested in at-rules (like @media) are not modified.
+func AddWideGamutFallbacks(css string) (string, error) {
+	tokens := parser.ParseStylesheetBytes([]byte(css), false, false)
+	isRule := false
+	for _, token := range tokens {
+		_, ok := token.(parser.QualifiedRule)
+		isRule = isRule || ok
+	}
+	nbFallbacks := 0
+	if !isRule { // a list of declarations, as accepted by [ParseWideGamutColors]
+		return addFallbacks(parser.ParseDeclarationListString(css, false, false), &nbFallbacks)
+	}
+
+	var out strings.Builder
+	for _, token := range tokens {
+		switch token := token.(type) {
+		case parser.QualifiedRule:
+			declarations, err := addFallbacks(parser.ParseDeclarationList(*token.Content, false, false), &nbFallbacks)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(parser.Serialize(*token.Prelude) + "{\n" + declarations + "}")
+		case parser.ParseError:
+		default: // keep the other rules
+			out.WriteString(parser.SerializeOne(token))
+		}
+	}
+	return out.String(), nil
+}
+
+// addFallbacks serializes [declarations], with the sRGB fallbacks added.
+// [nbFallbacks] is the number of fallback colors generated so far.
+func addFallbacks(declarations []parser.Token, nbFallbacks *int) (string, error) {
+	var out strings.Builder
+	fallbacks := map[string]int{} // number of sRGB colors of the last declaration
+	for _, token := range declarations {
+		decl, ok := token.(parser.Declaration)
+		if !ok {
+			continue
+		}
+		var (
+			rgbs   []*parser.RGBA
+			wides  []*WideGamutColor
+			isWide bool
+		)
+		if err := collectColors(decl.Value, &rgbs, &wides); err != nil {
+			return "", fmt.Errorf("invalid property %s: %s", decl.Name, err)
+		}
+		for _, w := range wides {
+			isWide = isWide || w != nil
+		}
+		name := decl.Name.Lower()
+		important := ""
+		if decl.Important {
+			important = " !important"
+		}
+		if !isWide {
+			fallbacks[name] = len(rgbs)
+		} else if n, has := fallbacks[name]; !has || n != len(wides) {
+			fmt.Fprintf(&out, "%s:%s%s;\n", string(decl.Name), serializeFallback(decl.Value, nbFallbacks), important)
+		}
+		fmt.Fprintf(&out, "%s:%s%s;\n", string(decl.Name), parser.Serialize(decl.Value), important)
+	}
+	return out.String(), nil
+}
+
+// serializeFallback serializes [tokens], replacing the wide gamut
+// colors by their unique fallback, see [uniqueFallback]
+func serializeFallback(tokens []parser.Token, nbFallbacks *int) string {
+	var out strings.Builder
+	for _, token := range tokens {
+		fn, ok := token.(parser.FunctionBlock)
+		if !ok {
+			out.WriteString(parser.SerializeOne(token))
+			continue
+		}
+		if wide, isWide, _ := parseWideGamutColor(fn); isWide {
+			out.WriteString(uniqueFallback(wide.toSRGB(), *nbFallbacks))
+			*nbFallbacks++
+			continue
+		}
+		out.WriteString(string(fn.Name) + "(" + serializeFallback(*fn.Arguments, nbFallbacks) + ")")
+	}
+	return out.String()
+}
+
+// uniqueFallback serializes the closest 8-bit color of [c], with one channel
+// shifted by less than 1/255, depending on [index], so that the fallback
+// is distinct from the (8-bit) colors of the other declarations, and
+// from the 92 previous fallbacks.
+func uniqueFallback(c parser.RGBA, index int) string {
+	channels := [3]float64{math.Round(float64(c.R) * 255), math.Round(float64(c.G) * 255), math.Round(float64(c.B) * 255)}
+	k, shift := index%3, float64(index/3%31+1)/64
+	if channels[k]+shift > 255 {
+		shift = -shift
+	}
+	channels[k] += shift
+	percent := func(v float64) string { return strconv.FormatFloat(v/255*100, 'f', 4, 64) + "%" }
+	return fmt.Sprintf("rgb(%s, %s, %s)", percent(channels[0]), percent(channels[1]), percent(channels[2]))
+}
+
+// collectColors walks through [tokens], adding one entry in [rgbs] and [wides]
+// for each color
+func collectColors(tokens []parser.Token, rgbs *[]*parser.RGBA, wides *[]*WideGamutColor) error {
+	for _, token := range tokens {
+		if fn, ok := token.(parser.FunctionBlock); ok {
+			wide, isWide, err := parseWideGamutColor(fn)
+			if err != nil {
+				return err
+			}
+			if isWide {
+				*rgbs, *wides = append(*rgbs, nil), append(*wides, &wide)
+				continue
+			}
+		}
+		switch color := parser.ParseColor(token); color.Type {
+		case parser.ColorRGBA:
+			rgba := color.RGBA
+			*rgbs, *wides = append(*rgbs, &rgba), append(*wides, nil)
+		case parser.ColorCurrentColor:
+			*rgbs, *wides = append(*rgbs, nil), append(*wides, nil)
+		default:
+			if fn, ok := token.(parser.FunctionBlock); ok {
+				if err := collectColors(*fn.Arguments, rgbs, wides); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseWideGamutColor returns false if [fn] is not a
+// wide gamut color function
+func parseWideGamutColor(fn parser.FunctionBlock) (WideGamutColor, bool, error) {
+	args := *fn.Arguments
+	var out WideGamutColor
+	switch fn.Name.Lower() {
+	case "color":
+		// the first argument is the color space
+		for len(args) != 0 {
+			if _, ok := args[0].(parser.WhitespaceToken); !ok {
+				break
+			}
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return out, false, nil
+		}
+		if space, ok := args[0].(parser.IdentToken); !ok || space.Value.Lower() != "display-p3" {
+			return out, false, nil
+		}
+		out.Space = DisplayP3
+		args = args[1:]
+	case "lab":
+		out.Space = CIELab
+	case "oklch":
+		out.Space = OkLCh
+	default:
+		return out, false, nil
+	}
+
+	var n int
+	for _, token := range args {
+		if lit, ok := token.(parser.LiteralToken); ok && lit.Value == "/" {
+			break // alpha is ignored
+		}
+		var (
+			value     fl
+			isPercent bool
+		)
+		switch token := token.(type) {
+		case parser.WhitespaceToken, parser.Comment:
+			continue
+		case parser.NumberToken:
+			value = token.Value
+		case parser.PercentageToken:
+			value, isPercent = token.Value/100, true
+		case parser.DimensionToken:
+			angle, ok := parseHue(token)
+			if !ok || out.Space != OkLCh || n != 2 {
+				return out, true, fmt.Errorf("unexpected dimension %s", parser.SerializeOne(token))
+			}
+			value = angle
+		case parser.IdentToken:
+			if token.Value.Lower() != "none" {
+				return out, true, fmt.Errorf("unexpected keyword %s", token.Value)
+			}
+		default:
+			return out, true, fmt.Errorf("unexpected token %s", parser.SerializeOne(token))
+		}
+		if n == 3 {
+			return out, true, fmt.Errorf("too many components in %s()", fn.Name)
+		}
+		if isPercent { // see the CSS Color 4 reference ranges
+			switch {
+			case out.Space == CIELab && n == 0:
+				value *= 100
+			case out.Space == CIELab:
+				value *= 125
+			case out.Space == OkLCh && n == 1:
+				value *= 0.4
+			}
+		}
+		out.Components[n] = value
+		n++
+	}
+	if n != 3 {
+		return out, true, fmt.Errorf("expected 3 components in %s(), got %d", fn.Name, n)
+	}
+	return out, true, nil
+}
+
+// parseHue returns the angle in degrees
+func parseHue(token parser.DimensionToken) (fl, bool) {
+	switch token.Unit.Lower() {
+	case "deg":
+		return token.Value, true
+	case "rad":
+		return token.Value * 180 / math.Pi, true
+	case "grad":
+		return token.Value * 0.9, true
+	case "turn":
+		return token.Value * 360, true
+	default:
+		return 0, false
+	}
+}
+
+type vec3 [3]float64
+
+type mat3 [3]vec3
+
+func (m mat3) apply(v vec3) vec3 {
+	var out vec3
+	for i, row := range m {
+		out[i] = row[0]*v[0] + row[1]*v[1] + row[2]*v[2]
+	}
+	return out
+}
+
+func (m mat3) mul(n mat3) mat3 {
+	var out mat3
+	for i := range out {
+		for j := range out[i] {
+			out[i][j] = m[i][0]*n[0][j] + m[i][1]*n[1][j] + m[i][2]*n[2][j]
+		}
+	}
+	return out
+}
+
+var (
+	linearSRGBToXYZ = mat3{
+		{0.41239079926595934, 0.357584339383878, 0.1804807884018343},
+		{0.21263900587151027, 0.715168678767756, 0.07219231536073371},
+		{0.01933081871559182, 0.11919477979462598, 0.9505321522496607},
+	}
+	linearP3ToXYZ = mat3{
+		{0.4865709486482162, 0.26566769316909306, 0.1982172852343625},
+		{0.2289745640697488, 0.6917385218365064, 0.079286914093745},
+		{0, 0.04511338185890264, 1.043944368900976},
+	}
+	xyzToLinearSRGB = mat3{
+		{3.2409699419045226, -1.537383177570094, -0.4986107602930034},
+		{-0.9692436362808796, 1.8759675015077202, 0.04155505740717559},
+		{0.05563007969699366, -0.20397695888897652, 1.0569715142428786},
+	}
+	xyzToLinearP3 = mat3{
+		{2.493496911941425, -0.9313836179191239, -0.40271078445071684},
+		{-0.8294889695615747, 1.7626640603183463, 0.023624685841943577},
+		{0.03584583024378447, -0.07617238926804182, 0.9568845240076872},
+	}
+	// Bradford chromatic adaptation
+	d65ToD50 = mat3{
+		{1.0479298208405488, 0.022946793341019088, -0.05019222954313557},
+		{0.029627815688159344, 0.990434484573249, -0.01707382502938514},
+		{-0.009243058152591178, 0.015055144896577895, 0.7518742899580008},
+	}
+	d50ToD65 = mat3{
+		{0.9554734527042182, -0.023098536874261423, 0.0632593086610217},
+		{-0.028369706963208136, 1.0099954580058226, 0.021041398966943008},
+		{0.012314001688319899, -0.020507696433477912, 1.3303659366080753},
+	}
+	d50White = vec3{0.3457 / 0.3585, 1, (1 - 0.3457 - 0.3585) / 0.3585}
+)
+
+// sRGB transfer function, also used by Display P3
+func toLinear(c float64) float64 {
+	sign := 1.
+	if c < 0 {
+		sign, c = -1, -c
+	}
+	if c <= 0.04045 {
+		return sign * c / 12.92
+	}
+	return sign * math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func fromLinear(c float64) float64 {
+	sign := 1.
+	if c < 0 {
+		sign, c = -1, -c
+	}
+	if c <= 0.0031308 {
+		return sign * 12.92 * c
+	}
+	return sign * (1.055*math.Pow(c, 1/2.4) - 0.055)
+}
+
+func srgbToXYZ(color parser.RGBA) vec3 {
+	return linearSRGBToXYZ.apply(vec3{toLinear(float64(color.R)), toLinear(float64(color.G)), toLinear(float64(color.B))})
+}
+
+func xyzToP3(xyz vec3) vec3 {
+	lin := xyzToLinearP3.apply(xyz)
+	return vec3{fromLinear(lin[0]), fromLinear(lin[1]), fromLinear(lin[2])}
+}
+
+// xyzToLab converts D65 XYZ to D50 Lab
+func xyzToLab(xyz vec3) vec3 {
+	xyz = d65ToD50.apply(xyz)
+	const epsilon, kappa = 216. / 24389, 24389. / 27
+	var f vec3
+	for i, v := range xyz {
+		v /= d50White[i]
+		if v > epsilon {
+			f[i] = math.Cbrt(v)
+		} else {
+			f[i] = (kappa*v + 16) / 116
+		}
+	}
+	return vec3{116*f[1] - 16, 500 * (f[0] - f[1]), 200 * (f[1] - f[2])}
+}
+
+// labToXYZ converts D50 Lab to D65 XYZ
+func labToXYZ(lab vec3) vec3 {
+	const epsilon, kappa = 216. / 24389, 24389. / 27
+	fy := (lab[0] + 16) / 116
+	f := vec3{lab[1]/500 + fy, fy, fy - lab[2]/200}
+	var xyz vec3
+	for i, v := range f {
+		if v*v*v > epsilon {
+			xyz[i] = v * v * v
+		} else {
+			xyz[i] = (116*v - 16) / kappa
+		}
+		xyz[i] *= d50White[i]
+	}
+	return d50ToD65.apply(xyz)
+}
+
+// xyzToSRGB converts D65 XYZ to sRGB, clipping the components
+func xyzToSRGB(xyz vec3) parser.RGBA {
+	lin := xyzToLinearSRGB.apply(xyz)
+	var out [3]fl
+	for i, c := range lin {
+		out[i] = fl(math.Max(0, math.Min(1, fromLinear(c))))
+	}
+	return parser.RGBA{R: out[0], G: out[1], B: out[2], A: 1}
+}
+
+// toSRGB returns the closest sRGB color
+func (w WideGamutColor) toSRGB() parser.RGBA {
+	c := vec3{float64(w.Components[0]), float64(w.Components[1]), float64(w.Components[2])}
+	switch w.Space {
+	case DisplayP3:
+		return xyzToSRGB(linearP3ToXYZ.apply(vec3{toLinear(c[0]), toLinear(c[1]), toLinear(c[2])}))
+	case OkLCh:
+		return xyzToSRGB(oklchToXYZ(c))
+	default:
+		return xyzToSRGB(labToXYZ(c))
+	}
+}
+
+func oklchToXYZ(lch vec3) vec3 {
+	h := lch[2] * math.Pi / 180
+	L, a, b := lch[0], lch[1]*math.Cos(h), lch[1]*math.Sin(h)
+	l := math.Pow(L+0.3963377774*a+0.2158037573*b, 3)
+	m := math.Pow(L-0.1055613458*a-0.0638541728*b, 3)
+	s := math.Pow(L-0.0894841775*a-1.2914855480*b, 3)
+	return linearSRGBToXYZ.apply(vec3{
+		4.0767416621*l - 3.3077115913*m + 0.2309699292*s,
+		-1.2684380046*l + 2.6097574011*m - 0.3413193965*s,
+		-0.0041960863*l - 0.7034186147*m + 1.7076147010*s,
+	})
+}
+
+func srgbToOklch(color parser.RGBA) vec3 {
+	r, g, b := toLinear(float64(color.R)), toLinear(float64(color.G)), toLinear(float64(color.B))
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+	L := 0.2104542553*l + 0.7936177850*m - 0.0040720468*s
+	A := 1.9779984951*l - 2.4285922050*m + 0.4505937099*s
+	B := 0.0259040371*l + 0.7827717662*m - 0.8086757660*s
+	hue := math.Atan2(B, A) * 180 / math.Pi
+	if hue < 0 {
+		hue += 360
+	}
+	return vec3{L, math.Hypot(A, B), hue}
+}
+
+// labColorSpace is the D50 CIE Lab color space
+var labColorSpace = model.ColorSpaceLab{
+	WhitePoint: [3]fl{0.9642, 1, 0.8249},
+	Range:      [4]fl{-128, 127, -128, 127},
+}
+
+// displayP3 returns the shared ICCBased color space for Display P3,
+// building it on first use
+func (c cache) displayP3() *model.ColorSpaceICCBased {
+	if cs := c.iccSpaces[DisplayP3]; cs != nil {
+		return cs
+	}
+	cs := &model.ColorSpaceICCBased{
+		Stream:    model.NewCompressedStream(displayP3Profile()),
+		N:         3,
+		Alternate: model.ColorSpaceRGB,
+	}
+	c.iccSpaces[DisplayP3] = cs
+	return cs
+}
+
+// displayP3Profile builds a version 2 matrix/TRC ICC profile for Display P3,
+// that is the P3 primaries with a D65 white point and the sRGB transfer function
+func displayP3Profile() []byte {
+	s15Fixed16 := func(v float64) uint32 { return uint32(int32(math.Round(v * 65536))) }
+	xyzTag := func(v vec3) []byte {
+		out := []byte("XYZ \x00\x00\x00\x00")
+		for _, c := range v {
+			out = appendUint32(out, s15Fixed16(c))
+		}
+		return out
+	}
+
+	description := "Display P3"
+	desc := []byte("desc\x00\x00\x00\x00")
+	desc = appendUint32(desc, uint32(len(description)+1))
+	desc = append(desc, description...)
+	desc = append(desc, make([]byte, 1+4+4+2+1+67)...)
+
+	cprt := append([]byte("text\x00\x00\x00\x00No copyright, use freely"), 0)
+
+	const curveSize = 1024
+	trc := []byte("curv\x00\x00\x00\x00")
+	trc = appendUint32(trc, curveSize)
+	for i := 0; i < curveSize; i++ {
+		v := toLinear(float64(i) / (curveSize - 1))
+		trc = appendUint16(trc, uint16(math.Round(v*65535)))
+	}
+
+	// colorants adapted to D50
+	colorants := d65ToD50.mul(linearP3ToXYZ)
+	tags := []struct {
+		signature string
+		data      []byte
+	}{
+		{"desc", desc},
+		{"cprt", cprt},
+		{"wtpt", xyzTag(d50White)},
+		{"rXYZ", xyzTag(vec3{colorants[0][0], colorants[1][0], colorants[2][0]})},
+		{"gXYZ", xyzTag(vec3{colorants[0][1], colorants[1][1], colorants[2][1]})},
+		{"bXYZ", xyzTag(vec3{colorants[0][2], colorants[1][2], colorants[2][2]})},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	var data bytes.Buffer
+	table := appendUint32(nil, uint32(len(tags)))
+	dataStart := 128 + 4 + 12*len(tags)
+	trcOffset := -1
+	for _, tag := range tags {
+		offset := dataStart + data.Len()
+		if tag.signature[1:] == "TRC" && trcOffset != -1 { // shared curve
+			offset = trcOffset
+		} else {
+			if tag.signature[1:] == "TRC" {
+				trcOffset = offset
+			}
+			data.Write(tag.data)
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		table = append(table, tag.signature...)
+		table = appendUint32(table, uint32(offset))
+		table = appendUint32(table, uint32(len(tag.data)))
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(128+len(table)+data.Len()))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // version 2.1
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	for i, c := range (vec3{0.9642, 1, 0.8249}) { // PCS illuminant
+		binary.BigEndian.PutUint32(header[68+4*i:], s15Fixed16(c))
+	}
+
+	out := append(header, table...)
+	return append(out, data.Bytes()...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// findWideGamut returns the wide gamut color replacing [color], or nil
+func (c cache) findWideGamut(color parser.RGBA) *WideGamutColor {
+	for i, w := range c.options.WideGamutColors {
+		if sameRGB(w.Fallback, color) {
+			c.logSharedColor(color, "wide gamut color")
+			return &c.options.WideGamutColors[i]
+		}
+	}
+	return nil
+}
+
+// pdfColor returns the color space name and components used to write [w]
+func (w *WideGamutColor) pdfColor() (model.ColorSpaceName, []fl) {
+	switch w.Space {
+	case DisplayP3:
+		return "DisplayP3", w.Components[:]
+	case OkLCh:
+		lab := xyzToLab(oklchToXYZ(vec3{float64(w.Components[0]), float64(w.Components[1]), float64(w.Components[2])}))
+		return "Lab", []fl{fl(lab[0]), fl(lab[1]), fl(lab[2])}
+	default:
+		return "Lab", w.Components[:]
+	}
+}
+
+// useColorSpace registers the wide gamut color space [name]
+func (g *group) useColorSpace(name model.ColorSpaceName) {
+	if g.colorSpaces == nil {
+		g.colorSpaces = make(model.ResourcesColorSpace)
+	}
+	if name == "DisplayP3" {
+		g.colorSpaces[name] = g.displayP3()
+	} else {
+		g.colorSpaces[name] = labColorSpace
+	}
+}
+
+// writeWideGamutColor writes the color operations if [color] is
+// the fallback of a wide gamut color, returning false otherwise
+func (g *group) writeWideGamutColor(color parser.RGBA, stroke bool) bool {
+	w := g.findWideGamut(color)
+	if w == nil {
+		return false
+	}
+	name, components := w.pdfColor()
+	g.useColorSpace(name)
+	if stroke {
+		g.app.Ops(cs.OpSetStrokeColorSpace{ColorSpace: name}, cs.OpSetStrokeColorN{Color: components})
+	} else {
+		g.app.Ops(cs.OpSetFillColorSpace{ColorSpace: name}, cs.OpSetFillColorN{Color: components})
+	}
+	return true
+}
+
+// oklchSteps is the number of intervals used to
+// approximate the interpolation in Oklch
+const oklchSteps = 8
+
+// wideGamutGradient converts the colors of [grad] if one of [colors]
+// is the fallback of a wide gamut color, and returns the color space
+// of the gradient, or nil.
+// The colors are interpolated in Display P3 if all the wide gamut colors use
+// it, in Oklch (with additional stops) if they all use Oklch, or in Lab.
+func (g *group) wideGamutGradient(grad *cs.GradientComplex, colors []parser.RGBA) model.ColorSpace {
+	if len(g.options.WideGamutColors) == 0 || g.cmykOutput() || g.grayOutput() != nil {
+		return nil
+	}
+	wides := make([]*WideGamutColor, len(colors))
+	var space GamutSpace // 0 for mixed spaces
+	for i, color := range colors {
+		wides[i] = g.findWideGamut(color)
+		if w := wides[i]; w != nil {
+			if space == 0 || space == w.Space {
+				space = w.Space
+			} else {
+				space = CIELab
+			}
+		}
+	}
+	if space == 0 {
+		return nil
+	}
+
+	switch space {
+	case DisplayP3:
+		for i, color := range colors {
+			c := [3]fl{}
+			if w := wides[i]; w != nil {
+				c = w.Components
+			} else {
+				p3 := xyzToP3(srgbToXYZ(color))
+				c = [3]fl{fl(p3[0]), fl(p3[1]), fl(p3[2])}
+			}
+			grad.Colors[i] = [4]fl{c[0], c[1], c[2], color.A}
+		}
+		g.useColorSpace("DisplayP3")
+		return g.displayP3()
+	case OkLCh:
+		lchs := make([]vec3, len(colors))
+		for i, color := range colors {
+			if w := wides[i]; w != nil {
+				lchs[i] = vec3{float64(w.Components[0]), float64(w.Components[1]), float64(w.Components[2])}
+			} else {
+				lchs[i] = srgbToOklch(color)
+			}
+		}
+		var (
+			offsets []fl
+			stops   [][4]fl
+		)
+		for i := range colors {
+			if i == 0 {
+				continue
+			}
+			start, end := lchs[i-1], lchs[i]
+			fixHues(&start, &end)
+			for step := 0; step <= oklchSteps; step++ {
+				if step == 0 && i != 1 {
+					continue // already added
+				}
+				t := float64(step) / oklchSteps
+				var lch vec3
+				for k := range lch {
+					lch[k] = start[k] + t*(end[k]-start[k])
+				}
+				lab := xyzToLab(oklchToXYZ(lch))
+				alpha := colors[i-1].A + fl(t)*(colors[i].A-colors[i-1].A)
+				offsets = append(offsets, grad.Offsets[i-1]+fl(t)*(grad.Offsets[i]-grad.Offsets[i-1]))
+				stops = append(stops, [4]fl{fl(lab[0]), fl(lab[1]), fl(lab[2]), alpha})
+			}
+		}
+		if len(colors) == 1 {
+			lab := xyzToLab(oklchToXYZ(lchs[0]))
+			offsets, stops = grad.Offsets, [][4]fl{{fl(lab[0]), fl(lab[1]), fl(lab[2]), colors[0].A}}
+		}
+		grad.Offsets, grad.Colors = offsets, stops
+	default:
+		for i, color := range colors {
+			var lab vec3
+			if w := wides[i]; w != nil {
+				_, c := w.pdfColor()
+				lab = vec3{float64(c[0]), float64(c[1]), float64(c[2])}
+			} else {
+				lab = xyzToLab(srgbToXYZ(color))
+			}
+			grad.Colors[i] = [4]fl{fl(lab[0]), fl(lab[1]), fl(lab[2]), color.A}
+		}
+	}
+	g.useColorSpace("Lab")
+	return labColorSpace
+}
+
+// fixHues uses the shorter arc between the hues,
+// and ignores the hue of achromatic colors
+func fixHues(start, end *vec3) {
+	const achromatic = 1e-4
+	if start[1] < achromatic {
+		start[2] = end[2]
+	} else if end[1] < achromatic {
+		end[2] = start[2]
+	}
+	if d := end[2] - start[2]; d > 180 {
+		start[2] += 360
+	} else if d < -180 {
+		end[2] += 360
+	}
+}
@@ -0,0 +1,202 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/webrender/backend"
+	"github.com/benoitkugler/webrender/css/parser"
+)
+
+func TestParseWideGamutColors(t *testing.T) {
+	colors, err := ParseWideGamutColors(`p {
+		color: #f00;
+		color: color(display-p3 1 0 0);
+		background: linear-gradient(to right, #00f, white);
+		background: linear-gradient(to right, oklch(45% 0.31 0.73turn / 0.5), white);
+		border-color: green;
+		border-color: lab(46 -52 50) lab(50 0 0);
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(colors) != 2 {
+		t.Fatalf("expected 2 colors, got %v", colors)
+	}
+	if c := colors[0]; c.Space != DisplayP3 || c.Components != [3]fl{1, 0, 0} || c.Fallback != (parser.RGBA{R: 1, A: 1}) {
+		t.Fatalf("unexpected color %v", c)
+	}
+	if c := colors[1]; c.Space != OkLCh || c.Fallback != (parser.RGBA{B: 1, A: 1}) ||
+		math.Abs(float64(c.Components[0]-0.45)) > 1e-6 || math.Abs(float64(c.Components[2]-262.8)) > 1e-3 {
+		t.Fatalf("unexpected color %v", c)
+	}
+
+	if _, err = ParseWideGamutColors("color: red; color: lab(50 0);"); err == nil {
+		t.Fatal("expected error for invalid lab()")
+	}
+}
+
+func TestColorConversions(t *testing.T) {
+	closeTo := func(a, b vec3, tol float64) bool {
+		for i := range a {
+			if math.Abs(a[i]-b[i]) > tol {
+				return false
+			}
+		}
+		return true
+	}
+	if lab := xyzToLab(srgbToXYZ(parser.RGBA{R: 1, G: 1, B: 1, A: 1})); !closeTo(lab, vec3{100, 0, 0}, 0.01) {
+		t.Fatalf("unexpected white %v", lab)
+	}
+	if lab := xyzToLab(srgbToXYZ(parser.RGBA{R: 1, A: 1})); !closeTo(lab, vec3{54.29, 80.8, 69.89}, 0.05) {
+		t.Fatalf("unexpected red %v", lab)
+	}
+	red := srgbToOklch(parser.RGBA{R: 1, A: 1})
+	if !closeTo(red, vec3{0.628, 0.2577, 29.234}, 1e-3) {
+		t.Fatalf("unexpected oklch %v", red)
+	}
+	if xyz := oklchToXYZ(red); !closeTo(xyz, srgbToXYZ(parser.RGBA{R: 1, A: 1}), 1e-4) {
+		t.Fatalf("unexpected round trip %v", xyz)
+	}
+	// sRGB red is inside P3
+	if p3 := xyzToP3(srgbToXYZ(parser.RGBA{R: 1, A: 1})); !closeTo(p3, vec3{0.9175, 0.2003, 0.1387}, 1e-3) {
+		t.Fatalf("unexpected P3 %v", p3)
+	}
+}
+
+func TestDisplayP3Profile(t *testing.T) {
+	profile := displayP3Profile()
+	if int(binary.BigEndian.Uint32(profile)) != len(profile) || string(profile[36:40]) != "acsp" || string(profile[16:20]) != "RGB " {
+		t.Fatal("invalid profile header")
+	}
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		entry := profile[132+12*i:]
+		offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		if offset%4 != 0 || int(offset+size) > len(profile) {
+			t.Fatalf("invalid tag %s", entry[:4])
+		}
+		if sig := string(entry[:4]); sig == "rXYZ" {
+			if tag := profile[offset:]; string(tag[:4]) != "XYZ " {
+				t.Fatalf("invalid tag type %s", tag[:4])
+			}
+		}
+	}
+}
+
+func TestWideGamutColors(t *testing.T) {
+	output := NewOutput()
+	output.Options.WideGamutColors = []WideGamutColor{
+		{Space: DisplayP3, Components: [3]fl{1, 0, 0}, Fallback: parser.RGBA{R: 1, A: 1}},
+		{Space: OkLCh, Components: [3]fl{0.45, 0.31, 264}, Fallback: parser.RGBA{B: 1, A: 1}},
+	}
+	page := output.AddPage(0, 0, 100, 100)
+	if len(output.cache.iccSpaces) != 0 {
+		t.Fatal("the Display P3 profile should be built on first use")
+	}
+	page.State().SetColorRgba(parser.RGBA{R: 1, A: 1}, false)
+	page.Rectangle(0, 0, 10, 10)
+	page.Paint(backend.FillNonZero)
+	page.DrawGradient(backend.GradientLayout{
+		ScaleY:       1,
+		GradientKind: backend.GradientKind{Kind: "linear", Coords: [6]fl{0, 0, 100, 0}},
+		Positions:    []fl{0, 1},
+		Colors:       []parser.RGBA{{B: 1, A: 1}, {R: 1, G: 1, B: 1, A: 1}},
+	}, 100, 100)
+
+	doc, err := output.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageObj := doc.Catalog.Pages.Kids[0].(*model.PageObject)
+	if p3, ok := pageObj.Resources.ColorSpace["DisplayP3"].(*model.ColorSpaceICCBased); !ok || p3.N != 3 {
+		t.Fatalf("expected an ICC based color space, got %v", pageObj.Resources.ColorSpace)
+	}
+	if _, ok := pageObj.Resources.ColorSpace["Lab"].(model.ColorSpaceLab); !ok {
+		t.Fatalf("expected a Lab color space, got %v", pageObj.Resources.ColorSpace)
+	}
+	var shading *model.ShadingDict
+	for _, sh := range pageObj.Resources.Shading {
+		shading = sh
+	}
+	if _, ok := shading.ColorSpace.(model.ColorSpaceLab); !ok {
+		t.Fatalf("expected a Lab shading, got %v", shading.ColorSpace)
+	}
+	// interpolation in oklch
+	stitching := shading.ShadingType.(model.ShadingAxial).Function[0].FunctionType.(model.FunctionStitching)
+	if len(stitching.Functions) != oklchSteps {
+		t.Fatalf("unexpected number of stops %d", len(stitching.Functions))
+	}
+
+	content, err := pageObj.Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "/DisplayP3 cs") || !strings.Contains(string(content), "1 0 0 scn") {
+		t.Fatalf("unexpected content %s", content)
+	}
+}
+
+func TestAddWideGamutFallbacks(t *testing.T) {
+	css, err := AddWideGamutFallbacks(`@import url(print.css);
+	p {
+		color: color(display-p3 1 0 0);
+		background: linear-gradient(to right, lab(54.29 80.8 69.89), white);
+		border-color: green;
+		border-color: lab(46 -52 50);
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(css, "@import url(print.css);") || strings.Count(css, "border-color") != 2 {
+		t.Fatalf("unexpected stylesheet %s", css)
+	}
+	colors, err := ParseWideGamutColors(css)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(colors) != 3 {
+		t.Fatalf("expected 3 colors, got %v %s", colors, css)
+	}
+	// Display P3 red is clipped; the Lab value is sRGB red.
+	// The fallbacks are unique values, close to #ff0000
+	for _, c := range colors[:2] {
+		if c.Fallback == (parser.RGBA{R: 1, A: 1}) || 1-c.Fallback.R >= 1./255 || c.Fallback.G >= 1./255 || c.Fallback.B >= 1./255 {
+			t.Fatalf("unexpected fallback %v in %s", c.Fallback, css)
+		}
+	}
+	if colors[0].Fallback == colors[1].Fallback {
+		t.Fatalf("expected unique fallbacks, got %s", css)
+	}
+	if colors[2].Fallback != (parser.RGBA{G: 128. / 255, A: 1}) {
+		t.Fatalf("the existing fallback should be kept, got %v", colors[2].Fallback)
+	}
+
+	if _, err = AddWideGamutFallbacks("color: lab(50 0);"); err == nil {
+		t.Fatal("expected error for invalid lab()")
+	}
+}
+
+func TestWideGamutUndeclaredColor(t *testing.T) {
+	css, err := AddWideGamutFallbacks(`.brand { background-color: color(display-p3 1 0 0) }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors, err := ParseWideGamutColors(css)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second element uses the sRGB red, without declaring a wide gamut color
+	doc := htmlToModelOptions(t, `<style>@page { size: 100px 100px; margin: 0 } div { height: 10px }`+css+`</style>
+		<div class="brand"></div><div style="background-color: #ff0000"></div>`, 1, ".", nil, Options{WideGamutColors: colors})
+	content, err := doc.Catalog.Pages.Kids[0].(*model.PageObject).Contents[0].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(content), "/DisplayP3 cs") != 1 || !strings.Contains(string(content), "1 0 0 rg") {
+		t.Fatalf("expected one Display P3 color, got %s", content)
+	}
+}
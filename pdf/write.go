@@ -0,0 +1,297 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/benoitkugler/pdf/model"
+	"github.com/benoitkugler/pdf/reader/file"
+)
+
+// Write finalizes the document and writes it in [w].
+// It should be preferred to [Output.Finalize] followed by [model.Document.Write],
+// since some options (like [Options.OutputIntent]) require entries
+// not supported by the model, which are added to the file in a second pass.
+func (c *Output) Write(w io.Writer) error {
+	doc, err := c.Finalize()
+	if err != nil {
+		return err
+	}
+	return c.writeDocument(doc, w)
+}
+
+// writeDocument writes [doc], adding the entries required by the options.
+//
+// The model has no support for these entries (output intents, optional content,
+// overprint states, object streams, linearization...) and its serializer is not exported:
+// the file written by [model.Document.Write] is parsed back, modified and serialized again.
+// Since this doubles the cost of writing, it is only done when required (see [Output.needsRewrite]).
+// The only trailer entries are /Root and /Info (the model only writes /ID with encryption),
+// and the file identifier is always written.
+func (c *Output) writeDocument(doc model.Document, w io.Writer) error {
+	if !c.needsRewrite() {
+		return doc.Write(w, nil)
+	}
+
+	var buf bytes.Buffer
+	if err := doc.Write(&buf, nil); err != nil {
+		return err
+	}
+	raw, err := parseRawPDF(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("invalid PDF output: %s", err)
+	}
+	if doc.Trailer.ID[0] != "" {
+		raw.id = doc.Trailer.ID
+	}
+	if err = c.rewrite(&raw); err != nil {
+		return err
+	}
+	version := c.Options.Version
+	switch {
+	case c.Options.Linearize:
+		return raw.writeLinearized(w, version)
+	case c.Options.ObjectStreams && version.pdf15():
+		return raw.writeCompressed(w, version)
+	default:
+		return raw.write(w, version)
+	}
+}
+
+// needsRewrite returns true if the file written by
+// [model.Document.Write] must be modified
+func (c *Output) needsRewrite() bool {
+	return c.Options.OutputIntent != nil || c.Options.Version != PDF17 || c.Options.ObjectStreams || c.Options.Linearize ||
+		len(c.cache.overprints) != 0 || c.hasLayers()
+}
+
+// rewrite adds the entries not supported by the model
+func (c *Output) rewrite(raw *rawPDF) error {
+	if err := c.addOutputIntent(raw); err != nil {
+		return err
+	}
+	c.addOverprints(raw)
+	c.addLayers(raw)
+	return nil
+}
+
+// addOutputIntent registers [Options.OutputIntent] in the catalog
+func (c *Output) addOutputIntent(raw *rawPDF) error {
+	oi := c.Options.OutputIntent
+	if oi == nil {
+		return nil
+	}
+	if oi.Subtype == "" {
+		return errors.New("missing output intent subtype")
+	}
+	catalog := raw.catalog()
+	catalog["OutputIntents"] = model.ObjArray{raw.add(oi.pdfObject(raw))}
+	return nil
+}
+
+// rawPDF is a PDF file stored as a list of objects,
+// used to modify the output of [model.Document.Write]
+type rawPDF struct {
+	objects map[int]model.Object
+	root    int
+	info    int       // 0 if absent
+	id      [2]string // file identifier, always written
+}
+
+// parseRawPDF parses [content], using its hash as file
+// identifier if it has none
+func parseRawPDF(content []byte) (rawPDF, error) {
+	f, err := file.Read(bytes.NewReader(content), nil)
+	if err != nil {
+		return rawPDF{}, err
+	}
+	out := rawPDF{objects: f.XrefTable, root: f.Root.ObjectNumber, id: f.ID}
+	if f.Info != nil {
+		out.info = f.Info.ObjectNumber
+	}
+	if out.id[0] == "" {
+		out.id = newFileID(content)
+	}
+	return out, nil
+}
+
+// newFileID returns a file identifier computed from [content],
+// used for the two parts of the /ID array of new files
+func newFileID(content []byte) [2]string {
+	sum := md5.Sum(content)
+	return [2]string{string(sum[:]), string(sum[:])}
+}
+
+// trailerID returns the /ID entry of the trailer, required by PDF 2.0
+func (raw rawPDF) trailerID() string {
+	return fmt.Sprintf("/ID [<%x> <%x>]\n", raw.id[0], raw.id[1])
+}
+
+// catalog returns the root dictionary, which may be modified in place
+func (raw *rawPDF) catalog() model.ObjDict {
+	catalog, _ := raw.objects[raw.root].(model.ObjDict)
+	if catalog == nil { // should not happen
+		catalog = model.ObjDict{"Type": model.ObjName("Catalog")}
+		raw.objects[raw.root] = catalog
+	}
+	return catalog
+}
+
+// resolve returns the object referenced by [o], or [o] itself
+func (raw *rawPDF) resolve(o model.Object) model.Object {
+	return file.XrefTable(raw.objects).ResolveObject(o)
+}
+
+// add stores [obj] as a new indirect object
+func (raw *rawPDF) add(obj model.Object) model.ObjIndirectRef {
+	number := 1
+	for n := range raw.objects {
+		if n >= number {
+			number = n + 1
+		}
+	}
+	raw.objects[number] = obj
+	return model.ObjIndirectRef{ObjectNumber: number}
+}
+
+// write serializes the objects, with a new cross-reference table
+func (raw rawPDF) write(w io.Writer, version Version) error {
+	numbers := make([]int, 0, len(raw.objects))
+	for n := range raw.objects {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	out := &rawWriter{next: numbers[len(numbers)-1] + 1}
+	out.buf.WriteString(version.header())
+	for _, n := range numbers {
+		out.writeObject(n, raw.objects[n])
+	}
+	for len(out.pending) != 0 { // objects created while writing
+		pending := out.pending
+		out.pending = nil
+		for _, p := range pending {
+			out.writeIndirect(p.number, p.header, p.content, p.isStream)
+		}
+	}
+
+	size := out.next
+	xref := out.buf.Len()
+	fmt.Fprintf(&out.buf, "xref\n0 %d\n0000000000 65535 f \n", size)
+	for n := 1; n < size; n++ {
+		if offset, ok := out.offsets[n]; ok {
+			fmt.Fprintf(&out.buf, "%010d 00000 n \n", offset)
+		} else {
+			out.buf.WriteString("0000000000 00000 f \n")
+		}
+	}
+	fmt.Fprintf(&out.buf, "trailer\n<<\n/Size %d\n/Root %d 0 R\n", size, raw.root)
+	if raw.info != 0 {
+		fmt.Fprintf(&out.buf, "/Info %d 0 R\n", raw.info)
+	}
+	out.buf.WriteString(raw.trailerID())
+	fmt.Fprintf(&out.buf, ">>\nstartxref\n%d\n%%%%EOF", xref)
+
+	_, err := w.Write(out.buf.Bytes())
+	return err
+}
+
+type pendingObject struct {
+	number   int
+	header   string
+	content  []byte
+	isStream bool
+}
+
+// rawWriter implements [model.PDFWritter], without encryption
+type rawWriter struct {
+	buf     bytes.Buffer
+	offsets map[int]int
+	next    int // next free object number
+	pending []pendingObject
+}
+
+func (w *rawWriter) EncodeString(s string, mode model.PDFStringEncoding, _ model.Reference) string {
+	switch mode {
+	case model.HexString:
+		return model.EspaceHexString([]byte(s))
+	case model.TextString:
+		return model.EscapeByteString([]byte(encodeTextString([]rune(s))))
+	default:
+		return model.EscapeByteString([]byte(s))
+	}
+}
+
+func (w *rawWriter) CreateObject() model.Reference {
+	ref := model.Reference(w.next)
+	w.next++
+	return ref
+}
+
+func (w *rawWriter) WriteObject(content string, ref model.Reference) {
+	w.pending = append(w.pending, pendingObject{number: int(ref), header: content})
+}
+
+func (w *rawWriter) WriteStream(header model.StreamHeader, stream []byte, ref model.Reference) {
+	header.Fields["Length"] = fmt.Sprint(len(stream))
+	w.pending = append(w.pending, pendingObject{number: int(ref), header: string(header.PDFContent()), content: stream, isStream: true})
+}
+
+func (w *rawWriter) writeIndirect(number int, header string, content []byte, isStream bool) {
+	if w.offsets == nil {
+		w.offsets = make(map[int]int)
+	}
+	w.offsets[number] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\n", number, header)
+	if isStream {
+		w.buf.WriteString("stream\n")
+		w.buf.Write(content)
+		w.buf.WriteString("\nendstream\n")
+	}
+	w.buf.WriteString("endobj\n")
+}
+
+// writeObject writes [obj] as the indirect object [number]
+func (w *rawWriter) writeObject(number int, obj model.Object) {
+	ref := model.Reference(number)
+	if stream, ok := obj.(model.ObjStream); ok {
+		args := stream.Args.Clone().(model.ObjDict)
+		args["Length"] = model.ObjInt(len(stream.Content))
+		w.writeIndirect(number, w.format(args, ref), stream.Content, true)
+		return
+	}
+	w.writeIndirect(number, w.format(obj, ref), nil, false)
+}
+
+// format is the same as [model.Object.Write], but sorts the dictionary keys
+// to produce a deterministic output.
+func (w *rawWriter) format(obj model.Object, ref model.Reference) string {
+	switch obj := obj.(type) {
+	case model.ObjDict:
+		keys := make([]model.Name, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		chunks := make([]string, len(keys))
+		for i, k := range keys {
+			chunks[i] = k.String() + " " + w.format(obj[k], ref)
+		}
+		return "<<" + strings.Join(chunks, " ") + ">>"
+	case model.ObjArray:
+		chunks := make([]string, len(obj))
+		for i, v := range obj {
+			chunks[i] = w.format(v, ref)
+		}
+		return "[" + strings.Join(chunks, " ") + "]"
+	case nil:
+		return "null"
+	default:
+		return obj.Write(w, ref)
+	}
+}
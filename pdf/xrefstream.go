@@ -0,0 +1,149 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/benoitkugler/pdf/model"
+)
+
+// Version is the PDF version of the files written by [Output.Write].
+type Version uint8
+
+const (
+	// PDF17 is the default version.
+	PDF17 Version = iota
+	// PDF14 does not use the features introduced by later versions, for older viewers :
+	// object streams, layers and /ActualText marked content (PDF 1.5) are not written,
+	// and the glyphs of OpenType fonts with CFF outlines, which can't be embedded before
+	// PDF 1.6, are drawn as paths.
+	PDF14
+	// PDF20 writes the same content as PDF17, with the trailer /ID
+	// required by PDF 2.0 (which is actually written for all versions).
+	PDF20
+)
+
+func (v Version) String() string {
+	switch v {
+	case PDF14:
+		return "1.4"
+	case PDF20:
+		return "2.0"
+	default:
+		return "1.7"
+	}
+}
+
+// header returns the first lines of the file, with a
+// comment marking the file as binary
+func (v Version) header() string {
+	return "%PDF-" + v.String() + "\n%\xc8\xc8\xc8\xc8\n"
+}
+
+// pdf15 returns true if the features introduced by PDF 1.5 may be used
+func (v Version) pdf15() bool { return v != PDF14 }
+
+// pdf16 returns true if the features introduced by PDF 1.6 may be used
+func (v Version) pdf16() bool { return v != PDF14 }
+
+// objectsPerStream is the maximum number of objects packed in an object stream
+const objectsPerStream = 100
+
+// writeCompressed serializes the objects, packing the ones which are not streams
+// in compressed object streams, and ends the file with a cross-reference stream.
+func (raw rawPDF) writeCompressed(w io.Writer, version Version) error {
+	numbers := make([]int, 0, len(raw.objects))
+	for n := range raw.objects {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	out := &rawWriter{next: numbers[len(numbers)-1] + 1}
+	out.buf.WriteString(version.header())
+	var packed []int
+	for _, n := range numbers {
+		if _, isStream := raw.objects[n].(model.ObjStream); isStream {
+			out.writeObject(n, raw.objects[n])
+		} else {
+			packed = append(packed, n)
+		}
+	}
+
+	compressed := make(map[int][2]int) // object stream and index, by object number
+	for start := 0; start < len(packed); start += objectsPerStream {
+		end := start + objectsPerStream
+		if end > len(packed) {
+			end = len(packed)
+		}
+		number := out.next
+		out.next++
+		var offsets, body bytes.Buffer
+		for i, n := range packed[start:end] {
+			fmt.Fprintf(&offsets, "%d %d ", n, body.Len())
+			body.WriteString(out.format(raw.objects[n], model.Reference(n)))
+			body.WriteByte('\n')
+			compressed[n] = [2]int{number, i}
+		}
+		stream := model.NewCompressedStream(append(offsets.Bytes(), body.Bytes()...))
+		out.writeObject(number, model.ObjStream{
+			Args: model.ObjDict{
+				"Type":   model.ObjName("ObjStm"),
+				"N":      model.ObjInt(end - start),
+				"First":  model.ObjInt(offsets.Len()),
+				"Filter": model.ObjName("FlateDecode"),
+			},
+			Content: stream.Content,
+		})
+	}
+	for len(out.pending) != 0 { // objects created while writing
+		pending := out.pending
+		out.pending = nil
+		for _, p := range pending {
+			out.writeIndirect(p.number, p.header, p.content, p.isStream)
+		}
+	}
+
+	// the cross-reference stream lists itself
+	xrefNumber := out.next
+	out.next++
+	size := out.next
+	xref := out.buf.Len()
+	entries := make([]byte, 0, 7*size)
+	for n := 0; n < size; n++ {
+		if n == xrefNumber {
+			entries = append(entries, 1)
+			entries = appendUint32(entries, uint32(xref))
+			entries = appendUint16(entries, 0)
+		} else if offset, ok := out.offsets[n]; ok {
+			entries = append(entries, 1)
+			entries = appendUint32(entries, uint32(offset))
+			entries = appendUint16(entries, 0)
+		} else if location, ok := compressed[n]; ok {
+			entries = append(entries, 2)
+			entries = appendUint32(entries, uint32(location[0]))
+			entries = appendUint16(entries, uint16(location[1]))
+		} else if n == 0 {
+			entries = append(entries, 0, 0, 0, 0, 0, 0xff, 0xff)
+		} else {
+			entries = append(entries, 0, 0, 0, 0, 0, 0, 0)
+		}
+	}
+	args := model.ObjDict{
+		"Type":   model.ObjName("XRef"),
+		"Size":   model.ObjInt(size),
+		"W":      model.ObjArray{model.ObjInt(1), model.ObjInt(4), model.ObjInt(2)},
+		"Root":   model.ObjIndirectRef{ObjectNumber: raw.root},
+		"ID":     model.ObjArray{model.ObjHexLiteral(raw.id[0]), model.ObjHexLiteral(raw.id[1])},
+		"Filter": model.ObjName("FlateDecode"),
+	}
+	if raw.info != 0 {
+		args["Info"] = model.ObjIndirectRef{ObjectNumber: raw.info}
+	}
+	out.writeObject(xrefNumber, model.ObjStream{Args: args, Content: model.NewCompressedStream(entries).Content})
+	fmt.Fprintf(&out.buf, "startxref\n%d\n%%%%EOF", xref)
+
+	_, err := w.Write(out.buf.Bytes())
+	return err
+}
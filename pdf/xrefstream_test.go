@@ -0,0 +1,126 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/benoitkugler/pdf/reader"
+	"github.com/benoitkugler/webrender/backend"
+)
+
+// fileID matches the /ID entry of the trailer
+var fileID = regexp.MustCompile(`/ID \[<[0-9a-f]{32}> <[0-9a-f]{32}>\]`)
+
+// writeLinks returns a document with many link annotations
+func writeLinks(t *testing.T, options Options) []byte {
+	t.Helper()
+	output := NewOutput()
+	output.Options = options
+	output.SetTitle("Links")
+	for i := 0; i < 2; i++ {
+		page := output.AddPage(0, 0, 200, 300)
+		page.SetMediaBox(0, 0, 200, 300)
+		for j := 0; j < 150; j++ {
+			page.AddExternalLink(0, fl(2*j), 50, 2, fmt.Sprintf("https://example.org/%d", j))
+		}
+	}
+	var buf bytes.Buffer
+	if err := output.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestObjectStreams(t *testing.T) {
+	plain := writeLinks(t, Options{})
+	compressed := writeLinks(t, Options{ObjectStreams: true})
+	if !bytes.Contains(compressed, []byte("/Type /ObjStm")) || !bytes.Contains(compressed, []byte("/Type /XRef")) {
+		t.Fatal("missing object and cross-reference streams")
+	}
+	if len(compressed) >= len(plain)/2 {
+		t.Fatalf("expected a smaller file, got %d bytes (instead of %d)", len(compressed), len(plain))
+	}
+
+	doc, _, err := reader.ParsePDFReader(bytes.NewReader(compressed), reader.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := doc.Catalog.Pages.Flatten()
+	if len(pages) != 2 || len(pages[1].Annots) != 150 || doc.Trailer.Info.Title != "Links" {
+		t.Fatalf("unexpected document %v", doc.Trailer.Info)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	layer := []Overlay{{Text: "Draft", Layer: "Watermark"}}
+	for _, test := range []struct {
+		options Options
+		header  string
+		layers  bool
+		objStm  bool
+	}{
+		{Options{Overlays: layer}, "%PDF-1.7", true, false},
+		{Options{Overlays: layer, Version: PDF14, ObjectStreams: true}, "%PDF-1.4", false, false},
+		{Options{Overlays: layer, Version: PDF20}, "%PDF-2.0", true, false},
+		{Options{Version: PDF20, ObjectStreams: true}, "%PDF-2.0", false, true},
+		{Options{Version: PDF20, ObjectStreams: true, Linearize: true}, "%PDF-2.0", false, false},
+	} {
+		content := writeLinks(t, test.options)
+		if !bytes.HasPrefix(content, []byte(test.header)) {
+			t.Fatalf("unexpected header %s", content[:10])
+		}
+		if got := bytes.Contains(content, []byte("/OCProperties")) || bytes.Contains(content, []byte("/Type /OCG")); got != test.layers {
+			t.Fatalf("for %v, unexpected layers %v", test.options, got)
+		}
+		if got := bytes.Contains(content, []byte("/Type /ObjStm")); got != test.objStm {
+			t.Fatalf("for %v, unexpected object streams %v", test.options, got)
+		}
+		if !fileID.Match(content) {
+			t.Fatalf("for %v, missing file identifier", test.options)
+		}
+		if _, _, err := reader.ParsePDFReader(bytes.NewReader(content), reader.Options{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVersionFeatures(t *testing.T) {
+	otf, err := os.ReadFile("../resources_test/weasyprint.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []Version{PDF17, PDF14} {
+		font := newTestFont("Test")
+		font.desc.IsOpentypeOpentype = true // CFF outlines
+		output := NewOutput()
+		output.Options.Version = version
+		page := output.AddPage(0, 0, 100, 100)
+		chars := page.AddFont(font, otf)
+		chars.Cmap[36], chars.Cmap[37] = []rune("A"), []rune("fi")
+		page.DrawText([]backend.TextDrawing{{
+			Runs:     []backend.TextRun{{Font: font, Glyphs: []backend.TextGlyph{{Glyph: 36}, {Glyph: 37}}}},
+			FontSize: 12,
+		}})
+		var buf bytes.Buffer
+		if err := output.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		doc, _, err := reader.ParsePDFReader(bytes.NewReader(buf.Bytes()), reader.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := doc.Catalog.Pages.Flatten()[0].DecodeAllContents()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hasSpan, hasOpenType := bytes.Contains(content, []byte("/Span")), bytes.Contains(buf.Bytes(), []byte("/OpenType"))
+		if version == PDF14 && (hasSpan || hasOpenType || bytes.Contains(content, []byte("Tf"))) {
+			t.Fatalf("unexpected PDF 1.5 or 1.6 features in %s", content)
+		} else if version == PDF17 && !(hasSpan && hasOpenType) {
+			t.Fatalf("missing actual text or embedded font in %s", content)
+		}
+	}
+}